@@ -0,0 +1,248 @@
+package main
+
+// CRC: crc-LuaREPL.md
+// Minimal line editor for the REPL: cursor movement, history and tab completion on a raw
+// terminal, plain line reading otherwise
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode"
+)
+
+// errInterrupted is returned by ReadLine when the user presses Ctrl-C
+var errInterrupted = errors.New("interrupted")
+
+// maxHistory is the number of entries kept in the history file
+const maxHistory = 1000
+
+// lineEditor reads lines from the terminal
+type lineEditor struct {
+	in          *bufio.Reader
+	out         io.Writer
+	raw         bool                                          // Terminal is in raw mode, so keys are edited here
+	restore     string                                        // stty settings to restore on Close
+	history     []string                                      // Oldest first
+	historyPath string                                        // Appended to as lines are entered
+	complete    func(text string) (start int, items []string) // Completions for the text before the cursor
+}
+
+// newLineEditor puts a terminal stdin into raw mode with stty; pipes and terminals stty cannot
+// configure are read a line at a time
+func newLineEditor(historyPath string, complete func(string) (int, []string)) *lineEditor {
+	e := &lineEditor{in: bufio.NewReader(os.Stdin), out: os.Stdout, historyPath: historyPath, complete: complete}
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		if saved, err := stty("-g"); err == nil {
+			if _, err := stty("-icanon", "-echo", "-isig", "min", "1", "time", "0"); err == nil {
+				e.raw, e.restore = true, strings.TrimSpace(saved)
+			}
+		}
+	}
+	if data, err := os.ReadFile(historyPath); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				e.history = append(e.history, line)
+			}
+		}
+		if len(e.history) > maxHistory {
+			e.history = e.history[len(e.history)-maxHistory:]
+		}
+	}
+	return e
+}
+
+// stty runs stty on the terminal
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// Close restores the terminal and rewrites the history file at its limit
+func (e *lineEditor) Close() {
+	if e.raw {
+		stty(e.restore)
+	}
+	if e.historyPath != "" && len(e.history) > 0 {
+		os.WriteFile(e.historyPath, []byte(strings.Join(e.history, "\n")+"\n"), 0644)
+	}
+}
+
+// AddHistory records an entered line and appends it to the history file, so a killed REPL
+// keeps its history
+func (e *lineEditor) AddHistory(line string) {
+	if strings.TrimSpace(line) == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+	if e.historyPath != "" {
+		if f, err := os.OpenFile(e.historyPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+			f.WriteString(line + "\n")
+			f.Close()
+		}
+	}
+}
+
+// ReadLine shows prompt and returns the entered line, io.EOF on Ctrl-D or end of input, or
+// errInterrupted on Ctrl-C
+func (e *lineEditor) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(e.out, prompt)
+	if !e.raw {
+		line, err := e.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	var buf []rune
+	cursor := 0
+	histIndex := len(e.history)
+	var pending []rune // The line being edited while browsing history
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(buf))
+		if back := len(buf) - cursor; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	setLine := func(line []rune) {
+		buf = append([]rune(nil), line...)
+		cursor = len(buf)
+		redraw()
+	}
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(buf), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if cursor < len(buf) {
+				buf = append(buf[:cursor], buf[cursor+1:]...)
+				redraw()
+			}
+		case 127, 8: // Backspace
+			if cursor > 0 {
+				buf = append(buf[:cursor-1], buf[cursor:]...)
+				cursor--
+				redraw()
+			}
+		case 1: // Ctrl-A
+			cursor = 0
+			redraw()
+		case 5: // Ctrl-E
+			cursor = len(buf)
+			redraw()
+		case 11: // Ctrl-K
+			buf = buf[:cursor]
+			redraw()
+		case 21: // Ctrl-U
+			buf = append([]rune(nil), buf[cursor:]...)
+			cursor = 0
+			redraw()
+		case '\t':
+			e.completeAt(&buf, &cursor)
+			redraw()
+		case 27: // Escape sequences: arrows, Home, End, Delete
+			if next, _, _ := e.in.ReadRune(); next != '[' && next != 'O' {
+				continue
+			}
+			key, _, _ := e.in.ReadRune()
+			switch key {
+			case 'A':
+				if histIndex > 0 {
+					if histIndex == len(e.history) {
+						pending = append([]rune(nil), buf...)
+					}
+					histIndex--
+					setLine([]rune(e.history[histIndex]))
+				}
+			case 'B':
+				if histIndex < len(e.history) {
+					histIndex++
+					if histIndex == len(e.history) {
+						setLine(pending)
+					} else {
+						setLine([]rune(e.history[histIndex]))
+					}
+				}
+			case 'C':
+				if cursor < len(buf) {
+					cursor++
+					redraw()
+				}
+			case 'D':
+				if cursor > 0 {
+					cursor--
+					redraw()
+				}
+			case 'H':
+				cursor = 0
+				redraw()
+			case 'F':
+				cursor = len(buf)
+				redraw()
+			case '3':
+				e.in.ReadRune() // '~'
+				if cursor < len(buf) {
+					buf = append(buf[:cursor], buf[cursor+1:]...)
+					redraw()
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				buf = append(buf[:cursor], append([]rune{r}, buf[cursor:]...)...)
+				cursor++
+				redraw()
+			}
+		}
+	}
+}
+
+// completeAt inserts the completion for the word before the cursor: the whole name when there
+// is one candidate, their common prefix otherwise, listing them when that adds nothing
+func (e *lineEditor) completeAt(buf *[]rune, cursor *int) {
+	if e.complete == nil {
+		return
+	}
+	text := string((*buf)[:*cursor])
+	start, items := e.complete(text)
+	if len(items) == 0 || start > len(text) {
+		return
+	}
+	typed := text[start:]
+	common := items[0]
+	for _, item := range items[1:] {
+		for !strings.HasPrefix(item, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(common) > len(typed) {
+		insert := []rune(common[len(typed):])
+		*buf = append((*buf)[:*cursor], append(insert, (*buf)[*cursor:]...)...)
+		*cursor += len(insert)
+		return
+	}
+	if len(items) > 1 {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(items, "  "))
+	}
+}
@@ -5,9 +5,13 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -44,6 +48,14 @@ func main() {
 			if command == "theme" {
 				return true, runTheme(args)
 			}
+			// Handle audit command (file-based, no server needed)
+			if command == "audit" {
+				return true, runAudit(args)
+			}
+			// Handle repl command (connects to a running server)
+			if command == "repl" {
+				return true, runREPL(args)
+			}
 			return false, 0
 		},
 		CustomHelp: func() string {
@@ -53,6 +65,8 @@ Commands:
   serve           Start standalone server with HTTP UI and MCP endpoints
   install         Install skills and resources (without starting server)
   theme           Theme management (list, classes, audit)
+  audit           Audit an app for code quality violations
+  repl            Interactive Lua prompt in the running server's session
 
 Examples:
   frictionless mcp                                        Start MCP server (default: --dir .ui)
@@ -61,7 +75,16 @@ Examples:
   frictionless install --force                            Force reinstall even if up to date
   frictionless theme list                                 List available themes
   frictionless theme classes [THEME]                      Show semantic classes for a theme
-  frictionless theme audit APP [THEME]                    Audit app's theme class usage`
+  frictionless theme audit APP [THEME]                    Audit app's theme class usage
+  frictionless theme validate [THEME]                     Check a theme against the variable schema
+  frictionless theme new NAME --accent COLOR [--from THEME] [--light]  Generate a theme from a seed color
+  frictionless theme set [NAME] [--auto|--fixed]          Set the theme and switch connected browsers
+  frictionless theme install ZIP|DIR [--force]            Install a theme package with its fonts and images
+  frictionless theme export NAME [-o FILE]                Write a theme package (default NAME.zip)
+  frictionless audit APP                                  Audit an app for code quality violations
+  frictionless audit APP --fix [--checkpoint]             Rewrite mechanical viewdef violations in place
+  frictionless audit --all                                Audit every app and print a summary table
+  frictionless repl [--profile readonly]                  Lua prompt with locals, completion and history`
 		},
 		CustomVersion: func() string {
 			return "frictionless " + Version
@@ -91,12 +114,12 @@ func runMCP(args []string) int {
 		cfg.Server.Dir = ".ui"
 	}
 
-	// Track the current log file for reopening after log clearing
+	// Track the current log file for reopening after log rotation
 	var currentLogFile *os.File
 	var logFileMu sync.Mutex
 
 	// openLogFile opens (or reopens) the Go log file
-	// Spec: mcp.md Section 5.1 - reopening Go log handles after clearing
+	// Spec: mcp.md Section 5.1 - reopening Go log handles after rotation
 	openLogFile := func() {
 		logFileMu.Lock()
 		defer logFileMu.Unlock()
@@ -144,13 +167,13 @@ func runMCP(args []string) int {
 		return 1
 	}
 
-	// Set callback to reopen Go log file after logs are cleared
-	// Spec: mcp.md Section 5.1 - ui_configure clears logs
+	// Set callback to reopen Go log file after logs are rotated
+	// Spec: mcp.md Section 5.1 - ui_configure rotates logs
 	if logToFile {
 		mcpServer.SetOnClearLogs(openLogFile)
 	}
 
-	// Configure AFTER SetOnClearLogs so log file can be reopened after ClearLogs()
+	// Configure AFTER SetOnClearLogs so log file can be reopened after RotateLogs()
 	// Spec: mcp.md Section 3.1 - Server auto-starts
 	if cfg.Server.Dir != "" {
 		if err := mcpServer.Configure(cfg.Server.Dir); err != nil {
@@ -220,7 +243,7 @@ func newMCPServer(cfg *cli.Config, fn func(p int) (string, error)) *mcp.Server {
 	})
 
 	// Note: Configure() is called by runMCP AFTER SetOnClearLogs is set,
-	// so the log file can be reopened after ClearLogs() deletes it.
+	// so the log file can be reopened after RotateLogs() archives it.
 	// StartAndCreateSession is also called AFTER newMCPServer returns
 	// to avoid nil pointer in startFunc closure.
 
@@ -327,12 +350,9 @@ func printThemeClasses(classes []mcp.ThemeClass) {
 	}
 }
 
-// runTheme handles theme management commands (file-based, no server needed).
-func runTheme(args []string) int {
-	// Default dir to .ui
+// parseDirFlag extracts --dir from args (defaults to .ui) and returns the remaining args.
+func parseDirFlag(args []string) (string, []string) {
 	baseDir := ".ui"
-
-	// Parse --dir flag
 	var filteredArgs []string
 	for i := 0; i < len(args); i++ {
 		if args[i] == "--dir" && i+1 < len(args) {
@@ -344,12 +364,127 @@ func runTheme(args []string) int {
 			filteredArgs = append(filteredArgs, args[i])
 		}
 	}
+	return baseDir, filteredArgs
+}
+
+// runAudit audits an app, optionally fixing mechanical viewdef violations first.
+// CRC: crc-Auditor.md
+func runAudit(args []string) int {
+	baseDir, filteredArgs := parseDirFlag(args)
+
+	fix := false
+	checkpoint := false
+	all := false
+	var positional []string
+	for _, arg := range filteredArgs {
+		switch arg {
+		case "--fix":
+			fix = true
+		case "--checkpoint":
+			checkpoint = true
+		case "--all":
+			all = true
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	if all && len(positional) == 0 && !fix {
+		project, err := mcp.AuditAllApps(baseDir, nil, false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error auditing apps: %v\n", err)
+			return 1
+		}
+		fmt.Print(mcp.FormatAuditTable(project))
+		if project.Summary.Violations > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: frictionless audit APP [--fix] [--checkpoint] [--dir DIR]")
+		fmt.Fprintln(os.Stderr, "       frictionless audit --all [--dir DIR]")
+		return 1
+	}
+	app := positional[0]
+
+	if fix {
+		if checkpoint {
+			msg, err := mcp.CreateCheckpoint(baseDir, app, "before audit fix")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating checkpoint: %v\n", err)
+				return 1
+			}
+			fmt.Println(msg)
+		}
+		fixResult, err := mcp.FixApp(baseDir, app)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error fixing app: %v\n", err)
+			return 1
+		}
+		if len(fixResult.Fixes) == 0 {
+			fmt.Println("No fixable violations")
+		} else {
+			fmt.Printf("Fixed %d violation(s) in %s\n\n", len(fixResult.Fixes), strings.Join(fixResult.Files, ", "))
+			fmt.Print(fixResult.Diff)
+		}
+		if len(fixResult.Unfixed) > 0 {
+			fmt.Printf("\nLeft %d violation(s) to fix by hand:\n", len(fixResult.Unfixed))
+			for _, v := range fixResult.Unfixed {
+				fmt.Printf("  %s (%s): %s\n", v.Type, v.Location, v.Detail)
+			}
+		}
+		fmt.Println()
+	}
+
+	result, err := mcp.AuditApp(baseDir, app)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error auditing app: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("App: %s\n", result.App)
+	fmt.Printf("Summary: %d methods, %d dead, %d viewdef violations\n",
+		result.Summary.TotalMethods, result.Summary.DeadMethods, result.Summary.ViewdefViolations)
+	if len(result.Violations) > 0 {
+		fmt.Println("\nViolations:")
+		for _, v := range result.Violations {
+			fmt.Printf("  %s (%s): %s\n", v.Type, v.Location, v.Detail)
+		}
+	}
+	if len(result.Warnings) > 0 {
+		fmt.Println("\nWarnings:")
+		for _, v := range result.Warnings {
+			fmt.Printf("  %s (%s): %s\n", v.Type, v.Location, v.Detail)
+		}
+	}
+
+	if len(result.Violations) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runTheme handles theme management commands (file-based, no server needed).
+func runTheme(args []string) int {
+	baseDir, filteredArgs := parseDirFlag(args)
 
 	if len(filteredArgs) == 0 {
-		fmt.Fprintln(os.Stderr, "Usage: frictionless theme <list|classes|audit> [options]")
+		fmt.Fprintln(os.Stderr, "Usage: frictionless theme <list|classes|audit|validate|new|set|install|export> [options]")
 		fmt.Fprintln(os.Stderr, "  theme list              List available themes")
 		fmt.Fprintln(os.Stderr, "  theme classes [THEME]   Show semantic classes for a theme")
 		fmt.Fprintln(os.Stderr, "  theme audit APP [THEME] Audit app's theme class usage")
+		fmt.Fprintln(os.Stderr, "  theme validate [THEME]  Check a theme against the variable schema")
+		fmt.Fprintln(os.Stderr, "  theme new NAME --accent COLOR [--from THEME] [--light]")
+		fmt.Fprintln(os.Stderr, "                          Generate a theme from a seed color")
+		fmt.Fprintln(os.Stderr, "  theme set [NAME] [--auto|--fixed]")
+		fmt.Fprintln(os.Stderr, "                          Set the theme and switch connected browsers;")
+		fmt.Fprintln(os.Stderr, "                          --auto follows the system light/dark setting using the theme's @pair")
+		fmt.Fprintln(os.Stderr, "  theme install ZIP|DIR [--force]")
+		fmt.Fprintln(os.Stderr, "                          Install a theme package (NAME.css with fonts and images under NAME/)")
+		fmt.Fprintln(os.Stderr, "  theme export NAME [-o FILE]")
+		fmt.Fprintln(os.Stderr, "                          Write a theme and its assets to a zip package (default NAME.zip)")
 		return 1
 	}
 
@@ -368,6 +503,9 @@ func runTheme(args []string) int {
 		if current != "" {
 			fmt.Printf("Current: %s\n", current)
 		}
+		if user := mcp.UserThemes(baseDir); len(user) > 0 {
+			fmt.Printf("User-installed: %s\n", strings.Join(user, ", "))
+		}
 		return 0
 
 	case "classes":
@@ -415,7 +553,7 @@ func runTheme(args []string) int {
 		if len(result.UndocumentedClasses) > 0 {
 			fmt.Println("\nUndocumented classes:")
 			for _, c := range result.UndocumentedClasses {
-				fmt.Printf("  .%s (%s:%d)\n", c.Class, c.File, c.Line)
+				fmt.Printf("  .%s (%s:%d, %s)\n", c.Class, c.File, c.Line, c.Source)
 			}
 		}
 
@@ -426,15 +564,261 @@ func runTheme(args []string) int {
 			}
 		}
 
+		if len(result.ContrastIssues) > 0 {
+			fmt.Println("\nContrast issues:")
+			for _, c := range result.ContrastIssues {
+				fmt.Printf("  %s: %s on %s is %.2f:1 (minimum %.1f:1)\n", c.Theme, c.Foreground, c.Background, c.Ratio, c.Minimum)
+			}
+		}
+
+		return 0
+
+	case "validate":
+		theme := mcp.GetCurrentTheme(baseDir)
+		if len(actionArgs) > 0 {
+			theme = actionArgs[0]
+		}
+		result, err := mcp.ValidateTheme(baseDir, theme)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error validating theme: %v\n", err)
+			return 1
+		}
+
+		fmt.Printf("Theme: %s\n", result.Theme)
+		for _, name := range result.MissingVariables {
+			fmt.Printf("  missing variable: %s\n", name)
+		}
+		for _, name := range result.ExtraVariables {
+			fmt.Printf("  extra variable: %s\n", name)
+		}
+		for _, v := range result.InvalidValues {
+			fmt.Printf("  invalid value: %s: %s\n", v.Name, v.Value)
+		}
+		for _, class := range result.UndocumentedClasses {
+			fmt.Printf("  undocumented class: .%s (no @class entry)\n", class)
+		}
+		if !result.Valid {
+			return 1
+		}
+		fmt.Println("OK")
+		return 0
+
+	case "new":
+		// CRC: crc-ThemeManager.md | Seq: seq-theme-new.md
+		var name, accent string
+		from := mcp.GetCurrentTheme(baseDir)
+		light := false
+		for i := 0; i < len(actionArgs); i++ {
+			switch arg := actionArgs[i]; {
+			case arg == "--light":
+				light = true
+			case (arg == "--accent" || arg == "--from") && i+1 < len(actionArgs):
+				i++
+				if arg == "--accent" {
+					accent = actionArgs[i]
+				} else {
+					from = actionArgs[i]
+				}
+			case strings.HasPrefix(arg, "--accent="):
+				accent = strings.TrimPrefix(arg, "--accent=")
+			case strings.HasPrefix(arg, "--from="):
+				from = strings.TrimPrefix(arg, "--from=")
+			case name == "" && !strings.HasPrefix(arg, "--"):
+				name = arg
+			default:
+				fmt.Fprintf(os.Stderr, "Unexpected argument: %s\n", arg)
+				return 1
+			}
+		}
+		if name == "" || accent == "" {
+			fmt.Fprintln(os.Stderr, "Usage: frictionless theme new NAME --accent COLOR [--from THEME] [--light]")
+			return 1
+		}
+
+		path, err := mcp.GenerateTheme(baseDir, name, from, accent, light)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating theme: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Created %s\n", path)
+		if err := mcp.InjectThemeBlock(baseDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to update index.html theme block: %v\n", err)
+		}
+		return 0
+
+	case "install":
+		// CRC: crc-ThemeManager.md | Seq: seq-theme-package.md
+		var src string
+		force := false
+		for _, arg := range actionArgs {
+			switch {
+			case arg == "--force":
+				force = true
+			case src == "" && !strings.HasPrefix(arg, "--"):
+				src = arg
+			default:
+				fmt.Fprintf(os.Stderr, "Unexpected argument: %s\n", arg)
+				return 1
+			}
+		}
+		if src == "" {
+			fmt.Fprintln(os.Stderr, "Usage: frictionless theme install ZIP|DIR [--force]")
+			return 1
+		}
+
+		result, err := mcp.InstallThemePackage(baseDir, src, force)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error installing theme: %v\n", err)
+			return 1
+		}
+		verb := "Installed"
+		if result.Replaced {
+			verb = "Replaced"
+		}
+		fmt.Printf("%s theme %s (%d files)\n", verb, result.Theme, len(result.Files))
+		for _, w := range result.Warnings {
+			fmt.Printf("  Warning: %s\n", w)
+		}
+		if err := mcp.InjectThemeBlock(baseDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to update index.html theme block: %v\n", err)
+		}
+		return 0
+
+	case "export":
+		// CRC: crc-ThemeManager.md | Seq: seq-theme-package.md
+		var name, dest string
+		for i := 0; i < len(actionArgs); i++ {
+			switch arg := actionArgs[i]; {
+			case (arg == "-o" || arg == "--output") && i+1 < len(actionArgs):
+				i++
+				dest = actionArgs[i]
+			case strings.HasPrefix(arg, "--output="):
+				dest = strings.TrimPrefix(arg, "--output=")
+			case name == "" && !strings.HasPrefix(arg, "-"):
+				name = arg
+			default:
+				fmt.Fprintf(os.Stderr, "Unexpected argument: %s\n", arg)
+				return 1
+			}
+		}
+		if name == "" {
+			fmt.Fprintln(os.Stderr, "Usage: frictionless theme export NAME [-o FILE]")
+			return 1
+		}
+
+		result, err := mcp.ExportTheme(baseDir, name, dest)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error exporting theme: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Wrote %s (%d files)\n", result.Path, len(result.Files))
+		for _, w := range result.Warnings {
+			fmt.Printf("  Warning: %s\n", w)
+		}
+		return 0
+
+	case "set":
+		// CRC: crc-ThemeManager.md | Seq: seq-theme-set.md
+		var theme, mode string
+		for _, arg := range actionArgs {
+			switch {
+			case arg == "--auto":
+				mode = mcp.ThemeModeAuto
+			case arg == "--fixed":
+				mode = mcp.ThemeModeFixed
+			case theme == "" && !strings.HasPrefix(arg, "--"):
+				theme = arg
+			default:
+				fmt.Fprintf(os.Stderr, "Unexpected argument: %s\n", arg)
+				return 1
+			}
+		}
+		if theme == "" && mode == "" {
+			fmt.Fprintln(os.Stderr, "Usage: frictionless theme set [NAME] [--auto|--fixed]")
+			return 1
+		}
+		if theme == "" {
+			theme = mcp.GetCurrentTheme(baseDir)
+		}
+
+		// A running server saves the setting and switches its browsers live
+		if err := setThemeOnServer(baseDir, theme, mode); err == nil {
+			fmt.Printf("Theme set to %s\n", theme)
+			return 0
+		} else if !errors.Is(err, errNoServer) {
+			fmt.Fprintf(os.Stderr, "Error setting theme: %v\n", err)
+			return 1
+		}
+
+		// Save the theme first: it checks the theme exists, so a bad name leaves the mode unchanged
+		if err := mcp.SaveThemeSetting(baseDir, "", theme); err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting theme: %v\n", err)
+			return 1
+		}
+		if mode != "" {
+			if err := mcp.SaveThemeMode(baseDir, mode); err != nil {
+				fmt.Fprintf(os.Stderr, "Error setting theme mode: %v\n", err)
+				return 1
+			}
+		}
+		if err := mcp.InjectThemeBlock(baseDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to update index.html theme block: %v\n", err)
+		}
+		fmt.Printf("Theme set to %s (no running server; applies on next page load)\n", theme)
 		return 0
 
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown theme action: %s\n", action)
-		fmt.Fprintln(os.Stderr, "Usage: frictionless theme <list|classes|audit> [options]")
+		fmt.Fprintln(os.Stderr, "Usage: frictionless theme <list|classes|audit|validate|new|set|install|export> [options]")
 		return 1
 	}
 }
 
+// errNoServer means no MCP server is listening for baseDir
+var errNoServer = errors.New("no running server")
+
+// setThemeOnServer asks the MCP server running for baseDir to set the global theme (and mode,
+// if not empty) through POST /api/ui_theme, sending $FRICTIONLESS_API_TOKEN when it is set.
+// Returns errNoServer if there is no server to ask.
+func setThemeOnServer(baseDir, theme, mode string) error {
+	port, err := os.ReadFile(filepath.Join(baseDir, "mcp-port"))
+	if err != nil {
+		return errNoServer
+	}
+	body, _ := json.Marshal(map[string]string{"action": "set", "theme": theme, "mode": mode})
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://127.0.0.1:%s/api/ui_theme", strings.TrimSpace(string(port))), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("FRICTIONLESS_API_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errNoServer // Stale port file
+	}
+	defer resp.Body.Close()
+
+	// Success returns the ThemeSetResult object; tool errors come back as a plain message
+	var reply struct {
+		Result interface{} `json:"result"`
+		Error  string      `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&reply)
+	if message, ok := reply.Result.(string); ok {
+		return errors.New(message)
+	}
+	if resp.StatusCode != http.StatusOK || reply.Error != "" {
+		if reply.Error == "" {
+			reply.Error = resp.Status
+		}
+		return errors.New(reply.Error)
+	}
+	return nil
+}
+
 // runServe runs the standalone server with HTTP UI and SSE MCP endpoints.
 func runServe(args []string) int {
 	os.Setenv("FRICTIONLESS_MCP", "true")
@@ -0,0 +1,175 @@
+package main
+
+// CRC: crc-LuaREPL.md | Seq: seq-lua-repl.md
+// frictionless repl: an interactive Lua prompt connected to the running server's /repl endpoint
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zot/frictionless/internal/mcp"
+	"golang.org/x/net/websocket"
+)
+
+// replHelp lists the REPL's own commands
+const replHelp = `Enter Lua expressions or statements; an unfinished block continues on the next line.
+Top-level locals and assignments are kept for this connection; use _G.name = value to set a global.
+Tab completes globals and fields, Up/Down recall history, Ctrl-C cancels the entry.
+  :help      show this help
+  :history   list history
+  :quit      exit (or Ctrl-D)`
+
+// runREPL connects to the MCP server for --dir and runs the prompt until EOF or :quit.
+// Spec: mcp.md Section 2.6
+func runREPL(args []string) int {
+	baseDir, filteredArgs := parseDirFlag(args)
+	profile := ""
+	token := os.Getenv("FRICTIONLESS_API_TOKEN")
+	for i := 0; i < len(filteredArgs); i++ {
+		switch {
+		case filteredArgs[i] == "--profile" && i+1 < len(filteredArgs):
+			profile = filteredArgs[i+1]
+			i++
+		case filteredArgs[i] == "--token" && i+1 < len(filteredArgs):
+			token = filteredArgs[i+1]
+			i++
+		default:
+			fmt.Fprintf(os.Stderr, "Unexpected argument: %s\n", filteredArgs[i])
+			fmt.Fprintln(os.Stderr, "Usage: frictionless repl [--profile full|no-io|readonly] [--token TOKEN] [--dir DIR]")
+			return 1
+		}
+	}
+	if _, err := mcp.ParseRunProfile(profile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	ws, err := dialREPL(baseDir, profile, token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer ws.Close()
+
+	editor := newLineEditor(filepath.Join(baseDir, "repl_history"), func(text string) (int, []string) {
+		var reply mcp.REPLReply
+		if err := replCall(ws, mcp.REPLRequest{Type: "complete", Text: text}, &reply); err != nil {
+			return 0, nil
+		}
+		return reply.Start, reply.Completions
+	})
+	defer editor.Close()
+
+	shown := profile
+	if shown == "" && token != "" {
+		shown = "token's"
+	} else if shown == "" {
+		shown = "full"
+	}
+	fmt.Printf("Connected to %s (%s profile). :help for commands, Ctrl-D to exit.\n", baseDir, shown)
+	var entry []string
+	for {
+		prompt := "> "
+		if len(entry) > 0 {
+			prompt = ">> "
+		}
+		line, err := editor.ReadLine(prompt)
+		if errors.Is(err, errInterrupted) {
+			entry = nil
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			return 0
+		}
+		if len(entry) == 0 {
+			switch strings.TrimSpace(line) {
+			case "":
+				continue
+			case ":quit", ":q", ":exit":
+				return 0
+			case ":help":
+				fmt.Println(replHelp)
+				continue
+			case ":history":
+				for i, past := range editor.history {
+					fmt.Printf("%4d  %s\n", i+1, past)
+				}
+				continue
+			}
+		}
+		editor.AddHistory(line)
+		entry = append(entry, line)
+
+		var reply mcp.REPLReply
+		if err := replCall(ws, mcp.REPLRequest{Type: "eval", Code: strings.Join(entry, "\n")}, &reply); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: connection lost: %v\n", err)
+			return 1
+		}
+		if reply.Incomplete {
+			continue
+		}
+		entry = nil
+		printREPLReply(reply)
+	}
+}
+
+// dialREPL opens the WebSocket to the server whose port is in baseDir/mcp-port
+func dialREPL(baseDir, profile, token string) (*websocket.Conn, error) {
+	port, err := os.ReadFile(filepath.Join(baseDir, "mcp-port"))
+	if err != nil {
+		return nil, fmt.Errorf("no running server for %s (start one with frictionless mcp or serve)", baseDir)
+	}
+	location := fmt.Sprintf("ws://127.0.0.1:%s/repl", strings.TrimSpace(string(port)))
+	if profile != "" {
+		location += "?profile=" + url.QueryEscape(profile)
+	}
+	config, err := websocket.NewConfig(location, "http://127.0.0.1/")
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		config.Header.Set("Authorization", "Bearer "+token)
+	}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", location, err)
+	}
+	return ws, nil
+}
+
+// replCall sends one request and waits for its reply
+func replCall(ws *websocket.Conn, req mcp.REPLRequest, reply *mcp.REPLReply) error {
+	if err := websocket.JSON.Send(ws, req); err != nil {
+		return err
+	}
+	return websocket.JSON.Receive(ws, reply)
+}
+
+// printREPLReply shows output, then the values or the error
+func printREPLReply(reply mcp.REPLReply) {
+	if reply.Stdout != "" {
+		fmt.Print(reply.Stdout)
+		if !strings.HasSuffix(reply.Stdout, "\n") {
+			fmt.Println()
+		}
+	}
+	if reply.Error != "" {
+		fmt.Fprintf(os.Stderr, "error: %s\n", reply.Error)
+		if reply.Traceback != "" {
+			fmt.Fprintln(os.Stderr, reply.Traceback)
+		}
+		return
+	}
+	for _, value := range reply.Values {
+		fmt.Println(value)
+	}
+}
@@ -788,6 +788,30 @@ function AppInfo:notBuilding()
     return not self:isBuilding()
 end
 
+-- Live audit results, pushed by the MCP server as app files are saved
+function AppInfo:diagnostics()
+    return mcp.diagnostics and mcp.diagnostics[self.name]
+end
+
+function AppInfo:lintCount()
+    local diag = self:diagnostics()
+    return diag and #diag.violations or 0
+end
+
+function AppInfo:noLint()
+    return self:lintCount() == 0
+end
+
+function AppInfo:lintTooltip()
+    local diag = self:diagnostics()
+    if not diag then return "" end
+    local lines = {}
+    for _, v in ipairs(diag.violations) do
+        table.insert(lines, v.location .. ": " .. v.detail)
+    end
+    return table.concat(lines, "\n")
+end
+
 -- Helper to create toggle/hidden/icon methods for collapsible sections
 local function makeCollapsible(proto, fieldName)
     local showField = "show" .. fieldName
@@ -4,13 +4,15 @@
 local Prefs = session:prototype("Prefs", {
     _themes = {},
     _themeScanTime = 0,
-    _currentTheme = "lcars"
+    _currentTheme = "lcars",
+    _themeMode = "fixed"
 })
 
 Prefs.ThemeItem = session:prototype("Prefs.ThemeItem", {
     name = "",
     description = "",
     accentColor = "",
+    pair = "",
     _prefs = nil
 })
 local ThemeItem = Prefs.ThemeItem
@@ -28,6 +30,14 @@ function ThemeItem:swatchStyle()
     return "background-color: " .. self.accentColor .. ";"
 end
 
+function ThemeItem:pairLabel()
+    return "Pairs with " .. self.pair
+end
+
+function ThemeItem:pairHidden()
+    return self.pair == ""
+end
+
 function Prefs:scanThemes()
     local themeDir = mcp:status().base_dir .. "/html/themes"
     local names = {}
@@ -42,11 +52,18 @@ function Prefs:scanThemes()
             if name and name ~= "base" then
                 local desc = head:match("@description%s+([^\n]+)") or ""
                 local accent = head:match("%-%-term%-accent:%s*(#%x+)") or ""
-                names[name] = {description = desc, accentColor = accent}
+                local pair = head:match("@pair%s+(%S+)") or ""
+                names[name] = {description = desc, accentColor = accent, pair = pair}
             end
         end
     end
     handle:close()
+    -- A single @pair declaration pairs both themes
+    for name, info in pairs(names) do
+        if info.pair ~= "" and names[info.pair] and names[info.pair].pair == "" then
+            names[info.pair].pair = name
+        end
+    end
     -- Build sorted list, reusing existing ThemeItems where possible
     local byName = {}
     for _, t in ipairs(self._themes) do
@@ -61,12 +78,14 @@ function Prefs:scanThemes()
         if existing then
             existing.description = names[n].description
             existing.accentColor = names[n].accentColor
+            existing.pair = names[n].pair
             result[#result + 1] = existing
         else
             local item = session:create(ThemeItem, {
                 name = n,
                 description = names[n].description,
                 accentColor = names[n].accentColor,
+                pair = names[n].pair,
                 _prefs = self
             })
             result[#result + 1] = item
@@ -90,13 +109,26 @@ end
 
 function Prefs:setCurrentTheme(name)
     self._currentTheme = name
-    -- Persist to settings file
-    local settings = mcp:readSettings()
-    settings.theme = name
-    mcp:writeSettings(settings)
-    self:applyTheme(name)
-    -- Re-inject theme block so future page loads include all theme CSS links
-    mcp:reinjectThemes()
+    -- Persists to settings.json, re-injects the theme block and switches the browser
+    local _, err = mcp:setTheme(name)
+    if err then
+        mcp:notify("Could not set theme: " .. err, "danger")
+    end
+end
+
+-- Auto mode shows the selected theme or its @pair, following the system light/dark setting
+function Prefs:autoTheme()
+    return self._themeMode == "auto"
+end
+
+function Prefs:toggleAutoTheme()
+    local mode = self:autoTheme() and "fixed" or "auto"
+    local _, err = mcp:setTheme(self._currentTheme, {mode = mode})
+    if err then
+        mcp:notify("Could not set theme mode: " .. err, "danger")
+        return
+    end
+    self._themeMode = mode
 end
 
 function Prefs:applyTheme(name)
@@ -113,6 +145,7 @@ function Prefs:loadThemeFromSettings()
     if settings.theme and settings.theme ~= "" then
         self._currentTheme = settings.theme
     end
+    self._themeMode = settings.themeMode == "auto" and "auto" or "fixed"
     self:applyTheme(self._currentTheme)
 end
 
@@ -19,6 +19,7 @@ type AuditResult struct {
 	Warnings   []Violation  `json:"warnings"`
 	Reminders  []string     `json:"reminders"`
 	Summary    AuditSummary `json:"summary"`
+	Fix        *FixResult   `json:"fix,omitempty"`
 }
 
 // Violation represents a single audit finding
@@ -30,9 +31,10 @@ type Violation struct {
 
 // AuditSummary provides counts of findings
 type AuditSummary struct {
-	TotalMethods      int `json:"total_methods"`
-	DeadMethods       int `json:"dead_methods"`
-	ViewdefViolations int `json:"viewdef_violations"`
+	TotalMethods       int `json:"total_methods"`
+	DeadMethods        int `json:"dead_methods"`
+	ViewdefViolations  int `json:"viewdef_violations"`
+	CrossAppViolations int `json:"cross_app_violations"`
 }
 
 // Known method lists
@@ -112,9 +114,19 @@ var (
 	funcCallPattern = regexp.MustCompile(`^(\w+)\s*\(\s*(\w+)`)
 )
 
-// AuditApp performs a full audit of an app
+// AuditApp performs a full audit of an app, including its references to other apps
 // CRC: crc-Auditor.md
 func AuditApp(baseDir, appName string) (*AuditResult, error) {
+	result, err := auditAppLocal(baseDir, appName)
+	if err != nil {
+		return nil, err
+	}
+	checkCrossAppRefs(newCrossAppIndex(baseDir), appName, result)
+	return result, nil
+}
+
+// auditAppLocal audits an app's own files; its result depends only on files under apps/{appName}
+func auditAppLocal(baseDir, appName string) (*AuditResult, error) {
 	appPath := filepath.Join(baseDir, "apps", appName)
 
 	result := &AuditResult{
@@ -433,6 +445,9 @@ func walkDOM(n *html.Node, filename string, isListItem bool, result *AuditResult
 			})
 		}
 
+		// Accessibility checks (warnings)
+		checkAccessibility(n, filename, result)
+
 		// Check attributes
 		for _, attr := range n.Attr {
 			// Check ui-action on non-button
@@ -0,0 +1,137 @@
+package mcp
+
+// CRC: crc-Auditor.md | Seq: seq-audit.md
+// Accessibility checks for viewdef elements, reported as warnings
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	// Form controls that need an accessible label
+	formControls = map[string]bool{
+		"input":       true,
+		"select":      true,
+		"textarea":    true,
+		"sl-input":    true,
+		"sl-select":   true,
+		"sl-textarea": true,
+	}
+
+	// Input types that don't take a label (buttons carry their own text, hidden isn't rendered)
+	unlabeledInputTypes = map[string]bool{
+		"hidden": true,
+		"submit": true,
+		"reset":  true,
+		"button": true,
+		"image":  true,
+	}
+
+	// Elements that are keyboard-focusable and announced as interactive without role/tabindex
+	interactiveElements = map[string]bool{
+		"button":         true,
+		"sl-button":      true,
+		"sl-icon-button": true,
+		"a":              true,
+		"input":          true,
+		"select":         true,
+		"textarea":       true,
+		"summary":        true,
+		"sl-checkbox":    true,
+		"sl-switch":      true,
+		"sl-radio":       true,
+		"sl-menu-item":   true,
+		"sl-tab":         true,
+		"sl-option":      true,
+		"sl-tree-item":   true,
+	}
+)
+
+// hasAttr reports whether an element has any of the given attributes, static or bound via ui-attr-*
+func hasAttr(n *html.Node, names ...string) bool {
+	for _, attr := range n.Attr {
+		for _, name := range names {
+			if attr.Key == name || attr.Key == "ui-attr-"+name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// attrValue returns an attribute's value, or "" if absent
+func attrValue(n *html.Node, name string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// checkAccessibility flags accessibility problems on a single element
+// CRC: crc-Auditor.md
+func checkAccessibility(n *html.Node, filename string, result *AuditResult) {
+	tagName := n.Data
+	location := fmt.Sprintf("viewdefs/%s", filename)
+	warn := func(violationType, detail string) {
+		result.Warnings = append(result.Warnings, Violation{Type: violationType, Location: location, Detail: detail})
+	}
+
+	switch {
+	case tagName == "sl-icon-button" && !hasAttr(n, "label", "aria-label", "aria-labelledby"):
+		warn("a11y_icon_button_label", fmt.Sprintf("<sl-icon-button name=%q> has no label (screen readers announce nothing)", attrValue(n, "name")))
+
+	case tagName == "img" && !hasAttr(n, "alt"):
+		warn("a11y_img_alt", "<img> has no alt text (use alt=\"\" for decorative images)")
+
+	case formControls[tagName] && !unlabeledInputTypes[attrValue(n, "type")] && !isLabeled(n):
+		warn("a11y_input_label", fmt.Sprintf("<%s> has no label (add label or aria-label, or wrap it in <label>; placeholder is not a label)", tagName))
+	}
+
+	if hasAttr(n, "ui-event-click") && !interactiveElements[tagName] {
+		var missing []string
+		if !hasAttr(n, "role") {
+			missing = append(missing, "role")
+		}
+		if !hasAttr(n, "tabindex") {
+			missing = append(missing, "tabindex")
+		}
+		if len(missing) > 0 {
+			warn("a11y_clickable_non_button", fmt.Sprintf("ui-event-click on <%s> without %s (not reachable by keyboard)", tagName, strings.Join(missing, "/")))
+		}
+	}
+}
+
+// isLabeled reports whether a form control has a label attribute, a wrapping <label>,
+// or a <label for="id"> elsewhere in the viewdef
+func isLabeled(n *html.Node) bool {
+	if hasAttr(n, "label", "aria-label", "aria-labelledby", "title") {
+		return true
+	}
+	root := n
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Type == html.ElementNode && p.Data == "label" {
+			return true
+		}
+		root = p
+	}
+	id := attrValue(n, "id")
+	return id != "" && findLabelFor(root, id)
+}
+
+// findLabelFor searches a subtree for <label for="id">
+func findLabelFor(n *html.Node, id string) bool {
+	if n.Type == html.ElementNode && n.Data == "label" && attrValue(n, "for") == id {
+		return true
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if findLabelFor(c, id) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package mcp
+
+// CRC: crc-Auditor.md | Seq: seq-audit.md
+// Whole-project audit: audits every app concurrently, caching results by file content hash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ProjectAuditResult contains the results of auditing every app in a project
+type ProjectAuditResult struct {
+	Apps    []AppAuditSummary       `json:"apps"`
+	Graph   map[string][]string     `json:"graph"` // app -> apps it references via mcp:app()/mcp:display()
+	Results map[string]*AuditResult `json:"results,omitempty"`
+	Summary ProjectAuditSummary     `json:"summary"`
+}
+
+// AppAuditSummary is one row of the project audit table
+type AppAuditSummary struct {
+	App         string `json:"app"`
+	Violations  int    `json:"violations"`
+	Warnings    int    `json:"warnings"`
+	DeadMethods int    `json:"dead_methods"`
+	Cached      bool   `json:"cached"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ProjectAuditSummary provides project-wide counts
+type ProjectAuditSummary struct {
+	Apps       int `json:"apps"`
+	CleanApps  int `json:"clean_apps"`
+	Violations int `json:"violations"`
+	Warnings   int `json:"warnings"`
+	Cached     int `json:"cached"`
+}
+
+// AuditCache holds audit results keyed by a hash of each app's audited files.
+// Safe for concurrent use.
+type AuditCache struct {
+	mu      sync.Mutex
+	entries map[string]auditCacheEntry // app name -> entry
+}
+
+type auditCacheEntry struct {
+	hash   string
+	result *AuditResult
+}
+
+// NewAuditCache creates an empty audit cache
+func NewAuditCache() *AuditCache {
+	return &AuditCache{entries: make(map[string]auditCacheEntry)}
+}
+
+// get returns the cached result for an app if its files still hash the same
+func (c *AuditCache) get(app, hash string) (*AuditResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[app]
+	if !ok || entry.hash != hash {
+		return nil, false
+	}
+	return entry.result, true
+}
+
+// put stores an audit result for an app
+func (c *AuditCache) put(app, hash string, result *AuditResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[app] = auditCacheEntry{hash: hash, result: result}
+}
+
+// ListApps returns the names of app directories under {baseDir}/apps that contain app.lua.
+// Symlinked app directories are followed.
+func ListApps(baseDir string) ([]string, error) {
+	appsDir := filepath.Join(baseDir, "apps")
+	entries, err := os.ReadDir(appsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	var apps []string
+	for _, entry := range entries {
+		// Use os.Stat to follow symlinks (entry.IsDir() doesn't)
+		if info, err := os.Stat(filepath.Join(appsDir, entry.Name(), "app.lua")); err != nil || info.IsDir() {
+			continue
+		}
+		apps = append(apps, entry.Name())
+	}
+	sort.Strings(apps)
+	return apps, nil
+}
+
+// hashAppFiles hashes the names and contents of every file auditAppLocal reads for an app.
+func hashAppFiles(baseDir, appName string) (string, error) {
+	appPath := filepath.Join(baseDir, "apps", appName)
+	luaFiles, err := filepath.Glob(filepath.Join(appPath, "*.lua"))
+	if err != nil {
+		return "", err
+	}
+	htmlFiles, err := filepath.Glob(filepath.Join(appPath, "viewdefs", "*.html"))
+	if err != nil {
+		return "", err
+	}
+	files := append(luaFiles, htmlFiles...)
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue // auditAppLocal skips unreadable files too
+		}
+		rel, _ := filepath.Rel(appPath, file)
+		fmt.Fprintf(h, "%s\x00%d\x00", rel, len(content))
+		h.Write(content)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// AuditAllApps audits every app in the project concurrently.
+// Apps whose files are unchanged since the last audit reuse the cached result.
+// A nil cache disables caching. Full per-app results are included when verbose is true.
+// CRC: crc-Auditor.md
+func AuditAllApps(baseDir string, cache *AuditCache, verbose bool) (*ProjectAuditResult, error) {
+	apps, err := ListApps(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("listing apps: %w", err)
+	}
+
+	rows := make([]AppAuditSummary, len(apps))
+	results := make([]*AuditResult, len(apps))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.NumCPU())
+	for i, app := range apps {
+		wg.Add(1)
+		go func(i int, app string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			rows[i], results[i] = auditAppCached(baseDir, app, cache)
+		}(i, app)
+	}
+	wg.Wait()
+
+	// Cross-app checks depend on other apps' files, so they run fresh over the cached local results
+	idx := newCrossAppIndex(baseDir)
+	graph := make(map[string][]string, len(apps))
+	for i, app := range apps {
+		graph[app] = idx.appDependencies(app)
+		if results[i] == nil {
+			continue
+		}
+		full := *results[i]
+		full.Violations = append([]Violation(nil), results[i].Violations...)
+		checkCrossAppRefs(idx, app, &full)
+		results[i] = &full
+		rows[i].Violations = len(full.Violations)
+	}
+
+	project := &ProjectAuditResult{Apps: rows, Graph: graph}
+	if verbose {
+		project.Results = make(map[string]*AuditResult, len(apps))
+	}
+	for i, row := range rows {
+		project.Summary.Apps++
+		project.Summary.Violations += row.Violations
+		project.Summary.Warnings += row.Warnings
+		if row.Cached {
+			project.Summary.Cached++
+		}
+		if row.Violations == 0 && row.Error == "" {
+			project.Summary.CleanApps++
+		}
+		if verbose && results[i] != nil {
+			project.Results[row.App] = results[i]
+		}
+	}
+
+	return project, nil
+}
+
+// auditAppCached audits one app's own files, consulting the cache when its file hash is unchanged.
+func auditAppCached(baseDir, app string, cache *AuditCache) (AppAuditSummary, *AuditResult) {
+	row := AppAuditSummary{App: app}
+
+	hash, err := hashAppFiles(baseDir, app)
+	if err != nil {
+		row.Error = err.Error()
+		return row, nil
+	}
+
+	result, cached := (*AuditResult)(nil), false
+	if cache != nil {
+		result, cached = cache.get(app, hash)
+	}
+	if !cached {
+		result, err = auditAppLocal(baseDir, app)
+		if err != nil {
+			row.Error = err.Error()
+			return row, nil
+		}
+		if cache != nil {
+			cache.put(app, hash, result)
+		}
+	}
+
+	row.Cached = cached
+	row.Violations = len(result.Violations)
+	row.Warnings = len(result.Warnings)
+	row.DeadMethods = result.Summary.DeadMethods
+	return row, result
+}
+
+// FormatAuditTable renders a project audit as a plain-text table.
+func FormatAuditTable(project *ProjectAuditResult) string {
+	width := len("APP")
+	for _, row := range project.Apps {
+		if len(row.App) > width {
+			width = len(row.App)
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-*s  %10s  %8s  %4s  %s\n", width, "APP", "VIOLATIONS", "WARNINGS", "DEAD", "STATUS")
+	for _, row := range project.Apps {
+		status := "ok"
+		switch {
+		case row.Error != "":
+			status = "error: " + row.Error
+		case row.Violations > 0:
+			status = "fail"
+		}
+		if row.Cached {
+			status += " (cached)"
+		}
+		fmt.Fprintf(&sb, "%-*s  %10d  %8d  %4d  %s\n", width, row.App, row.Violations, row.Warnings, row.DeadMethods, status)
+	}
+	header := false
+	for _, row := range project.Apps {
+		deps := project.Graph[row.App]
+		if len(deps) == 0 {
+			continue
+		}
+		if !header {
+			sb.WriteString("\nDEPENDENCIES\n")
+			header = true
+		}
+		fmt.Fprintf(&sb, "%-*s  -> %s\n", width, row.App, strings.Join(deps, ", "))
+	}
+	fmt.Fprintf(&sb, "\n%d apps, %d clean, %d violations, %d warnings\n",
+		project.Summary.Apps, project.Summary.CleanApps, project.Summary.Violations, project.Summary.Warnings)
+	return sb.String()
+}
@@ -0,0 +1,260 @@
+package mcp
+
+// CRC: crc-Auditor.md | Seq: seq-audit.md
+// Auto-fix for mechanical viewdef violations: rewrites attributes in place, preserving formatting
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// FixResult describes the changes made by an auto-fix pass
+type FixResult struct {
+	App        string      `json:"app"`
+	Fixes      []AuditFix  `json:"fixes"`
+	Files      []string    `json:"files"`
+	Diff       string      `json:"diff"`
+	Checkpoint string      `json:"checkpoint,omitempty"`
+	Unfixed    []Violation `json:"unfixed"` // Flagged by the audit but not safe to rewrite
+}
+
+// AuditFix records a single rewritten attribute
+type AuditFix struct {
+	Type     string `json:"type"`
+	Location string `json:"location"`
+	Before   string `json:"before"`
+	After    string `json:"after"`
+}
+
+var (
+	// Matches one attribute in a raw start tag, including its leading whitespace
+	// Captures: leading space, name, "=value" part (optional), value with quotes
+	rawAttrPattern = regexp.MustCompile(`(\s+)([^\s"'>/=]+)(\s*=\s*("[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?`)
+
+	// Matches a ui-class value that only toggles hidden: "hidden:path"
+	hiddenClassPattern = regexp.MustCompile(`^\s*hidden:\s*(\S+)\s*$`)
+
+	// Matches an item. prefix at the start of a path segment
+	itemPrefixPattern = regexp.MustCompile(`(^|[^\w.])item\.`)
+)
+
+// FixApp rewrites mechanically fixable viewdef violations in place.
+// Only attribute names and values are changed; all other bytes are preserved.
+// CRC: crc-Auditor.md
+func FixApp(baseDir, appName string) (*FixResult, error) {
+	viewdefsPath := filepath.Join(baseDir, "apps", appName, "viewdefs")
+	result := &FixResult{
+		App:     appName,
+		Fixes:   []AuditFix{},
+		Files:   []string{},
+		Unfixed: []Violation{},
+	}
+
+	entries, err := os.ReadDir(viewdefsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, fmt.Errorf("reading viewdefs: %w", err)
+	}
+
+	var diff strings.Builder
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".html") {
+			continue
+		}
+
+		filePath := filepath.Join(viewdefsPath, entry.Name())
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			continue
+		}
+
+		isListItem := strings.HasSuffix(entry.Name(), ".list-item.html")
+		fixed, fixes, unfixed := fixViewdef("viewdefs/"+entry.Name(), string(content), isListItem)
+		result.Unfixed = append(result.Unfixed, unfixed...)
+		if len(fixes) == 0 {
+			continue
+		}
+
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filePath, []byte(fixed), info.Mode().Perm()); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", entry.Name(), err)
+		}
+
+		result.Fixes = append(result.Fixes, fixes...)
+		result.Files = append(result.Files, "viewdefs/"+entry.Name())
+		diff.WriteString(lineDiff("viewdefs/"+entry.Name(), string(content), fixed))
+	}
+
+	result.Diff = diff.String()
+	return result, nil
+}
+
+// fixViewdef tokenizes a viewdef and rewrites fixable attributes in each start tag.
+// Tokens are reassembled from their raw bytes so untouched text is preserved exactly.
+// Also returns the violations it found but left alone.
+func fixViewdef(location, content string, isListItem bool) (string, []AuditFix, []Violation) {
+	var fixes []AuditFix
+	var unfixed []Violation
+	var out strings.Builder
+
+	z := html.NewTokenizer(strings.NewReader(content))
+	offset := 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				return content, nil, nil // Leave unparseable files alone
+			}
+			break
+		}
+
+		raw := string(z.Raw())
+		if tt == html.StartTagToken || tt == html.SelfClosingTagToken {
+			name, _ := z.TagName()
+			line := strings.Count(content[:offset], "\n") + 1
+			var tagFixes []AuditFix
+			var tagUnfixed []Violation
+			raw, tagFixes, tagUnfixed = fixTag(string(name), raw, isListItem, fmt.Sprintf("%s:%d", location, line))
+			fixes = append(fixes, tagFixes...)
+			unfixed = append(unfixed, tagUnfixed...)
+		}
+		offset += len(z.Raw())
+		out.WriteString(raw)
+	}
+
+	if len(fixes) == 0 {
+		return content, nil, unfixed
+	}
+	return out.String(), fixes, unfixed
+}
+
+// fixTag rewrites the attributes of a single raw start tag. Violations the audit flags that no
+// rewrite settles, such as an item. that is not a leading path prefix (other.item.name) or a
+// rename blocked by the attribute it would produce, are reported as unfixed.
+func fixTag(tagName, raw string, isListItem bool, location string) (string, []AuditFix, []Violation) {
+	// Skip past "<tagname" so the tag name itself is never treated as an attribute
+	start := 1 + len(tagName)
+	if start > len(raw) {
+		return raw, nil, nil
+	}
+
+	present := make(map[string]bool)
+	for _, m := range rawAttrPattern.FindAllStringSubmatch(raw[start:], -1) {
+		present[strings.ToLower(m[2])] = true
+	}
+
+	var fixes []AuditFix
+	var unfixed []Violation
+	fixed := rawAttrPattern.ReplaceAllStringFunc(raw[start:], func(attr string) string {
+		m := rawAttrPattern.FindStringSubmatch(attr)
+		space, name, assign, quoted := m[1], m[2], m[3], m[4]
+		key := strings.ToLower(name)
+		quote, value := splitQuoted(quoted) // Both empty for a bare attribute
+		eq := assign[:len(assign)-len(quoted)]
+		unfix := func(typ, detail string) {
+			unfixed = append(unfixed, Violation{Type: typ, Location: location, Detail: detail})
+		}
+
+		// Each case is a violation the audit reports; those a rewrite can't settle are left unfixed
+		newName, newValue, fixType := name, value, ""
+		switch {
+		case key == "ui-action" && !buttonElements[tagName]:
+			if present["ui-event-click"] {
+				unfix("ui_action_non_button", fmt.Sprintf("<%s> already has ui-event-click; merge ui-action=%q into it by hand", tagName, value))
+			} else {
+				newName, fixType = "ui-event-click", "ui_action_non_button"
+			}
+		case key == "ui-value" && (tagName == "sl-checkbox" || tagName == "sl-switch"):
+			if present["ui-attr-checked"] {
+				unfix("ui_value_checkbox", fmt.Sprintf("<%s> already has ui-attr-checked; remove ui-value=%q by hand", tagName, value))
+			} else {
+				newName, fixType = "ui-attr-checked", "ui_value_checkbox"
+			}
+		case key == "ui-class" && strings.Contains(value, "hidden:"):
+			if hm := hiddenClassPattern.FindStringSubmatch(value); hm != nil && !present["ui-class-hidden"] {
+				newName, newValue, fixType = "ui-class-hidden", hm[1], "wrong_hidden_syntax"
+			} else {
+				unfix("wrong_hidden_syntax", fmt.Sprintf("ui-class=%q: move the hidden: condition to ui-class-hidden by hand", value))
+			}
+		}
+		if isListItem && strings.HasPrefix(key, "ui-") && key != "ui-namespace" && itemPrefixPattern.MatchString(newValue) {
+			newValue = itemPrefixPattern.ReplaceAllString(newValue, "$1")
+			if fixType == "" {
+				fixType = "item_prefix"
+			}
+		}
+		if isListItem && strings.HasPrefix(key, "ui-") && strings.Contains(newValue, "item.") {
+			unfix("item_prefix", fmt.Sprintf("%s=%q: 'item.' is not a leading prefix here; fix by hand", name, newValue))
+		}
+		if fixType == "" {
+			return attr
+		}
+
+		replacement := space + newName + eq + quote + newValue + quote
+		fixes = append(fixes, AuditFix{
+			Type:     fixType,
+			Location: location,
+			Before:   strings.TrimSpace(attr),
+			After:    strings.TrimSpace(replacement),
+		})
+		return replacement
+	})
+
+	return raw[:start] + fixed, fixes, unfixed
+}
+
+// splitQuoted separates an attribute value from its surrounding quotes.
+func splitQuoted(v string) (quote, value string) {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[:1], v[1 : len(v)-1]
+	}
+	return "", v
+}
+
+// lineDiff returns a unified-style diff of the changed lines between before and after.
+// Fixes never add or remove newlines, so lines are compared pairwise.
+func lineDiff(path, before, after string) string {
+	oldLines := strings.Split(before, "\n")
+	newLines := strings.Split(after, "\n")
+	if len(oldLines) != len(newLines) {
+		return fmt.Sprintf("--- a/%s\n+++ b/%s\n(file rewritten)\n", path, path)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- a/%s\n+++ b/%s\n", path, path)
+	for i := range oldLines {
+		if oldLines[i] == newLines[i] {
+			continue
+		}
+		fmt.Fprintf(&buf, "@@ -%d +%d @@\n-%s\n+%s\n", i+1, i+1, oldLines[i], newLines[i])
+	}
+	return buf.String()
+}
+
+// CreateCheckpoint saves an app checkpoint via the installed mcp script.
+// Returns the script output (e.g., "Saved checkpoint: ...").
+// CRC: crc-Auditor.md
+func CreateCheckpoint(baseDir, appName, message string) (string, error) {
+	script := filepath.Join(baseDir, "mcp")
+	if _, err := os.Stat(script); err != nil {
+		return "", fmt.Errorf("checkpoint script not found: %w", err)
+	}
+	out, err := exec.Command(script, "checkpoint", "save", appName, message).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("checkpoint failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -7,6 +7,9 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/zot/ui-engine/cli"
 )
 
 // createTestApp creates a minimal app structure for audit testing
@@ -751,3 +754,448 @@ makeCollapsible(AppInfo, "Test")
 		}
 	}
 }
+
+// ============================================================================
+// R156-R158: Auto-fix Tests
+// Test Design: test-Auditor.md (Test: auto-fix mechanical violations)
+// ============================================================================
+
+// TestAuditFixMechanicalViolations tests that each fixable violation is rewritten and no longer reported
+func TestAuditFixMechanicalViolations(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestApp(t, tempDir, "test-app",
+		`function Test:new() end
+function Test:select() end
+function Test:isDone() end
+function Test:isClosed() end`,
+		map[string]string{
+			"Test.DEFAULT.html": `<template>
+  <div class="row" ui-action="select()">Row</div>
+  <sl-checkbox ui-value="isDone()"></sl-checkbox>
+  <div ui-class='hidden:isClosed()'>Panel</div>
+</template>`,
+			"Test.list-item.html": `<template><span ui-value="item.name"></span></template>`,
+		})
+
+	fixResult, err := FixApp(tempDir, "test-app")
+	if err != nil {
+		t.Fatalf("FixApp returned error: %v", err)
+	}
+	if len(fixResult.Fixes) != 4 {
+		t.Errorf("Expected 4 fixes, got %d: %+v", len(fixResult.Fixes), fixResult.Fixes)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(tempDir, "apps", "test-app", "viewdefs", "Test.DEFAULT.html"))
+	expected := `<template>
+  <div class="row" ui-event-click="select()">Row</div>
+  <sl-checkbox ui-attr-checked="isDone()"></sl-checkbox>
+  <div ui-class-hidden='isClosed()'>Panel</div>
+</template>`
+	if string(content) != expected {
+		t.Errorf("Unexpected fixed content:\n%s", content)
+	}
+
+	if !strings.Contains(fixResult.Diff, "@@ -2 +2 @@") || !strings.Contains(fixResult.Diff, "+  <div class=\"row\" ui-event-click=\"select()\">Row</div>") {
+		t.Errorf("Diff missing expected hunk:\n%s", fixResult.Diff)
+	}
+
+	result, err := AuditApp(tempDir, "test-app")
+	if err != nil {
+		t.Fatalf("AuditApp returned error: %v", err)
+	}
+	for _, typ := range []string{"ui_action_non_button", "ui_value_checkbox", "wrong_hidden_syntax", "item_prefix"} {
+		if hasViolationType(result, typ) {
+			t.Errorf("Expected %s to be fixed", typ)
+		}
+	}
+}
+
+// TestAuditFixReportsUnfixed tests that every violation the audit flags and the fix can't settle is reported
+func TestAuditFixReportsUnfixed(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestApp(t, tempDir, "test-app",
+		"function Test:new() end\nfunction Test:go() end\nfunction Test:isDone() end",
+		map[string]string{
+			"Test.list-item.html": `<template>
+  <span ui-value="item.name"></span>
+  <span ui-value="parent.item.name"></span>
+</template>`,
+			"Test.DEFAULT.html": `<template>
+  <div ui-class="hidden:isDone() active">Panel</div>
+  <div ui-action="go()" ui-event-click="go()">Row</div>
+  <sl-checkbox ui-value="isDone()" ui-attr-checked="isDone()"></sl-checkbox>
+</template>`,
+		})
+
+	fixResult, err := FixApp(tempDir, "test-app")
+	if err != nil {
+		t.Fatalf("FixApp returned error: %v", err)
+	}
+	if len(fixResult.Fixes) != 1 {
+		t.Errorf("Expected 1 fix, got %d: %+v", len(fixResult.Fixes), fixResult.Fixes)
+	}
+	unfixed := make(map[string]string) // type -> location
+	for _, v := range fixResult.Unfixed {
+		unfixed[v.Type] = v.Location
+	}
+	want := map[string]string{
+		"item_prefix":          "viewdefs/Test.list-item.html:3",
+		"wrong_hidden_syntax":  "viewdefs/Test.DEFAULT.html:2",
+		"ui_action_non_button": "viewdefs/Test.DEFAULT.html:3",
+		"ui_value_checkbox":    "viewdefs/Test.DEFAULT.html:4",
+	}
+	if len(fixResult.Unfixed) != len(want) {
+		t.Errorf("Expected %d unfixed violations, got %+v", len(want), fixResult.Unfixed)
+	}
+	for typ, location := range want {
+		if unfixed[typ] != location {
+			t.Errorf("Expected unfixed %s at %s, got %q", typ, location, unfixed[typ])
+		}
+	}
+
+	// What the fix reports as unfixed is exactly what the next audit still flags
+	result, err := AuditApp(tempDir, "test-app")
+	if err != nil {
+		t.Fatalf("AuditApp returned error: %v", err)
+	}
+	remaining := make(map[string]int)
+	for _, v := range result.Violations {
+		if _, ok := want[v.Type]; ok {
+			remaining[v.Type]++
+		}
+	}
+	for typ := range want {
+		if remaining[typ] != 1 {
+			t.Errorf("Expected the audit to still flag one %s, got %d", typ, remaining[typ])
+		}
+	}
+}
+
+// TestAuditFixPreservesCleanFiles tests that files without fixable violations are left untouched
+func TestAuditFixPreservesCleanFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	original := `<template>
+  <!-- ui-action="x()" in a comment stays -->
+  <sl-button   ui-action="save()" >Save</sl-button>
+  <div ui-class="active:isActive">Text</div>
+</template>`
+	createTestApp(t, tempDir, "test-app",
+		"function Test:new() end\nfunction Test:save() end",
+		map[string]string{"Test.DEFAULT.html": original})
+
+	fixResult, err := FixApp(tempDir, "test-app")
+	if err != nil {
+		t.Fatalf("FixApp returned error: %v", err)
+	}
+	if len(fixResult.Fixes) != 0 || len(fixResult.Files) != 0 {
+		t.Errorf("Expected no fixes, got %+v", fixResult.Fixes)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(tempDir, "apps", "test-app", "viewdefs", "Test.DEFAULT.html"))
+	if string(content) != original {
+		t.Errorf("Clean file was modified:\n%s", content)
+	}
+}
+
+// ============================================================================
+// R159-R161: Project Audit Tests
+// Test Design: test-Auditor.md (Test: project audit with caching)
+// ============================================================================
+
+// TestAuditAllAppsSummary tests that every app with app.lua is audited and summarized
+func TestAuditAllAppsSummary(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestApp(t, tempDir, "clean-app",
+		"function Test:new() end\nfunction Test:go() end",
+		map[string]string{"Test.DEFAULT.html": `<template><sl-button ui-action="go()">Go</sl-button></template>`})
+	createTestApp(t, tempDir, "bad-app",
+		"function Test:new() end\nfunction Test:go() end",
+		map[string]string{"Test.DEFAULT.html": `<template><div ui-action="go()">Go</div></template>`})
+	createTestApp(t, tempDir, "viewdefs-only", "", map[string]string{"Lib.DEFAULT.html": `<template></template>`})
+
+	project, err := AuditAllApps(tempDir, NewAuditCache(), false)
+	if err != nil {
+		t.Fatalf("AuditAllApps returned error: %v", err)
+	}
+
+	if len(project.Apps) != 2 || project.Apps[0].App != "bad-app" || project.Apps[1].App != "clean-app" {
+		t.Fatalf("Expected sorted rows for bad-app and clean-app, got %+v", project.Apps)
+	}
+	if project.Apps[0].Violations == 0 {
+		t.Error("Expected violations for bad-app")
+	}
+	if project.Summary.CleanApps != 1 {
+		t.Errorf("Expected 1 clean app, got %d", project.Summary.CleanApps)
+	}
+	if project.Results != nil {
+		t.Error("Expected no per-app results when not verbose")
+	}
+	if table := FormatAuditTable(project); !strings.Contains(table, "bad-app") || !strings.Contains(table, "fail") {
+		t.Errorf("Unexpected table:\n%s", table)
+	}
+}
+
+// TestAuditAllAppsCache tests that unchanged apps reuse cached results and edited apps are re-audited
+func TestAuditAllAppsCache(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestApp(t, tempDir, "test-app",
+		"function Test:new() end\nfunction Test:go() end",
+		map[string]string{"Test.DEFAULT.html": `<template><div ui-action="go()">Go</div></template>`})
+	cache := NewAuditCache()
+
+	first, err := AuditAllApps(tempDir, cache, false)
+	if err != nil {
+		t.Fatalf("AuditAllApps returned error: %v", err)
+	}
+	if first.Apps[0].Cached {
+		t.Error("First audit should not be cached")
+	}
+
+	second, _ := AuditAllApps(tempDir, cache, false)
+	if !second.Apps[0].Cached {
+		t.Error("Second audit of unchanged app should be cached")
+	}
+
+	viewdef := filepath.Join(tempDir, "apps", "test-app", "viewdefs", "Test.DEFAULT.html")
+	os.WriteFile(viewdef, []byte(`<template><sl-button ui-action="go()">Go</sl-button></template>`), 0644)
+
+	third, _ := AuditAllApps(tempDir, cache, false)
+	if third.Apps[0].Cached {
+		t.Error("Audit after edit should not be cached")
+	}
+	if third.Apps[0].Violations != 0 {
+		t.Errorf("Expected edited app to be clean, got %d violations", third.Apps[0].Violations)
+	}
+}
+
+// R162-R164: Cross-App Reference Tests
+// Test Design: test-Auditor.md (Cross-app scenarios)
+
+// TestAuditCrossAppReferences tests broken app references and missing cross-app methods
+func TestAuditCrossAppReferences(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestApp(t, tempDir, "helper",
+		"function Helper:new() end\nfunction Helper:refresh() end", nil)
+	createTestApp(t, tempDir, "caller", `function Caller:new() end
+function Caller:go()
+    local h = mcp:app("helper")
+    h:refresh()
+    h:explode()
+    mcp:app("helper"):refresh()
+    mcp:display("missing-app")
+end`, map[string]string{"Caller.DEFAULT.html": `<template><sl-button ui-action="go()">Go</sl-button></template>`})
+
+	result, err := AuditApp(tempDir, "caller")
+	if err != nil {
+		t.Fatalf("AuditApp returned error: %v", err)
+	}
+
+	if !hasViolationType(result, "broken_app_reference") {
+		t.Error("Expected broken_app_reference for mcp:display(\"missing-app\")")
+	}
+	missing := 0
+	for _, v := range result.Violations {
+		if v.Type == "missing_cross_app_method" {
+			missing++
+			if !strings.Contains(v.Detail, "explode") {
+				t.Errorf("Unexpected missing_cross_app_method: %s", v.Detail)
+			}
+			if v.Location != "app.lua:5" {
+				t.Errorf("Expected location app.lua:5, got %s", v.Location)
+			}
+		}
+	}
+	if missing != 1 {
+		t.Errorf("Expected 1 missing_cross_app_method, got %d", missing)
+	}
+	if result.Summary.CrossAppViolations != 2 {
+		t.Errorf("Expected 2 cross-app violations, got %d", result.Summary.CrossAppViolations)
+	}
+}
+
+// TestAuditAllAppsGraph tests the dependency graph and that cross-app checks see edits to target apps
+func TestAuditAllAppsGraph(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestApp(t, tempDir, "helper", "function Helper:new() end", nil)
+	createTestApp(t, tempDir, "caller", `function Caller:new() end
+function Caller:go()
+    mcp:app("helper"):refresh()
+end`, map[string]string{"Caller.DEFAULT.html": `<template><sl-button ui-action="go()">Go</sl-button></template>`})
+	cache := NewAuditCache()
+
+	first, err := AuditAllApps(tempDir, cache, false)
+	if err != nil {
+		t.Fatalf("AuditAllApps returned error: %v", err)
+	}
+	if deps := first.Graph["caller"]; len(deps) != 1 || deps[0] != "helper" {
+		t.Errorf("Expected caller -> [helper], got %v", deps)
+	}
+	if len(first.Graph["helper"]) != 0 {
+		t.Errorf("Expected helper to have no dependencies, got %v", first.Graph["helper"])
+	}
+	if first.Apps[0].App != "caller" || first.Apps[0].Violations != 1 {
+		t.Errorf("Expected caller to have 1 violation, got %+v", first.Apps[0])
+	}
+
+	// Defining the method in the target app clears the caller's violation even though the caller is cached
+	os.WriteFile(filepath.Join(tempDir, "apps", "helper", "app.lua"),
+		[]byte("function Helper:new() end\nfunction Helper:refresh() end"), 0644)
+
+	second, _ := AuditAllApps(tempDir, cache, false)
+	if !second.Apps[0].Cached {
+		t.Error("Unchanged caller should reuse its cached local result")
+	}
+	if second.Apps[0].Violations != 0 {
+		t.Errorf("Expected caller to be clean after helper defines refresh(), got %d violations", second.Apps[0].Violations)
+	}
+}
+
+// R165-R166: Continuous Audit Tests
+// Test Design: test-Auditor.md (Continuous audit)
+
+// TestWatchAppsReportsChangedApp tests that saving app files triggers one debounced callback per app
+func TestWatchAppsReportsChangedApp(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestApp(t, tempDir, "test-app", "function Test:new() end", nil)
+
+	changed := make(chan string, 10)
+	stop, err := WatchApps(tempDir, func(app string) { changed <- app }, func(int, string, ...interface{}) {})
+	if err != nil {
+		t.Fatalf("WatchApps returned error: %v", err)
+	}
+	defer stop()
+
+	appDir := filepath.Join(tempDir, "apps", "test-app")
+	os.WriteFile(filepath.Join(appDir, "app.lua"), []byte("function Test:new() end\n"), 0644)
+	os.WriteFile(filepath.Join(appDir, "viewdefs", "Test.DEFAULT.html"), []byte("<template></template>"), 0644)
+	os.WriteFile(filepath.Join(appDir, "notes.md"), []byte("ignored"), 0644)
+
+	select {
+	case app := <-changed:
+		if app != "test-app" {
+			t.Errorf("Expected test-app, got %s", app)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for audit watcher callback")
+	}
+
+	select {
+	case app := <-changed:
+		t.Errorf("Expected a single debounced callback, got another for %s", app)
+	case <-time.After(2 * auditDebounce):
+	}
+}
+
+// TestWatchAppsStopCancelsPending tests that stopping the watcher drops a pending debounced callback
+func TestWatchAppsStopCancelsPending(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestApp(t, tempDir, "test-app", "function Test:new() end", nil)
+
+	changed := make(chan string, 10)
+	stop, err := WatchApps(tempDir, func(app string) { changed <- app }, func(int, string, ...interface{}) {})
+	if err != nil {
+		t.Fatalf("WatchApps returned error: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(tempDir, "apps", "test-app", "app.lua"), []byte("function Test:new() end\n"), 0644)
+	time.Sleep(auditDebounce / 4) // Let the event reach the watcher
+	stop()
+
+	select {
+	case app := <-changed:
+		t.Errorf("Expected no callback after stop, got one for %s", app)
+	case <-time.After(2 * auditDebounce):
+	}
+}
+
+// TestRefreshChangedAppReauditsDependents tests that a saved app's dependents get fresh diagnostics
+func TestRefreshChangedAppReauditsDependents(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestApp(t, tempDir, "helper", "function Helper:new() end", nil)
+	createTestApp(t, tempDir, "caller", `function Caller:new() end
+function Caller:go()
+    mcp:app("helper"):refresh()
+end`, map[string]string{"Caller.DEFAULT.html": `<template><sl-button ui-action="go()">Go</sl-button></template>`})
+	s := &Server{cfg: cli.DefaultConfig(), baseDir: tempDir, diagnostics: make(map[string]*AuditResult)}
+
+	s.refreshDiagnostics("caller")
+	if !hasViolationType(s.diagnostics["caller"], "missing_cross_app_method") {
+		t.Fatal("Expected caller to report the missing refresh() method")
+	}
+
+	os.WriteFile(filepath.Join(tempDir, "apps", "helper", "app.lua"),
+		[]byte("function Helper:new() end\nfunction Helper:refresh() end"), 0644)
+	s.refreshChangedApp("helper")
+	if hasViolationType(s.diagnostics["caller"], "missing_cross_app_method") {
+		t.Error("Saving helper should re-audit caller")
+	}
+	if s.diagnostics["helper"] == nil {
+		t.Error("Expected diagnostics for helper")
+	}
+}
+
+// R167-R168: Accessibility Tests
+// Test Design: test-Auditor.md (Accessibility)
+
+// TestAuditAccessibility tests the accessibility warnings on viewdef elements
+func TestAuditAccessibility(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestApp(t, tempDir, "test-app",
+		"function Test:new() end\nfunction Test:go() end",
+		map[string]string{"Test.DEFAULT.html": `<template>
+  <sl-icon-button name="x-lg" ui-action="go()"></sl-icon-button>
+  <sl-icon-button name="trash" label="Delete" ui-action="go()"></sl-icon-button>
+  <img src="a.png">
+  <img ui-attr-src="go()" alt="">
+  <sl-input placeholder="Name"></sl-input>
+  <sl-input label="Name"></sl-input>
+  <label>Age <input type="number"></label>
+  <label for="email">Email</label><input id="email">
+  <input type="hidden">
+  <div ui-event-click="go()">Click</div>
+  <div role="button" tabindex="0" ui-event-click="go()">Click</div>
+</template>`})
+
+	result, err := AuditApp(tempDir, "test-app")
+	if err != nil {
+		t.Fatalf("AuditApp returned error: %v", err)
+	}
+
+	counts := make(map[string]int)
+	for _, w := range result.Warnings {
+		counts[w.Type]++
+	}
+	for _, wantType := range []string{"a11y_icon_button_label", "a11y_img_alt", "a11y_input_label", "a11y_clickable_non_button"} {
+		if counts[wantType] != 1 {
+			t.Errorf("Expected 1 %s warning, got %d", wantType, counts[wantType])
+		}
+	}
+	for _, v := range result.Violations {
+		if strings.HasPrefix(v.Type, "a11y_") {
+			t.Errorf("Accessibility issues should be warnings, got violation %s", v.Type)
+		}
+	}
+}
+
+// TestCheckThemeContrast tests contrast ratios, var() resolution and skipping translucent colors
+func TestCheckThemeContrast(t *testing.T) {
+	vars := map[string]string{
+		"--term-bg":         "#000000",
+		"--term-bg-panel":   "rgba(0, 0, 0, 0.5)",
+		"--term-text":       "#fff",
+		"--term-text-dim":   "#444444",
+		"--term-text-muted": "var(--term-text)",
+		"--term-accent":     "rgb(255, 255, 0)",
+	}
+	issues := CheckThemeContrast("test", vars)
+
+	if len(issues) != 1 {
+		t.Fatalf("Expected 1 contrast issue, got %d: %+v", len(issues), issues)
+	}
+	if issues[0].Foreground != "--term-text-dim" || issues[0].Background != "--term-bg" {
+		t.Errorf("Expected --term-text-dim on --term-bg, got %+v", issues[0])
+	}
+	if issues[0].Ratio >= contrastText {
+		t.Errorf("Expected ratio below %.1f, got %.2f", contrastText, issues[0].Ratio)
+	}
+}
@@ -0,0 +1,244 @@
+package mcp
+
+// CRC: crc-Auditor.md | Seq: seq-audit.md
+// Continuous audit: re-audits apps when their Lua or viewdef files are saved
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zot/ui-engine/cli"
+)
+
+// auditDebounce coalesces the burst of events editors produce for a single save
+const auditDebounce = 200 * time.Millisecond
+
+// WatchApps watches apps/*/*.lua and apps/*/viewdefs/*.html and calls onChange with the
+// app name after its files settle. Apps created after the watcher starts are picked up.
+// Returns a stop function.
+func WatchApps(baseDir string, onChange func(app string), logFn func(level int, format string, args ...interface{})) (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("creating watcher: %w", err)
+	}
+
+	appsDir := filepath.Join(baseDir, "apps")
+	if err := watcher.Add(appsDir); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("watching %s: %w", appsDir, err)
+	}
+
+	// addApp watches an app directory and its viewdefs directory (fsnotify is not recursive)
+	addApp := func(app string) {
+		for _, dir := range []string{filepath.Join(appsDir, app), filepath.Join(appsDir, app, "viewdefs")} {
+			if info, err := os.Stat(dir); err == nil && info.IsDir() {
+				if err := watcher.Add(dir); err != nil {
+					logFn(1, "Warning: audit watcher failed to watch %s: %v", dir, err)
+				}
+			}
+		}
+	}
+	entries, _ := os.ReadDir(appsDir)
+	for _, entry := range entries {
+		addApp(entry.Name())
+	}
+
+	var timersMu sync.Mutex
+	timers := make(map[string]*time.Timer) // app -> pending debounce timer
+	stopped := false
+	schedule := func(app string) {
+		timersMu.Lock()
+		defer timersMu.Unlock()
+		if stopped {
+			return
+		}
+		if t, ok := timers[app]; ok {
+			t.Reset(auditDebounce)
+			return
+		}
+		timers[app] = time.AfterFunc(auditDebounce, func() {
+			timersMu.Lock()
+			delete(timers, app)
+			done := stopped
+			timersMu.Unlock()
+			if !done {
+				onChange(app)
+			}
+		})
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+					continue
+				}
+				rel, err := filepath.Rel(appsDir, event.Name)
+				if err != nil {
+					continue
+				}
+				parts := strings.Split(filepath.ToSlash(rel), "/")
+				switch {
+				case len(parts) == 1:
+					// New app directory (or symlink) under apps/
+					if event.Op&fsnotify.Create != 0 {
+						addApp(parts[0])
+						schedule(parts[0])
+					}
+				case len(parts) == 2 && parts[1] == "viewdefs":
+					if event.Op&fsnotify.Create != 0 {
+						addApp(parts[0])
+					}
+				case len(parts) == 2 && strings.HasSuffix(parts[1], ".lua"),
+					len(parts) == 3 && parts[1] == "viewdefs" && strings.HasSuffix(parts[2], ".html"):
+					schedule(parts[0])
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logFn(0, "Warning: audit watcher error: %v", err)
+			}
+		}
+	}()
+
+	// Stopping cancels pending timers; one already firing sees stopped and skips onChange
+	return func() {
+		watcher.Close()
+		timersMu.Lock()
+		defer timersMu.Unlock()
+		stopped = true
+		for app, t := range timers {
+			t.Stop()
+			delete(timers, app)
+		}
+	}, nil
+}
+
+// startAuditWatcher begins continuous auditing for the configured base directory,
+// replacing any watcher from a previous configuration.
+// CRC: crc-Auditor.md
+func (s *Server) startAuditWatcher() {
+	s.mu.Lock()
+	baseDir := s.baseDir
+	if s.stopAuditWatch != nil {
+		s.stopAuditWatch()
+		s.stopAuditWatch = nil
+	}
+	s.diagnostics = make(map[string]*AuditResult)
+	s.mu.Unlock()
+
+	stop, err := WatchApps(baseDir, s.refreshChangedApp, s.cfg.Log)
+	if err != nil {
+		s.cfg.Log(0, "Warning: failed to start audit watcher: %v", err)
+		return
+	}
+	s.mu.Lock()
+	s.stopAuditWatch = stop
+	s.mu.Unlock()
+
+	// Initial pass so diagnostics are available before the first save
+	go func() {
+		apps, _ := ListApps(baseDir)
+		for _, app := range apps {
+			s.refreshDiagnostics(app)
+		}
+	}()
+}
+
+// stopAuditWatcher stops continuous auditing, if it is running
+// CRC: crc-Auditor.md
+func (s *Server) stopAuditWatcher() {
+	s.mu.Lock()
+	stop := s.stopAuditWatch
+	s.stopAuditWatch = nil
+	s.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+// refreshChangedApp re-audits a saved app and the apps whose cross-app checks reference it,
+// since their diagnostics depend on its files too
+// CRC: crc-Auditor.md
+func (s *Server) refreshChangedApp(app string) {
+	s.mu.RLock()
+	baseDir := s.baseDir
+	s.mu.RUnlock()
+
+	s.refreshDiagnostics(app)
+	for _, dependent := range appsReferencing(baseDir, app) {
+		s.refreshDiagnostics(dependent)
+	}
+}
+
+// refreshDiagnostics re-audits an app and publishes the result to mcp.diagnostics.
+// A deleted app's entry is removed.
+// CRC: crc-Auditor.md
+func (s *Server) refreshDiagnostics(app string) {
+	s.mu.RLock()
+	baseDir := s.baseDir
+	vendedID := s.currentVendedID
+	s.mu.RUnlock()
+
+	var result *AuditResult
+	if _, err := os.Stat(filepath.Join(baseDir, "apps", app, "app.lua")); err == nil {
+		audited, err := AuditApp(baseDir, app)
+		if err != nil {
+			s.cfg.Log(1, "Warning: audit of %s failed: %v", app, err)
+			return
+		}
+		result = audited
+	}
+
+	s.mu.Lock()
+	if result != nil {
+		s.diagnostics[app] = result
+	} else {
+		delete(s.diagnostics, app)
+	}
+	s.mu.Unlock()
+
+	if vendedID == "" {
+		return
+	}
+	s.SafeExecuteInSession(vendedID, func() (interface{}, error) {
+		session := s.UiServer.GetLuaSession(vendedID)
+		if session == nil {
+			return nil, nil
+		}
+		L := session.State
+		diagnostics, ok := L.GetField(L.GetGlobal("mcp"), "diagnostics").(*lua.LTable)
+		if !ok {
+			return nil, nil
+		}
+		if result == nil {
+			L.SetField(diagnostics, app, lua.LNil)
+		} else {
+			L.SetField(diagnostics, app, session.GoToLua(toJSONValue(result)))
+		}
+		return nil, nil
+	})
+}
+
+// diagnosticsTable builds the initial mcp.diagnostics table from the latest results
+func (s *Server) diagnosticsTable(session *cli.LuaSession) *lua.LTable {
+	L := session.State
+	table := L.NewTable()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for app, result := range s.diagnostics {
+		L.SetField(table, app, session.GoToLua(toJSONValue(result)))
+	}
+	return table
+}
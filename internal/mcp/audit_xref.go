@@ -0,0 +1,210 @@
+package mcp
+
+// CRC: crc-Auditor.md | Seq: seq-audit.md
+// Cross-app analysis: mcp:app()/mcp:display() references and methods called on other apps' globals
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// Matches: mcp:app("name") or mcp:display("name")
+	// Captures: kind (app|display), app name
+	appRefPattern = regexp.MustCompile(`mcp:(app|display)\(\s*["']([^"']+)["']\s*\)`)
+
+	// Matches: [local] var = mcp:app("name")
+	// Captures: variable name, app name
+	appBindPattern = regexp.MustCompile(`(?:local\s+)?(\w+)\s*=\s*mcp:app\(\s*["']([^"']+)["']\s*\)`)
+
+	// Matches: mcp:app("name"):method(
+	// Captures: app name, method name
+	appChainPattern = regexp.MustCompile(`mcp:app\(\s*["']([^"']+)["']\s*\):(\w+)\s*\(`)
+
+	// Matches: receiver:method(
+	// Captures: receiver, method name
+	receiverCallPattern = regexp.MustCompile(`(\w+):(\w+)\s*\(`)
+)
+
+// appRef is a literal reference from one app to another
+type appRef struct {
+	target   string
+	kind     string // "app" or "display"
+	location string
+}
+
+// crossAppCall is a method called on another app's global
+type crossAppCall struct {
+	target   string
+	method   string
+	location string
+}
+
+// appLuaInfo summarizes what the cross-app pass needs from one app's Lua files
+type appLuaInfo struct {
+	defs         map[string]bool
+	hasFactories bool
+	refs         []appRef
+	calls        []crossAppCall
+}
+
+// crossAppIndex lazily scans app Lua files so each app is read at most once per pass
+type crossAppIndex struct {
+	baseDir string
+	apps    map[string]*appLuaInfo // nil entry means the app has no app.lua
+}
+
+func newCrossAppIndex(baseDir string) *crossAppIndex {
+	return &crossAppIndex{baseDir: baseDir, apps: make(map[string]*appLuaInfo)}
+}
+
+// get returns the scanned info for an app, or nil if the app does not exist
+func (idx *crossAppIndex) get(app string) *appLuaInfo {
+	if info, ok := idx.apps[app]; ok {
+		return info
+	}
+	info := scanAppLua(idx.baseDir, app)
+	idx.apps[app] = info
+	return info
+}
+
+// scanAppLua extracts method definitions, app references and cross-app calls from an app's Lua files.
+// Returns nil if the app has no app.lua.
+func scanAppLua(baseDir, app string) *appLuaInfo {
+	appPath := filepath.Join(baseDir, "apps", app)
+	if _, err := os.Stat(filepath.Join(appPath, "app.lua")); err != nil {
+		return nil
+	}
+
+	info := &appLuaInfo{defs: make(map[string]bool)}
+	luaFiles, _ := filepath.Glob(filepath.Join(appPath, "*.lua"))
+	sort.Strings(luaFiles)
+
+	for _, luaFile := range luaFiles {
+		content, err := os.ReadFile(luaFile)
+		if err != nil {
+			continue
+		}
+		text := string(content)
+		filename := filepath.Base(luaFile)
+		lines := strings.Split(text, "\n")
+
+		if len(detectFactoryFunctions(text)) > 0 {
+			info.hasFactories = true
+		}
+
+		// First pass: definitions and variables bound to mcp:app("...")
+		bindings := make(map[string]string) // variable -> target app
+		for _, line := range lines {
+			if match := methodDefPattern.FindStringSubmatch(line); match != nil {
+				info.defs[match[2]] = true
+			}
+			if match := appBindPattern.FindStringSubmatch(line); match != nil {
+				bindings[match[1]] = match[2]
+			}
+		}
+
+		// Second pass: references and calls on bound variables or other apps' globals
+		for i, line := range lines {
+			trimmed := strings.TrimSpace(line)
+			if strings.HasPrefix(trimmed, "--") {
+				continue
+			}
+			location := fmt.Sprintf("%s:%d", filename, i+1)
+
+			for _, match := range appRefPattern.FindAllStringSubmatch(line, -1) {
+				info.refs = append(info.refs, appRef{target: match[2], kind: match[1], location: location})
+				bindings[sanitizeAppName(match[2])] = match[2]
+			}
+			for _, match := range appChainPattern.FindAllStringSubmatch(line, -1) {
+				info.calls = append(info.calls, crossAppCall{target: match[1], method: match[2], location: location})
+			}
+			for _, match := range receiverCallPattern.FindAllStringSubmatch(line, -1) {
+				if target, ok := bindings[match[1]]; ok && target != app {
+					info.calls = append(info.calls, crossAppCall{target: target, method: match[2], location: location})
+				}
+			}
+		}
+	}
+
+	return info
+}
+
+// checkCrossAppRefs flags references to apps that don't exist and methods called on
+// another app's global that the target app's Lua doesn't define.
+// CRC: crc-Auditor.md
+func checkCrossAppRefs(idx *crossAppIndex, app string, result *AuditResult) {
+	info := idx.get(app)
+	if info == nil {
+		return
+	}
+
+	for _, ref := range info.refs {
+		if ref.target == app || idx.get(ref.target) != nil {
+			continue
+		}
+		result.Violations = append(result.Violations, Violation{
+			Type:     "broken_app_reference",
+			Location: ref.location,
+			Detail:   fmt.Sprintf("mcp:%s(%q) references an app with no apps/%s/app.lua", ref.kind, ref.target, ref.target),
+		})
+		result.Summary.CrossAppViolations++
+	}
+
+	reported := make(map[string]bool)
+	for _, call := range info.calls {
+		target := idx.get(call.target)
+		if target == nil || target.hasFactories || target.defs[call.method] || frameworkMethods[call.method] {
+			continue // broken references are reported above; factory methods aren't statically visible
+		}
+		key := call.target + ":" + call.method
+		if reported[key] {
+			continue
+		}
+		reported[key] = true
+		result.Violations = append(result.Violations, Violation{
+			Type:     "missing_cross_app_method",
+			Location: call.location,
+			Detail:   fmt.Sprintf("Method '%s()' called on app %s but not defined in its Lua", call.method, call.target),
+		})
+		result.Summary.CrossAppViolations++
+	}
+}
+
+// appDependencies returns the sorted, de-duplicated apps referenced by an app
+func (idx *crossAppIndex) appDependencies(app string) []string {
+	info := idx.get(app)
+	if info == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var deps []string
+	for _, ref := range info.refs {
+		if ref.target != app && !seen[ref.target] {
+			seen[ref.target] = true
+			deps = append(deps, ref.target)
+		}
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+// appsReferencing returns the other apps that depend on app (see appDependencies)
+func appsReferencing(baseDir, app string) []string {
+	apps, _ := ListApps(baseDir)
+	idx := newCrossAppIndex(baseDir)
+	var dependents []string
+	for _, other := range apps {
+		for _, dep := range idx.appDependencies(other) {
+			if dep == app {
+				dependents = append(dependents, other)
+				break
+			}
+		}
+	}
+	return dependents
+}
@@ -0,0 +1,359 @@
+package mcp
+
+// CRC: crc-LuaBatch.md | Seq: seq-mcp-batch.md
+// ui_batch: several Lua snippets and app displays in one executor turn, rolled back together
+// when a step fails
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// maxSnapshotTables bounds the state a batch copies for rollback
+const maxSnapshotTables = 100000
+
+// BatchStep is one ui_batch step: Lua code to run, or an app to display
+type BatchStep struct {
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// BatchStepResult is the outcome of one executed step
+type BatchStepResult struct {
+	Result    interface{} `json:"result"`              // Return value, null on error
+	Error     string      `json:"error,omitempty"`     // Error message, without the traceback
+	Traceback string      `json:"traceback,omitempty"` // Lua stack traceback of the error
+}
+
+// BatchResult is the ui_batch response. Steps holds the steps that ran, the failed one last.
+type BatchResult struct {
+	Steps      []BatchStepResult `json:"steps"`
+	Stdout     string            `json:"stdout"`                // print and io.write output during the batch
+	Error      string            `json:"error,omitempty"`       // Why the batch failed
+	FailedStep int               `json:"failed_step,omitempty"` // 1-based index of the failed step
+	RolledBack bool              `json:"rolled_back,omitempty"` // Session tables were restored
+	DurationMS float64           `json:"duration_ms"`           // Execution time in the session executor
+}
+
+// parseBatchSteps reads the steps argument: objects with either code or display
+func parseBatchSteps(value interface{}) ([]BatchStep, error) {
+	items, ok := value.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, fmt.Errorf("steps must be a non-empty array")
+	}
+	steps := make([]BatchStep, len(items))
+	for i, item := range items {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("step %d must be an object", i+1)
+		}
+		code, hasCode := fields["code"].(string)
+		display, hasDisplay := fields["display"].(string)
+		switch {
+		case hasCode == hasDisplay:
+			return nil, fmt.Errorf("step %d must have either code or display", i+1)
+		case hasDisplay && display == "":
+			return nil, fmt.Errorf("step %d: display must be an app name", i+1)
+		}
+		steps[i] = BatchStep{Code: code, Display: display}
+	}
+	return steps, nil
+}
+
+// Spec: mcp.md Section 5.7
+// CRC: crc-LuaBatch.md
+// Sequence: seq-mcp-batch.md
+func (s *Server) handleBatch(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return mcp.NewToolResultError("arguments must be a map"), nil
+	}
+	steps, err := parseBatchSteps(args["steps"])
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	sessionID, ok := args["sessionId"].(string)
+	if !ok || sessionID == "" {
+		sessionID = s.currentVendedID
+	}
+	if sessionID == "" {
+		return mcp.NewToolResultError("no active session - server may not have started correctly"), nil
+	}
+	session := s.UiServer.GetLuaSession(sessionID)
+	if session == nil {
+		return mcp.NewToolResultError(fmt.Sprintf("session %s not found", sessionID)), nil
+	}
+	timeout, err := runTimeout(args["timeout"])
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	profileName, _ := args["profile"].(string)
+	profile, err := ParseRunProfile(profileName)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	if profile != ProfileFull {
+		for i, step := range steps {
+			if step.Display != "" {
+				return mcp.NewToolResultError(fmt.Sprintf("step %d: display steps need the full profile", i+1)), nil
+			}
+		}
+	}
+	rollback := true
+	if value, ok := args["rollback"].(bool); ok {
+		rollback = value
+	}
+
+	// One executor turn for every step, so the browser gets a single update batch. The
+	// timeout covers the whole batch.
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var batch BatchResult
+	_, err = s.SafeExecuteInSession(sessionID, func() (interface{}, error) {
+		L := session.State
+		stdout, elapsed, err := s.inLuaContext(runCtx, L, sessionID, func() error {
+			var snap *luaSnapshot
+			if rollback {
+				var err error
+				if snap, err = snapshotLua(L, batchRoots(L, steps), maxSnapshotTables); err != nil {
+					return err
+				}
+			}
+			// Steps run as functions that keep their results for luaToGo, code steps in the
+			// profile's sandbox and display steps in the session's globals
+			var sb *sandbox
+			if profile != ProfileFull {
+				sb = newSandbox(L, profile)
+			}
+			defer L.SetGlobal(profileRunGlobal, lua.LNil)
+			for i, step := range steps {
+				value := lua.LValue(lua.LNil)
+				code, stepSandbox := step.Code, sb
+				if step.Display != "" {
+					code, stepSandbox = fmt.Sprintf("return mcp:display(%q)", step.Display), nil
+				}
+				L.SetGlobal(profileRunGlobal, L.NewFunction(captureRun(stepSandbox, &value)))
+				_, err := session.LoadCodeDirect(fmt.Sprintf("mcp-batch-%d", i+1), profileCode(code))
+				if err == nil && step.Display != "" && value == lua.LNil {
+					err = fmt.Errorf("failed to display app: %s", step.Display)
+				}
+				if err != nil {
+					var stepResult BatchStepResult
+					stepResult.Error, stepResult.Traceback = luaErrorParts(err)
+					batch.Steps = append(batch.Steps, stepResult)
+					batch.FailedStep = i + 1
+					if snap != nil {
+						snap.restore()
+						batch.RolledBack = true
+					}
+					return nil
+				}
+				batch.Steps = append(batch.Steps, BatchStepResult{Result: luaToGo(value)})
+			}
+			return nil
+		})
+		batch.Stdout, batch.DurationMS = stdout, durationMS(elapsed)
+		return nil, err
+	})
+
+	switch {
+	case err != nil:
+		batch.Error = "batch failed: " + err.Error()
+	case batch.FailedStep == 0:
+		jsonResult, _ := json.MarshalIndent(batch, "", "  ")
+		return mcp.NewToolResultText(string(jsonResult)), nil
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		batch.Error = fmt.Sprintf("step %d failed: timed out after %s", batch.FailedStep, timeout)
+	case errors.Is(ctx.Err(), context.Canceled):
+		batch.Error = fmt.Sprintf("step %d failed: cancelled by the client", batch.FailedStep)
+	default:
+		batch.Error = fmt.Sprintf("step %d failed: %s", batch.FailedStep, batch.Steps[len(batch.Steps)-1].Error)
+	}
+	s.logLua(LogEntry{Level: LogError, Session: sessionID, Source: logSourceRun, Message: batch.Error})
+	jsonResult, _ := json.MarshalIndent(batch, "", "  ")
+	return mcp.NewToolResultError(string(jsonResult)), nil
+}
+
+// handleAPIBatch handles POST /api/ui_batch; tokens limit it like /api/ui_run
+func (s *Server) handleAPIBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, http.StatusMethodNotAllowed, "POST required")
+		return
+	}
+	args, err := parseJSONBody(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	name, _ := args["profile"].(string)
+	profile, status, err := s.tokenProfile(r, name)
+	if err != nil {
+		apiError(w, status, err.Error())
+		return
+	}
+	args["profile"] = string(profile)
+	result, err := s.callMCPHandler(r.Context(), "ui_batch", s.handleBatch, args)
+	apiResponse(w, result, err)
+}
+
+// Rollback snapshots
+
+// luaSnapshot is a shallow copy of the tables a batch can reach: the globals table itself, and
+// everything reachable from the globals its steps name, through table keys, values,
+// metatables, function environments and closed upvalues, and the globals named by the Lua
+// functions found on the way. Restoring puts back the contents of tables that changed, and
+// the values of upvalues that were reassigned; tables created since are left to the garbage
+// collector.
+type luaSnapshot struct {
+	tables   map[*lua.LTable]*tableCopy
+	upvalues map[*lua.Upvalue]lua.LValue
+}
+
+// tableCopy is one table's entries and metatable when the snapshot was taken
+type tableCopy struct {
+	keys, values []lua.LValue
+	metatable    lua.LValue
+}
+
+// batchRoots compiles each step's code, without running it, so snapshotLua can find the
+// globals the steps name. Steps that do not compile fail when they run, before changing state.
+func batchRoots(L *lua.LState, steps []BatchStep) []*lua.LFunction {
+	var roots []*lua.LFunction
+	for i, step := range steps {
+		code := step.Code
+		if step.Display != "" {
+			code = fmt.Sprintf("return mcp:display(%q)", step.Display)
+		}
+		if fn, err := L.Load(strings.NewReader(profileCode(code)), fmt.Sprintf("mcp-batch-%d", i+1)); err == nil {
+			roots = append(roots, fn)
+		}
+	}
+	return roots
+}
+
+// snapshotLua copies the state roots can reach in L, failing when it holds more than limit
+// tables. The globals table is copied without following its entries; only the globals that
+// the functions reached name, in their string constants, are followed. State reached through
+// computed global names, such as _G[name], is not copied.
+func snapshotLua(L *lua.LState, roots []*lua.LFunction, limit int) (*luaSnapshot, error) {
+	snap := &luaSnapshot{tables: make(map[*lua.LTable]*tableCopy), upvalues: make(map[*lua.Upvalue]lua.LValue)}
+	globals := L.G.Global
+	snap.tables[globals] = copyTable(globals)
+	var pending []lua.LValue
+	for _, fn := range roots {
+		pending = append(pending, fn)
+	}
+	named := make(map[string]bool)
+	var nameGlobals func(proto *lua.FunctionProto)
+	nameGlobals = func(proto *lua.FunctionProto) {
+		for _, constant := range proto.Constants {
+			if name, ok := constant.(lua.LString); ok && !named[string(name)] {
+				named[string(name)] = true
+				pending = append(pending, globals.RawGet(name))
+			}
+		}
+		for _, nested := range proto.FunctionPrototypes {
+			nameGlobals(nested)
+		}
+	}
+	seenFunctions := make(map[*lua.LFunction]bool)
+	for len(pending) > 0 {
+		value := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		switch v := value.(type) {
+		case *lua.LTable:
+			if snap.tables[v] != nil {
+				continue
+			}
+			if len(snap.tables) >= limit {
+				return nil, fmt.Errorf("session state is too large to snapshot for rollback (over %d tables); use rollback=false", limit)
+			}
+			copied := copyTable(v)
+			pending = append(pending, copied.keys...)
+			pending = append(pending, copied.values...)
+			snap.tables[v] = copied
+			pending = append(pending, v.Metatable)
+		case *lua.LFunction:
+			if seenFunctions[v] {
+				continue
+			}
+			seenFunctions[v] = true
+			if v.Proto != nil {
+				nameGlobals(v.Proto)
+			}
+			if v.Env != nil {
+				pending = append(pending, v.Env)
+			}
+			for _, upvalue := range v.Upvalues {
+				if upvalue != nil && upvalue.IsClosed() {
+					snap.upvalues[upvalue] = upvalue.Value()
+					pending = append(pending, upvalue.Value())
+				}
+			}
+		case *lua.LUserData:
+			pending = append(pending, v.Metatable)
+		}
+	}
+	return snap, nil
+}
+
+// copyTable copies a table's entries and metatable
+func copyTable(tbl *lua.LTable) *tableCopy {
+	copied := &tableCopy{metatable: tbl.Metatable}
+	tbl.ForEach(func(key, value lua.LValue) {
+		copied.keys = append(copied.keys, key)
+		copied.values = append(copied.values, value)
+	})
+	return copied
+}
+
+// restore puts back changed tables and upvalues and returns how many tables it restored
+func (snap *luaSnapshot) restore() int {
+	restored := 0
+	for tbl, copied := range snap.tables {
+		if copied.matches(tbl) {
+			continue
+		}
+		var current []lua.LValue
+		tbl.ForEach(func(key, _ lua.LValue) { current = append(current, key) })
+		for _, key := range current {
+			tbl.RawSet(key, lua.LNil)
+		}
+		for i, key := range copied.keys {
+			tbl.RawSet(key, copied.values[i])
+		}
+		tbl.Metatable = copied.metatable
+		restored++
+	}
+	for upvalue, value := range snap.upvalues {
+		if upvalue.Value() != value {
+			upvalue.SetValue(value)
+		}
+	}
+	return restored
+}
+
+// matches reports whether tbl still holds exactly the copied entries and metatable
+func (copied *tableCopy) matches(tbl *lua.LTable) bool {
+	if tbl.Metatable != copied.metatable {
+		return false
+	}
+	count := 0
+	tbl.ForEach(func(_, _ lua.LValue) { count++ })
+	if count != len(copied.keys) {
+		return false
+	}
+	for i, key := range copied.keys {
+		if tbl.RawGet(key) != copied.values[i] {
+			return false
+		}
+	}
+	return true
+}
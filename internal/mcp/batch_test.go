@@ -0,0 +1,144 @@
+// Package mcp tests for ui_batch
+// Test: test-LuaBatch.md
+package mcp
+
+import (
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// R208-R211: Batch Tests
+// Test Design: test-LuaBatch.md
+
+// snapshotFor snapshots the state the code can reach, as handleBatch does for its steps
+func snapshotFor(t *testing.T, L *lua.LState, code string, limit int) (*luaSnapshot, error) {
+	t.Helper()
+	return snapshotLua(L, batchRoots(L, []BatchStep{{Code: code}}), limit)
+}
+
+// TestBatchRollback tests that restoring a snapshot undoes a failed batch's changes
+func TestBatchRollback(t *testing.T) {
+	L := newProfileState(t)
+	err := L.DoString(`
+		local calls = 0
+		function countCall() calls = calls + 1 return calls end
+		untouched = {a = 1}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := `
+		mcp.value:add("bread")
+		mcp.value.items[1].name = "cheese"
+		mcp.value.extra = {1, 2, 3}
+		setmetatable(mcp.value, nil)
+		countCall()
+		added = true
+		error("step failed")
+	`
+	snap, err := snapshotFor(t, L, step, maxSnapshotTables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = L.DoString(step)
+	if err == nil {
+		t.Fatal("expected the step to fail")
+	}
+	if restored := snap.restore(); restored != 4 {
+		t.Errorf("expected 4 restored tables (globals, value, items, first item), got %d", restored)
+	}
+	err = L.DoString(`
+		assert(#mcp.value.items == 2, "items")
+		assert(mcp.value.items[1].name == "milk", "name")
+		assert(mcp.value.extra == nil, "extra")
+		assert(mcp.value:count() == 2, "metatable")
+		assert(added == nil, "global")
+		assert(countCall() == 1, "upvalue")
+		assert(untouched.a == 1, "untouched")
+	`)
+	if err != nil {
+		t.Errorf("state not restored: %v", err)
+	}
+}
+
+// TestBatchSnapshotLimit tests that too much state refuses rollback instead of copying it
+func TestBatchSnapshotLimit(t *testing.T) {
+	L := newProfileState(t)
+	if err := L.DoString(`big = {} for i = 1, 50 do big[i] = {} end`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshotFor(t, L, `big[1].x = 1`, 20); err == nil || !strings.Contains(err.Error(), "rollback=false") {
+		t.Errorf("expected a size error, got %v", err)
+	}
+}
+
+// TestBatchSnapshotReach tests that only the globals the steps and their functions name are copied
+func TestBatchSnapshotReach(t *testing.T) {
+	L := newProfileState(t)
+	err := L.DoString(`
+		big = {} for i = 1, 50 do big[i] = {} end
+		settings = {theme = "dark"}
+		function applyTheme() settings.theme = "light" end
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := snapshotFor(t, L, `counter = 1 applyTheme()`, 20)
+	if err != nil {
+		t.Fatalf("expected big to be skipped, got %v", err)
+	}
+	if snap.tables[L.GetGlobal("big").(*lua.LTable)] != nil {
+		t.Error("big is not named by the step and should not be copied")
+	}
+	if err := L.DoString(`counter = 1 applyTheme()`); err != nil {
+		t.Fatal(err)
+	}
+	snap.restore()
+	if err := L.DoString(`assert(counter == nil and settings.theme == "dark")`); err != nil {
+		t.Errorf("state reached through applyTheme not restored: %v", err)
+	}
+}
+
+// TestBatchSnapshotCycles tests that cycles and shared tables are copied once
+func TestBatchSnapshotCycles(t *testing.T) {
+	L := newProfileState(t)
+	if err := L.DoString(`a = {} b = {a = a} a.b = b a[a] = b`); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := snapshotFor(t, L, `return a`, maxSnapshotTables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := L.GetGlobal("a").(*lua.LTable)
+	if snap.tables[a] == nil || len(snap.tables[a].keys) != 2 {
+		t.Errorf("expected a copied once with 2 keys, got %v", snap.tables[a])
+	}
+	if restored := snap.restore(); restored != 0 {
+		t.Errorf("expected nothing to restore, got %d", restored)
+	}
+}
+
+// TestParseBatchSteps tests step validation
+func TestParseBatchSteps(t *testing.T) {
+	steps, err := parseBatchSteps([]interface{}{
+		map[string]interface{}{"code": "x = 1"},
+		map[string]interface{}{"display": "todo"},
+	})
+	if err != nil || len(steps) != 2 || steps[0].Code != "x = 1" || steps[1].Display != "todo" {
+		t.Errorf("unexpected steps %v, %v", steps, err)
+	}
+	for _, bad := range []interface{}{
+		nil,
+		[]interface{}{},
+		[]interface{}{"x = 1"},
+		[]interface{}{map[string]interface{}{}},
+		[]interface{}{map[string]interface{}{"code": "x", "display": "todo"}},
+		[]interface{}{map[string]interface{}{"display": ""}},
+	} {
+		if _, err := parseBatchSteps(bad); err == nil {
+			t.Errorf("%v: expected an error", bad)
+		}
+	}
+}
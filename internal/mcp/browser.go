@@ -0,0 +1,166 @@
+package mcp
+
+// CRC: crc-MCPTool.md | Seq: seq-mcp-lifecycle.md
+// Browser opener selection for ui_open_browser: settings, $BROWSER, then the platform default
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenBrowserResult is the ui_open_browser response. Opened is false, without an error, when a
+// browser is already connected or no opener is available, so the agent can pass URL on.
+type OpenBrowserResult struct {
+	URL      string `json:"url"`
+	Opened   bool   `json:"opened"`
+	Browsers int    `json:"browsers"`          // Browsers connected to the session, before opening
+	Command  string `json:"command,omitempty"` // Opener run, or that would have run
+	Source   string `json:"source,omitempty"`  // Where the opener came from: settings, BROWSER or platform
+	Reason   string `json:"reason,omitempty"`  // Why nothing was opened
+}
+
+// browserOpener is a command that opens a URL. %s in an argument is replaced by the URL;
+// without one, the URL is appended.
+type browserOpener struct {
+	argv   []string
+	source string
+}
+
+// command returns the opener's arguments for url
+func (o browserOpener) command(url string) []string {
+	argv := make([]string, 0, len(o.argv)+1)
+	substituted := false
+	for _, arg := range o.argv {
+		if strings.Contains(arg, "%s") {
+			arg = strings.ReplaceAll(arg, "%s", url)
+			substituted = true
+		}
+		argv = append(argv, arg)
+	}
+	if !substituted {
+		argv = append(argv, url)
+	}
+	return argv
+}
+
+// findBrowserOpener chooses how to open a browser: storage/settings.json "browser", then the
+// first $BROWSER entry (colon-separated) that is installed, then the platform's opener. On
+// Linux and other Unix systems xdg-open needs a desktop ($DISPLAY or $WAYLAND_DISPLAY). It
+// reports false when there is no way to open one, as on a headless server or in a container.
+func findBrowserOpener(configured, goos string, getenv func(string) string, lookPath func(string) (string, error)) (browserOpener, bool) {
+	if argv := strings.Fields(configured); len(argv) > 0 {
+		return browserOpener{argv: argv, source: "settings"}, true
+	}
+	for _, entry := range strings.Split(getenv("BROWSER"), ":") {
+		argv := strings.Fields(entry)
+		if len(argv) == 0 {
+			continue
+		}
+		if _, err := lookPath(argv[0]); err == nil {
+			return browserOpener{argv: argv, source: "BROWSER"}, true
+		}
+	}
+	switch goos {
+	case "darwin":
+		return browserOpener{argv: []string{"open"}, source: "platform"}, true
+	case "windows":
+		return browserOpener{argv: []string{"cmd", "/c", "start"}, source: "platform"}, true
+	}
+	if getenv("DISPLAY") == "" && getenv("WAYLAND_DISPLAY") == "" {
+		return browserOpener{}, false
+	}
+	if _, err := lookPath("xdg-open"); err != nil {
+		return browserOpener{}, false
+	}
+	return browserOpener{argv: []string{"xdg-open"}, source: "platform"}, true
+}
+
+// browserKeepalive is how often a presence stream sends a comment, so proxies and the
+// browser keep it open and a closed tab is noticed
+const browserKeepalive = 30 * time.Second
+
+// handleBrowserPresence handles GET /browser-presence, a text/event-stream each page holds open
+// (the frictionless block in index.html starts it). The UI engine cannot report the browsers on a
+// session, so an open stream counts as one connected browser on the MCP session. A ui-session
+// cookie naming another session is not counted.
+// Spec: mcp.md Section 5.3
+// CRC: crc-MCPTool.md
+func (s *Server) handleBrowserPresence(w http.ResponseWriter, r *http.Request) {
+	vendedID := s.presenceSession(r)
+	if vendedID == "" {
+		http.Error(w, "No active session", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	s.addBrowser(vendedID, 1)
+	defer s.addBrowser(vendedID, -1)
+
+	ticker := time.NewTicker(browserKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// presenceSession returns the MCP session a presence request belongs to, or "" if none
+func (s *Server) presenceSession(r *http.Request) string {
+	s.mu.RLock()
+	vendedID := s.currentVendedID
+	s.mu.RUnlock()
+	if vendedID == "" {
+		return ""
+	}
+	if cookie, err := r.Cookie("ui-session"); err == nil && cookie.Value != "" && s.UiServer != nil {
+		if internalID := s.UiServer.GetSessions().GetInternalID(vendedID); internalID != "" && cookie.Value != internalID {
+			return ""
+		}
+	}
+	return vendedID
+}
+
+// addBrowser adjusts a session's count of connected browsers
+func (s *Server) addBrowser(vendedID string, delta int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := s.browsers[vendedID] + delta; n > 0 {
+		s.browsers[vendedID] = n
+	} else {
+		delete(s.browsers, vendedID)
+	}
+}
+
+// connectedBrowsers returns how many browsers hold a presence stream on a session
+func (s *Server) connectedBrowsers(vendedID string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.browsers[vendedID]
+}
+
+// GetBrowserSetting reads storage/settings.json "browser", the command ui_open_browser runs
+func GetBrowserSetting(baseDir string) string {
+	settings, err := readSettings(baseDir)
+	if err != nil {
+		return ""
+	}
+	browser, _ := settings["browser"].(string)
+	return browser
+}
@@ -0,0 +1,150 @@
+// Package mcp tests for browser opener selection
+// Test: test-MCP.md
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/zot/ui-engine/cli"
+)
+
+// R228-R230: Browser Opener Tests
+// Test Design: test-MCP.md
+
+// TestFindBrowserOpener tests the settings, $BROWSER, platform and headless choices
+func TestFindBrowserOpener(t *testing.T) {
+	installed := map[string]bool{"firefox": true, "xdg-open": true}
+	lookPath := func(name string) (string, error) {
+		if installed[name] {
+			return "/usr/bin/" + name, nil
+		}
+		return "", fmt.Errorf("%s not found", name)
+	}
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+	tests := []struct {
+		name       string
+		configured string
+		goos       string
+		vars       map[string]string
+		want       string // source: argv, or "" for none
+	}{
+		{"setting wins", "chromium --new-window", "linux", map[string]string{"BROWSER": "firefox"}, "settings: chromium --new-window"},
+		{"BROWSER skips missing", "", "linux", map[string]string{"BROWSER": "w3m:firefox %s"}, "BROWSER: firefox %s"},
+		{"desktop", "", "linux", map[string]string{"DISPLAY": ":0"}, "platform: xdg-open"},
+		{"wayland", "", "freebsd", map[string]string{"WAYLAND_DISPLAY": "wayland-0"}, "platform: xdg-open"},
+		{"headless", "", "linux", map[string]string{}, ""},
+		{"headless BROWSER missing", "", "linux", map[string]string{"BROWSER": "w3m"}, ""},
+		{"darwin", "", "darwin", map[string]string{}, "platform: open"},
+		{"windows", "", "windows", map[string]string{}, "platform: cmd /c start"},
+	}
+	for _, test := range tests {
+		opener, ok := findBrowserOpener(test.configured, test.goos, env(test.vars), lookPath)
+		got := ""
+		if ok {
+			got = opener.source + ": " + strings.Join(opener.argv, " ")
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// TestBrowserOpenerCommand tests URL substitution
+func TestBrowserOpenerCommand(t *testing.T) {
+	url := "http://127.0.0.1:8000/?conserve=true"
+	if got := strings.Join(browserOpener{argv: []string{"open"}}.command(url), " "); got != "open "+url {
+		t.Errorf("append: got %s", got)
+	}
+	if got := strings.Join(browserOpener{argv: []string{"firefox", "--url=%s", "-new-tab"}}.command(url), " "); got != "firefox --url="+url+" -new-tab" {
+		t.Errorf("substitute: got %s", got)
+	}
+}
+
+// TestGetBrowserSetting tests reading "browser" from storage/settings.json
+func TestGetBrowserSetting(t *testing.T) {
+	baseDir := t.TempDir()
+	if got := GetBrowserSetting(baseDir); got != "" {
+		t.Errorf("expected no setting, got %q", got)
+	}
+	os.MkdirAll(filepath.Join(baseDir, "storage"), 0755)
+	os.WriteFile(filepath.Join(baseDir, "storage", "settings.json"), []byte(`{"browser": "firefox -P work"}`), 0644)
+	if got := GetBrowserSetting(baseDir); got != "firefox -P work" {
+		t.Errorf("got %q", got)
+	}
+}
+
+// TestOpenBrowserConnected tests that ui_open_browser opens no tab while a browser holds a
+// presence stream on the MCP session, even though that is the only engine session
+func TestOpenBrowserConnected(t *testing.T) {
+	if _, err := exec.LookPath("true"); err != nil {
+		t.Skip("needs the true command as a stand-in browser")
+	}
+	baseDir := t.TempDir()
+	os.MkdirAll(filepath.Join(baseDir, "storage"), 0755)
+	os.WriteFile(filepath.Join(baseDir, "storage", "settings.json"), []byte(`{"browser": "true"}`), 0644)
+	s := NewServer(cli.DefaultConfig(), nil, nil, nil, func() int { return 1 })
+	s.baseDir = baseDir
+	s.state = Running
+	s.url = "http://127.0.0.1:8000"
+	s.currentVendedID = "1"
+
+	open := func(args map[string]interface{}) OpenBrowserResult {
+		t.Helper()
+		var req mcp.CallToolRequest
+		req.Params.Arguments = args
+		res, err := s.handleOpenBrowser(context.Background(), req)
+		if err != nil || res.IsError {
+			t.Fatalf("unexpected error %v %+v", err, res)
+		}
+		var result OpenBrowserResult
+		if err := json.Unmarshal([]byte(res.Content[0].(mcp.TextContent).Text), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	if result := open(map[string]interface{}{}); !result.Opened || result.Browsers != 0 {
+		t.Errorf("expected a tab to open with no browser connected, got %+v", result)
+	}
+
+	// One browser on the MCP session
+	ts := httptest.NewServer(http.HandlerFunc(s.handleBrowserPresence))
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+	if !strings.HasPrefix(line, ": connected") {
+		t.Fatalf("expected the presence stream to start, got %q", line)
+	}
+	if result := open(map[string]interface{}{}); result.Opened || result.Browsers != 1 || !strings.Contains(result.Reason, "already connected") {
+		t.Errorf("expected the connected browser to short-circuit, got %+v", result)
+	}
+	if result := open(map[string]interface{}{"force": true}); !result.Opened || result.Command != "true" || result.Source != "settings" {
+		t.Errorf("expected force to open, got %+v", result)
+	}
+
+	// Closing the tab ends the stream
+	resp.Body.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.connectedBrowsers("1") != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if result := open(map[string]interface{}{}); !result.Opened || result.Browsers != 0 {
+		t.Errorf("expected a closed tab not to count, got %+v", result)
+	}
+}
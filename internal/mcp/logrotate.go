@@ -0,0 +1,248 @@
+package mcp
+
+// CRC: crc-MCPServer.md | Seq: seq-mcp-lifecycle.md
+// Log rotation: archives {base_dir}/log files by size and age instead of deleting them on configure
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// rotatedLogs are the files in {base_dir}/log that rotate; other files are left alone
+var rotatedLogs = []string{"mcp.log", "lua.log", "lua-err.log", "lua.jsonl"}
+
+// logRotationInterval is how often logs are checked against their size and age limits
+const logRotationInterval = time.Minute
+
+// LogRotation is storage/settings.json "logRotation". A zero limit disables that trigger;
+// zero generations deletes logs instead of archiving them.
+type LogRotation struct {
+	MaxSizeMB   int `json:"maxSizeMB"`   // Rotate a log once it grows past this size
+	MaxAgeHours int `json:"maxAgeHours"` // Rotate a log once it has been written to for this long
+	Generations int `json:"generations"` // Archives kept per log, NAME.1 newest
+}
+
+// DefaultLogRotation applies to settings missing from storage/settings.json
+var DefaultLogRotation = LogRotation{MaxSizeMB: 10, MaxAgeHours: 24, Generations: 5}
+
+// GetLogRotation reads the rotation settings, falling back to DefaultLogRotation field by field
+func GetLogRotation(baseDir string) LogRotation {
+	rot := DefaultLogRotation
+	settings, err := readSettings(baseDir)
+	if err != nil {
+		return rot
+	}
+	values, _ := settings["logRotation"].(map[string]interface{})
+	for key, field := range map[string]*int{"maxSizeMB": &rot.MaxSizeMB, "maxAgeHours": &rot.MaxAgeHours, "generations": &rot.Generations} {
+		if n, ok := values[key].(float64); ok && n >= 0 {
+			*field = int(n)
+		}
+	}
+	return rot
+}
+
+// rotationDue reports whether a log has passed its size limit, or its age limit measured from started
+func rotationDue(info os.FileInfo, started, now time.Time, rot LogRotation) bool {
+	if info.Size() == 0 {
+		return false
+	}
+	if rot.MaxSizeMB > 0 && info.Size() > int64(rot.MaxSizeMB)*1024*1024 {
+		return true
+	}
+	return rot.MaxAgeHours > 0 && now.Sub(started) > time.Duration(rot.MaxAgeHours)*time.Hour
+}
+
+// RotateLog renames path to path.1, shifting path.1 to path.2 and so on, and removes archives
+// past generations. With zero generations the log is removed.
+func RotateLog(path string, generations int) error {
+	for _, archive := range logArchives(path) {
+		if n := archiveNumber(path, archive); n >= generations {
+			if err := os.Remove(archive); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	if generations == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	for n := generations - 1; n >= 1; n-- {
+		from := fmt.Sprintf("%s.%d", path, n)
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", path, n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(path, path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// logArchives returns path's numbered archives, oldest (highest number) first
+func logArchives(path string) []string {
+	matches, _ := filepath.Glob(path + ".*")
+	var archives []string
+	for _, match := range matches {
+		if archiveNumber(path, match) > 0 {
+			archives = append(archives, match)
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		return archiveNumber(path, archives[i]) > archiveNumber(path, archives[j])
+	})
+	return archives
+}
+
+// archiveNumber returns N for path.N, or 0 if archive is not a numbered archive of path
+func archiveNumber(path, archive string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(archive, path+"."))
+	if err != nil || !strings.HasPrefix(archive, path+".") || n < 1 {
+		return 0
+	}
+	return n
+}
+
+// RotateLogs archives the logs in {base_dir}/log. With force every non-empty log rotates
+// (reconfigure); otherwise only logs past their size or age limit do. After rotating, the
+// onClearLogs callback reopens the Go log, and the structured log and the session's Lua output
+// are reopened on fresh files.
+// Spec: mcp.md Section 5.1 - ui_configure rotates logs
+// CRC: crc-MCPServer.md
+func (s *Server) RotateLogs(force bool) error {
+	return s.rotateLogs(force, true)
+}
+
+// rotateLogs is RotateLogs; reopen is false when the caller replaces the Lua output itself
+func (s *Server) rotateLogs(force, reopen bool) error {
+	s.mu.RLock()
+	baseDir := s.baseDir
+	callback := s.onClearLogs
+	s.mu.RUnlock()
+
+	if baseDir == "" {
+		return nil // No base directory configured yet
+	}
+
+	logDir := filepath.Join(baseDir, "log")
+	if _, err := os.Stat(logDir); os.IsNotExist(err) {
+		return nil // Log directory doesn't exist, nothing to rotate
+	}
+
+	rot := GetLogRotation(baseDir)
+	now := time.Now()
+	rotated := false
+	var firstErr error
+	for _, name := range rotatedLogs {
+		path := filepath.Join(logDir, name)
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		s.mu.Lock()
+		if s.logStarted == nil {
+			s.logStarted = make(map[string]time.Time)
+		}
+		started, ok := s.logStarted[name]
+		if !ok {
+			// A log that predates this server is aged from when it was first seen
+			started = now
+			s.logStarted[name] = now
+		}
+		s.mu.Unlock()
+		if !(force && info.Size() > 0) && !rotationDue(info, started, now, rot) {
+			continue
+		}
+		if err := RotateLog(path, rot.Generations); err != nil {
+			s.cfg.Log(1, "Warning: failed to rotate log file %s: %v", path, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		s.mu.Lock()
+		s.logStarted[name] = now
+		s.mu.Unlock()
+		rotated = true
+	}
+
+	if rotated || force {
+		// Call callback to reopen Go log file handles
+		if callback != nil {
+			callback()
+		}
+		if reopen {
+			s.reopenLuaOutput()
+		}
+	}
+	return firstErr
+}
+
+// reopenLuaOutput points the structured log and the current session's Lua output at fresh files
+// after rotation renamed the ones they had open
+func (s *Server) reopenLuaOutput() {
+	s.mu.RLock()
+	luaLog := s.luaLog
+	vendedID := s.currentVendedID
+	logPath, errPath := s.logPath, s.errPath
+	s.mu.RUnlock()
+
+	if luaLog != nil {
+		if err := luaLog.Reopen(); err != nil {
+			s.cfg.Log(1, "Warning: failed to reopen Lua log: %v", err)
+		}
+	}
+	if s.UiServer == nil || vendedID == "" || logPath == "" || errPath == "" {
+		return
+	}
+	session := s.UiServer.GetLuaSession(vendedID)
+	if session == nil {
+		return
+	}
+	s.SafeExecuteInSession(vendedID, func() (interface{}, error) {
+		if err := session.RedirectOutput(logPath, errPath); err != nil {
+			s.cfg.Log(0, "Warning: failed to redirect Lua output: %v", err)
+		}
+		// Redirection may replace print; wrap it again so entries are still recorded
+		if mcpTable, ok := session.State.GetGlobal("mcp").(*lua.LTable); ok {
+			s.wrapLuaOutput(session.State, mcpTable, vendedID)
+		}
+		return nil, nil
+	})
+}
+
+// startLogRotation checks the logs against their limits every minute, replacing the
+// checker from a previous configuration
+// CRC: crc-MCPServer.md
+func (s *Server) startLogRotation() {
+	done := make(chan struct{})
+	s.mu.Lock()
+	if s.stopLogRotation != nil {
+		s.stopLogRotation()
+	}
+	s.stopLogRotation = func() { close(done) }
+	s.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(logRotationInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := s.RotateLogs(false); err != nil {
+					s.cfg.Log(1, "Warning: log rotation failed: %v", err)
+				}
+			}
+		}
+	}()
+}
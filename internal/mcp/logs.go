@@ -0,0 +1,448 @@
+package mcp
+
+// CRC: crc-LuaLog.md | Seq: seq-lua-log.md
+// Structured Lua log: JSON lines with time, level, session and app, queried by ui_logs and /api/logs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Log levels, lowest severity first
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+// Log entry sources
+const (
+	logSourcePrint  = "print"  // Lua print()
+	logSourceLog    = "log"    // mcp:log(level, ...)
+	logSourceStderr = "stderr" // A line of log/lua-err.log
+	logSourceRun    = "run"    // ui_run execution error
+)
+
+// logLevels ranks the levels for minimum-severity filtering
+var logLevels = map[string]int{LogDebug: 0, LogInfo: 1, LogWarn: 2, LogError: 3}
+
+// Default and maximum number of entries a query returns
+const (
+	defaultLogLimit = 100
+	maxLogLimit     = 5000
+)
+
+// errTailInterval is how often lua-err.log is polled for new lines
+const errTailInterval = 500 * time.Millisecond
+
+// Matches: apps/NAME/ in a Lua error or traceback line
+// Captures: app name
+var appPathPattern = regexp.MustCompile(`apps/([\w-]+)/`)
+
+// LogEntry is one line of log/lua.jsonl
+type LogEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Session string    `json:"session,omitempty"`
+	App     string    `json:"app,omitempty"`
+	Source  string    `json:"source"`
+	Message string    `json:"message"`
+}
+
+// LogQuery selects log entries. Empty fields match everything; Level is a minimum severity.
+type LogQuery struct {
+	App     string
+	Level   string
+	Session string
+	Since   time.Time
+	Limit   int // Most recent entries returned (defaults to 100)
+}
+
+// StructuredLog appends entries to a JSON lines file
+type StructuredLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// OpenStructuredLog opens (creating if needed) a JSON lines log for appending
+func OpenStructuredLog(path string) (*StructuredLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening log: %w", err)
+	}
+	return &StructuredLog{path: path, file: file}, nil
+}
+
+// Write appends an entry, filling in the time and normalizing the level
+func (l *StructuredLog) Write(entry LogEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	if _, ok := logLevels[entry.Level]; !ok {
+		entry.Level = LogInfo
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return fmt.Errorf("log closed")
+	}
+	_, err = l.file.Write(append(data, '\n'))
+	return err
+}
+
+// Reopen closes the log file and opens path afresh, after rotation renamed it
+func (l *StructuredLog) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+	}
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		l.file = nil
+		return fmt.Errorf("opening log: %w", err)
+	}
+	l.file = file
+	return nil
+}
+
+// Close closes the log file; later writes fail
+func (l *StructuredLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Query returns the most recent entries matching q, oldest first, reading rotated archives
+// before the current file. Unparseable lines are skipped.
+func (l *StructuredLog) Query(q LogQuery) ([]LogEntry, error) {
+	minLevel, ok := logLevels[q.Level]
+	if q.Level != "" && !ok {
+		return nil, fmt.Errorf("unknown level: %s (use: debug, info, warn, error)", q.Level)
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultLogLimit
+	} else if limit > maxLogLimit {
+		limit = maxLogLimit
+	}
+
+	// Only opening the files holds the lock, so writers are not blocked by the scan. Open handles
+	// keep reading the same files if rotation renames them, and the current file is read up to
+	// its size now, leaving later writes for the next query.
+	l.mu.Lock()
+	var files []io.Reader
+	var closers []*os.File
+	for _, path := range append(logArchives(l.path), l.path) {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			l.mu.Unlock()
+			for _, f := range closers {
+				f.Close()
+			}
+			return nil, fmt.Errorf("reading log: %w", err)
+		}
+		closers = append(closers, file)
+		var reader io.Reader = file
+		if path == l.path {
+			if info, err := file.Stat(); err == nil {
+				reader = io.LimitReader(file, info.Size())
+			}
+		}
+		files = append(files, reader)
+	}
+	l.mu.Unlock()
+	defer func() {
+		for _, f := range closers {
+			f.Close()
+		}
+	}()
+
+	entries := []LogEntry{}
+	for _, file := range files {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			var entry LogEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue
+			}
+			if logLevels[entry.Level] < minLevel ||
+				(q.App != "" && entry.App != q.App) ||
+				(q.Session != "" && entry.Session != q.Session) ||
+				(!q.Since.IsZero() && entry.Time.Before(q.Since)) {
+				continue
+			}
+			entries = append(entries, entry)
+			if len(entries) > limit {
+				entries = entries[1:]
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading log: %w", err)
+		}
+	}
+	return entries, nil
+}
+
+// ParseLogSince parses a since value: an RFC 3339 time or a duration back from now (e.g. "5m")
+func ParseLogSince(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid since %q (use RFC 3339 or a duration like 5m)", value)
+	}
+	return now.Add(-d), nil
+}
+
+// TailErrLog polls the Lua stderr log and records each new line as an error entry, attributed
+// to the app named in its apps/NAME/ path. The file may be truncated or recreated by log
+// rotation; reading restarts from the top when it shrinks or is replaced. Returns a stop function.
+func TailErrLog(path, session string, log *StructuredLog) func() {
+	done := make(chan struct{})
+	go func() {
+		var offset int64
+		var partial string
+		var last os.FileInfo
+		ticker := time.NewTicker(errTailInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				offset, partial = 0, ""
+				continue
+			}
+			if info.Size() < offset || (last != nil && !os.SameFile(last, info)) {
+				// Truncated, or replaced by rotation
+				offset, partial = 0, ""
+			}
+			last = info
+			if info.Size() == offset {
+				continue
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			data, err := io.ReadAll(io.NewSectionReader(file, offset, info.Size()-offset))
+			file.Close()
+			if err != nil {
+				continue
+			}
+			offset += int64(len(data))
+			lines := strings.Split(partial+string(data), "\n")
+			partial = lines[len(lines)-1] // Incomplete last line waits for the next poll
+			for _, line := range lines[:len(lines)-1] {
+				if strings.TrimSpace(line) == "" {
+					continue
+				}
+				app := ""
+				if m := appPathPattern.FindStringSubmatch(line); m != nil {
+					app = m[1]
+				}
+				log.Write(LogEntry{Level: LogError, Session: session, App: app, Source: logSourceStderr, Message: line})
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// luaLogPath returns the path of the structured Lua log
+func luaLogPath(baseDir string) string {
+	return filepath.Join(baseDir, "log", "lua.jsonl")
+}
+
+// logLua records an entry in the structured Lua log, if one is open
+func (s *Server) logLua(entry LogEntry) {
+	s.mu.RLock()
+	log := s.luaLog
+	s.mu.RUnlock()
+	if log == nil {
+		return
+	}
+	if err := log.Write(entry); err != nil {
+		s.cfg.Log(2, "Warning: failed to write Lua log: %v", err)
+	}
+}
+
+// openLuaLog opens log/lua.jsonl for the configured base directory, closing any previous log
+// and stderr tailer. Called by Configure after the logs are rotated.
+// CRC: crc-LuaLog.md
+func (s *Server) openLuaLog(baseDir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopErrTail != nil {
+		s.stopErrTail()
+		s.stopErrTail = nil
+	}
+	if s.luaLog != nil {
+		s.luaLog.Close()
+		s.luaLog = nil
+	}
+	log, err := OpenStructuredLog(luaLogPath(baseDir))
+	if err != nil {
+		s.cfg.Log(0, "Warning: failed to open Lua log: %v", err)
+		return
+	}
+	s.luaLog = log
+}
+
+// startErrTail records the session's Lua stderr lines in the structured log.
+// Called once the session's output is redirected.
+// CRC: crc-LuaLog.md
+func (s *Server) startErrTail(vendedID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.luaLog == nil || s.errPath == "" {
+		return
+	}
+	if s.stopErrTail != nil {
+		s.stopErrTail()
+	}
+	s.stopErrTail = TailErrLog(s.errPath, vendedID, s.luaLog)
+}
+
+// maxRunOutput caps the output a ui_run call captures
+const maxRunOutput = 1 << 20
+
+// registerLogMethods wraps the session's output functions (see wrapLuaOutput) and adds
+// mcp:log(level, ...). Entries are attributed to the app mcp.value displays.
+// CRC: crc-LuaLog.md | Seq: seq-lua-log.md
+func (s *Server) registerLogMethods(vendedID string, mcpTable *lua.LTable) {
+	session := s.UiServer.GetLuaSession(vendedID)
+	if session == nil {
+		return
+	}
+	L := session.State
+	s.wrapLuaOutput(L, mcpTable, vendedID)
+
+	// mcp:log(level, ...) - record a structured entry; an unknown level is recorded as info
+	L.SetField(mcpTable, "log", L.NewFunction(func(L *lua.LState) int {
+		level := L.CheckString(2) // arg 1 is self (colon notation)
+		s.logLua(LogEntry{Level: level, Session: vendedID, App: displayedAppName(L, mcpTable), Source: logSourceLog, Message: luaArgsMessage(L, 3, "\t")})
+		return 0
+	}))
+}
+
+// wrapLuaOutput wraps print and io.write so each print is recorded as an info entry and both
+// are captured while a ui_run call is in progress, before calling the originals. Functions that
+// are already wrapped are left alone, so it is safe to call again after output is redirected.
+// Must run inside the session's executor.
+func (s *Server) wrapLuaOutput(L *lua.LState, mcpTable *lua.LTable, vendedID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.outputWrappers == nil {
+		s.outputWrappers = make(map[string][2]*lua.LFunction)
+	}
+	wrappers := s.outputWrappers[vendedID]
+
+	if original, ok := L.GetGlobal("print").(*lua.LFunction); ok && original != wrappers[0] {
+		wrappers[0] = L.NewFunction(func(L *lua.LState) int {
+			message := luaArgsMessage(L, 1, "\t")
+			s.logLua(LogEntry{Level: LogInfo, Session: vendedID, App: displayedAppName(L, mcpTable), Source: logSourcePrint, Message: message})
+			s.captureRunOutput(vendedID, message+"\n")
+			return callOriginal(L, original)
+		})
+		L.SetGlobal("print", wrappers[0])
+	}
+	if ioTable, ok := L.GetGlobal("io").(*lua.LTable); ok {
+		if original, ok := L.GetField(ioTable, "write").(*lua.LFunction); ok && original != wrappers[1] {
+			wrappers[1] = L.NewFunction(func(L *lua.LState) int {
+				s.captureRunOutput(vendedID, luaArgsMessage(L, 1, ""))
+				return callOriginal(L, original)
+			})
+			L.SetField(ioTable, "write", wrappers[1])
+		}
+	}
+	s.outputWrappers[vendedID] = wrappers
+}
+
+// callOriginal calls fn with the current arguments and returns its results
+func callOriginal(L *lua.LState, fn *lua.LFunction) int {
+	top := L.GetTop()
+	L.Push(fn)
+	for i := 1; i <= top; i++ {
+		L.Push(L.Get(i))
+	}
+	L.Call(top, lua.MultRet)
+	return L.GetTop() - top
+}
+
+// luaArgsMessage joins the arguments from index start, converted like print does
+func luaArgsMessage(L *lua.LState, start int, sep string) string {
+	parts := make([]string, 0, L.GetTop())
+	for i := start; i <= L.GetTop(); i++ {
+		parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+	}
+	return strings.Join(parts, sep)
+}
+
+// startRunCapture begins collecting a session's print and io.write output for ui_run.
+// Must run inside the session's executor so only the call's own output is collected.
+func (s *Server) startRunCapture(vendedID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.runOutput == nil {
+		s.runOutput = make(map[string]*strings.Builder)
+	}
+	s.runOutput[vendedID] = &strings.Builder{}
+}
+
+// stopRunCapture ends collection and returns the output
+func (s *Server) stopRunCapture(vendedID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := s.runOutput[vendedID]
+	delete(s.runOutput, vendedID)
+	if out == nil {
+		return ""
+	}
+	return out.String()
+}
+
+// captureRunOutput appends to the session's ui_run output, if a call is in progress
+func (s *Server) captureRunOutput(vendedID, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := s.runOutput[vendedID]
+	if out == nil || out.Len() > maxRunOutput {
+		return
+	}
+	out.WriteString(text)
+	if out.Len() > maxRunOutput {
+		out.WriteString("\n[output truncated]\n")
+	}
+}
@@ -0,0 +1,205 @@
+// Package mcp tests for the structured Lua log
+// Test: test-LuaLog.md
+package mcp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zot/ui-engine/cli"
+)
+
+// R186-R188: Structured Log Tests
+// Test Design: test-LuaLog.md
+
+// TestStructuredLogQuery tests level, app, session, since and limit filtering
+func TestStructuredLogQuery(t *testing.T) {
+	log, err := OpenStructuredLog(filepath.Join(t.TempDir(), "lua.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []LogEntry{
+		{Time: start, Level: LogDebug, Session: "1", App: "todo", Source: logSourceLog, Message: "loading"},
+		{Time: start.Add(time.Minute), Level: LogInfo, Session: "1", App: "todo", Source: logSourcePrint, Message: "hello\tworld"},
+		{Time: start.Add(2 * time.Minute), Level: LogError, Session: "2", App: "prefs", Source: logSourceStderr, Message: "apps/prefs/app.lua:3: boom"},
+		{Time: start.Add(3 * time.Minute), Level: "loud", Session: "1", Source: logSourceLog, Message: "unknown level"},
+	}
+	for _, entry := range entries {
+		if err := log.Write(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query LogQuery
+		want  []string
+	}{
+		{"all", LogQuery{}, []string{"loading", "hello\tworld", "apps/prefs/app.lua:3: boom", "unknown level"}},
+		{"level is a minimum", LogQuery{Level: LogInfo}, []string{"hello\tworld", "apps/prefs/app.lua:3: boom", "unknown level"}},
+		{"app", LogQuery{App: "todo"}, []string{"loading", "hello\tworld"}},
+		{"session", LogQuery{Session: "2"}, []string{"apps/prefs/app.lua:3: boom"}},
+		{"since", LogQuery{Since: start.Add(2 * time.Minute)}, []string{"apps/prefs/app.lua:3: boom", "unknown level"}},
+		{"limit keeps the newest", LogQuery{Limit: 2}, []string{"apps/prefs/app.lua:3: boom", "unknown level"}},
+	}
+	for _, tt := range tests {
+		got, err := log.Query(tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var messages []string
+		for _, entry := range got {
+			messages = append(messages, entry.Message)
+		}
+		if len(messages) != len(tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, messages, tt.want)
+			continue
+		}
+		for i := range messages {
+			if messages[i] != tt.want[i] {
+				t.Errorf("%s: got %q, want %q", tt.name, messages, tt.want)
+				break
+			}
+		}
+	}
+
+	if got, _ := log.Query(LogQuery{Level: LogError}); len(got) != 1 || got[0].App != "prefs" {
+		t.Errorf("unknown level should be recorded as info, got %+v", got)
+	}
+	if _, err := log.Query(LogQuery{Level: "loud"}); err == nil {
+		t.Error("expected an error for an unknown query level")
+	}
+}
+
+// TestParseLogSince tests RFC 3339 and duration since values
+func TestParseLogSince(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if got, err := ParseLogSince("5m", now); err != nil || !got.Equal(now.Add(-5*time.Minute)) {
+		t.Errorf("5m: got %v, %v", got, err)
+	}
+	if got, err := ParseLogSince("2026-01-02T03:00:00Z", now); err != nil || got.Minute() != 0 {
+		t.Errorf("RFC 3339: got %v, %v", got, err)
+	}
+	if got, err := ParseLogSince("", now); err != nil || !got.IsZero() {
+		t.Errorf("empty: got %v, %v", got, err)
+	}
+	if _, err := ParseLogSince("yesterday", now); err == nil {
+		t.Error("expected an error for an invalid since")
+	}
+}
+
+// TestTailErrLog tests that new stderr lines become error entries attributed to their app
+func TestTailErrLog(t *testing.T) {
+	dir := t.TempDir()
+	errPath := filepath.Join(dir, "lua-err.log")
+	log, err := OpenStructuredLog(filepath.Join(dir, "lua.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	stop := TailErrLog(errPath, "1", log)
+	defer stop()
+	if err := os.WriteFile(errPath, []byte("apps/todo/app.lua:12: attempt to index nil\npartial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		entries, _ := log.Query(LogQuery{})
+		if len(entries) > 0 {
+			if len(entries) != 1 {
+				t.Fatalf("expected the complete line only, got %+v", entries)
+			}
+			e := entries[0]
+			if e.Level != LogError || e.App != "todo" || e.Session != "1" || e.Source != logSourceStderr {
+				t.Errorf("unexpected entry %+v", e)
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("stderr line was not recorded")
+}
+
+// TestStructuredLogQueryArchives tests that queries read rotated archives before the current file
+func TestStructuredLogQueryArchives(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lua.jsonl")
+	log, err := OpenStructuredLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	log.Write(LogEntry{Level: LogInfo, Source: logSourcePrint, Message: "before reconfigure"})
+	if err := RotateLog(path, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	log.Write(LogEntry{Level: LogInfo, Source: logSourcePrint, Message: "after reconfigure"})
+
+	entries, err := log.Query(LogQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Message != "before reconfigure" || entries[1].Message != "after reconfigure" {
+		t.Errorf("expected the archived entry then the current one, got %+v", entries)
+	}
+}
+
+// TestRunCaptureOutput tests that print and io.write are captured only while a run is in progress,
+// that print is recorded in the structured log, and that wrapping twice does not double either
+func TestRunCaptureOutput(t *testing.T) {
+	log, err := OpenStructuredLog(filepath.Join(t.TempDir(), "lua.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	s := &Server{cfg: cli.DefaultConfig(), luaLog: log}
+
+	L := lua.NewState()
+	defer L.Close()
+	L.SetGlobal("io", L.NewTable()) // io.write without writing to the test's stdout
+	ioTable := L.GetGlobal("io").(*lua.LTable)
+	L.SetField(ioTable, "write", L.NewFunction(func(L *lua.LState) int { return 0 }))
+	L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int { return 0 }))
+	mcpTable := L.NewTable()
+	s.wrapLuaOutput(L, mcpTable, "1")
+	s.wrapLuaOutput(L, mcpTable, "1")
+
+	if err := L.DoString(`print("before")`); err != nil {
+		t.Fatal(err)
+	}
+	s.startRunCapture("1")
+	if err := L.DoString(`print("a", 1) io.write("b", 2, "\n") print("c")`); err != nil {
+		t.Fatal(err)
+	}
+	out := s.stopRunCapture("1")
+	if out != "a\t1\nb2\nc\n" {
+		t.Errorf("captured %q", out)
+	}
+	if err := L.DoString(`print("after")`); err != nil {
+		t.Fatal(err)
+	}
+	if out := s.stopRunCapture("1"); out != "" {
+		t.Errorf("expected no capture outside a run, got %q", out)
+	}
+
+	entries, _ := log.Query(LogQuery{})
+	var messages []string
+	for _, entry := range entries {
+		messages = append(messages, entry.Message)
+	}
+	if strings.Join(messages, "|") != "before|a\t1|c|after" {
+		t.Errorf("logged %q", messages)
+	}
+}
@@ -0,0 +1,230 @@
+package mcp
+
+// CRC: crc-LuaConvert.md
+// Lua to Go conversion for JSON: ui_run and ui_batch results, mcp.pushState events and REPL values
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Default conversion limits: nesting depth and the number of values converted
+const (
+	defaultConvertDepth = 64
+	defaultConvertItems = 100000
+)
+
+// luaConverter turns Lua values into maps, slices and scalars that marshal as JSON. Shared
+// tables are written in full wherever they appear; a table reached again inside itself, a
+// cycle, becomes {"$ref": POINTER} with the JSON Pointer of the enclosing occurrence.
+type luaConverter struct {
+	maxDepth int
+	maxItems int
+	items    int
+	paths    map[*lua.LTable]string // Tables being converted, on the current path -> JSON Pointer
+}
+
+// newLuaConverter returns a converter that stops at maxDepth nested tables and maxItems values
+func newLuaConverter(maxDepth, maxItems int) *luaConverter {
+	return &luaConverter{maxDepth: maxDepth, maxItems: maxItems, paths: make(map[*lua.LTable]string)}
+}
+
+// luaToGo converts a Lua value with the default limits
+func luaToGo(v lua.LValue) interface{} {
+	return newLuaConverter(defaultConvertDepth, defaultConvertItems).convert(v, "", 0)
+}
+
+// convert converts one value found at path
+func (c *luaConverter) convert(v lua.LValue, path string, depth int) interface{} {
+	switch val := v.(type) {
+	case lua.LString:
+		return string(val)
+	case lua.LNumber:
+		f := float64(val)
+		switch {
+		case math.IsNaN(f):
+			return "NaN"
+		case math.IsInf(f, 1):
+			return "Infinity"
+		case math.IsInf(f, -1):
+			return "-Infinity"
+		}
+		return f
+	case lua.LBool:
+		return bool(val)
+	case *lua.LTable:
+		return c.table(val, path, depth)
+	case *lua.LFunction:
+		return map[string]interface{}{"$function": luaFunctionName(val)}
+	case *lua.LUserData:
+		return map[string]interface{}{"$userdata": fmt.Sprintf("%T", val.Value)}
+	case *lua.LState:
+		return map[string]interface{}{"$thread": "coroutine"}
+	}
+	return nil
+}
+
+// spend counts one value against the size limit, reporting false once it is used up
+func (c *luaConverter) spend() bool {
+	c.items++
+	return c.items <= c.maxItems
+}
+
+// table converts a table to a slice when its keys are positive integers filling at least half
+// of 1..max (gaps become null), and to a map with string keys otherwise. Maps of objects whose
+// prototype has a type name get it as "$type".
+func (c *luaConverter) table(tbl *lua.LTable, path string, depth int) interface{} {
+	if ref, ok := c.paths[tbl]; ok {
+		return map[string]interface{}{"$ref": "#" + ref}
+	}
+	if depth >= c.maxDepth {
+		return map[string]interface{}{"$truncated": "depth"}
+	}
+	c.paths[tbl] = path
+	defer delete(c.paths, tbl)
+
+	var keys, values []lua.LValue
+	tbl.ForEach(func(key, value lua.LValue) {
+		keys = append(keys, key)
+		values = append(values, value)
+	})
+
+	if maxIndex, ok := luaArrayLength(keys); ok {
+		index := make([]int, len(keys))
+		for i, key := range keys {
+			index[i] = int(key.(lua.LNumber))
+		}
+		order := make([]int, len(keys))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(a, b int) bool { return index[order[a]] < index[order[b]] })
+		arr := make([]interface{}, maxIndex)
+		for _, i := range order {
+			if !c.spend() {
+				return append(arr[:index[i]-1], map[string]interface{}{"$truncated": "size"})
+			}
+			arr[index[i]-1] = c.convert(values[i], path+"/"+strconv.Itoa(index[i]-1), depth+1)
+		}
+		return arr
+	}
+
+	names := luaKeyNames(keys)
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return names[order[a]] < names[order[b]] })
+	obj := make(map[string]interface{}, len(keys))
+	for _, i := range order {
+		if !c.spend() {
+			obj["$truncated"] = "size"
+			break
+		}
+		obj[names[i]] = c.convert(values[i], path+"/"+jsonPointerEscape(names[i]), depth+1)
+	}
+	if _, ok := obj["type"]; !ok {
+		if name := luaPrototypeName(tbl); name != "" {
+			obj["$type"] = name
+		}
+	}
+	return obj
+}
+
+// luaArrayLength returns the slice length for keys that are all positive integers no larger
+// than twice their count
+func luaArrayLength(keys []lua.LValue) (int, bool) {
+	if len(keys) == 0 {
+		return 0, false
+	}
+	maxIndex := 0
+	for _, key := range keys {
+		n, ok := key.(lua.LNumber)
+		if !ok || float64(n) != math.Trunc(float64(n)) || n < 1 || float64(n) > float64(2*len(keys)) {
+			return 0, false
+		}
+		if int(n) > maxIndex {
+			maxIndex = int(n)
+		}
+	}
+	return maxIndex, true
+}
+
+// luaKeyNames returns the JSON object keys for a table's keys. String keys keep their names; a
+// non-string key whose name is taken, as 1 is by "1", is written in brackets, as "[1]".
+func luaKeyNames(keys []lua.LValue) []string {
+	names := make([]string, len(keys))
+	used := make(map[string]bool, len(keys))
+	var others []int
+	for i, key := range keys {
+		if s, ok := key.(lua.LString); ok {
+			names[i] = string(s)
+			used[names[i]] = true
+		} else {
+			names[i] = luaKeyName(key)
+			others = append(others, i)
+		}
+	}
+	sort.Slice(others, func(a, b int) bool { return names[others[a]] < names[others[b]] })
+	for _, i := range others {
+		for used[names[i]] {
+			names[i] = "[" + names[i] + "]"
+		}
+		used[names[i]] = true
+	}
+	return names
+}
+
+// luaKeyName is the JSON object key for a Lua table key
+func luaKeyName(key lua.LValue) string {
+	switch k := key.(type) {
+	case lua.LString:
+		return string(k)
+	case lua.LNumber:
+		return strconv.FormatFloat(float64(k), 'g', -1, 64)
+	}
+	return key.String()
+}
+
+// luaPrototypeName returns the type name a table inherits: the `type` field of the first
+// prototype in its __index chain that has one, or its metatable's __name
+func luaPrototypeName(tbl *lua.LTable) string {
+	mt, ok := tbl.Metatable.(*lua.LTable)
+	if !ok {
+		return ""
+	}
+	for depth := 0; depth < 20 && mt != nil; depth++ {
+		proto, ok := mt.RawGetString("__index").(*lua.LTable)
+		if !ok {
+			break
+		}
+		if name, ok := proto.RawGetString("type").(lua.LString); ok {
+			return string(name)
+		}
+		mt, _ = proto.Metatable.(*lua.LTable)
+	}
+	if mt, ok := tbl.Metatable.(*lua.LTable); ok {
+		if name, ok := mt.RawGetString("__name").(lua.LString); ok {
+			return string(name)
+		}
+	}
+	return ""
+}
+
+// luaFunctionName describes a function by where it is defined
+func luaFunctionName(fn *lua.LFunction) string {
+	if fn.IsG || fn.Proto == nil {
+		return "builtin"
+	}
+	return fmt.Sprintf("%s:%d", fn.Proto.SourceName, fn.Proto.LineDefined)
+}
+
+// jsonPointerEscape escapes a key for a JSON Pointer (RFC 6901)
+func jsonPointerEscape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
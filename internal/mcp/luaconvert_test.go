@@ -0,0 +1,140 @@
+// Package mcp tests for Lua to Go conversion
+// Test: test-LuaConvert.md
+package mcp
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// R212-R215: Conversion Tests
+// Test Design: test-LuaConvert.md
+
+// convertJSON evaluates a Lua expression and returns its conversion as JSON
+func convertJSON(t *testing.T, L *lua.LState, expr string, c *luaConverter) string {
+	t.Helper()
+	if err := L.DoString("return " + expr); err != nil {
+		t.Fatal(err)
+	}
+	value := L.Get(-1)
+	L.Pop(1)
+	if c == nil {
+		c = newLuaConverter(defaultConvertDepth, defaultConvertItems)
+	}
+	var out strings.Builder
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(c.convert(value, "", 0)); err != nil {
+		t.Fatalf("%s: %v", expr, err)
+	}
+	return strings.TrimSpace(out.String())
+}
+
+// TestLuaConvertShapes tests arrays, sparse arrays, mixed keys and scalars
+func TestLuaConvertShapes(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	tests := map[string]string{
+		`{1, "two", true}`:                   `[1,"two",true]`,
+		`{}`:                                 `{}`,
+		`{[1] = "a", [3] = "c"}`:             `["a",null,"c"]`,
+		`{[1] = "a", [10] = "j"}`:            `{"1":"a","10":"j"}`,
+		`{"a", "b", name = "x"}`:             `{"1":"a","2":"b","name":"x"}`,
+		`{[1.5] = "half", [true] = "yes"}`:   `{"1.5":"half","true":"yes"}`,
+		`{[1] = "a", ["1"] = "b"}`:           `{"1":"b","[1]":"a"}`,
+		`{[true] = "x", ["true"] = "y"}`:     `{"[true]":"x","true":"y"}`,
+		`{["a/b"] = {}, nested = {{1}, {}}}`: `{"a/b":{},"nested":[[1],{}]}`,
+		`{0/0, 1/0, -1/0}`:                   `["NaN","Infinity","-Infinity"]`,
+		`{print}`:                            `[{"$function":"builtin"}]`,
+		`"text"`:                             `"text"`,
+		`nil`:                                `null`,
+	}
+	for expr, want := range tests {
+		if got := convertJSON(t, L, expr, nil); got != want {
+			t.Errorf("%s: got %s, want %s", expr, got, want)
+		}
+	}
+	if got := convertJSON(t, L, `{function() end}`, nil); !strings.Contains(got, `"$function":"<string>:1"`) {
+		t.Errorf("Lua function: got %s", got)
+	}
+}
+
+// TestLuaConvertRefs tests that cycles become $ref markers and shared tables are written in full
+func TestLuaConvertRefs(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	if err := L.DoString(`
+		node = {name = "root"}
+		node.self = node
+		shared = {1}
+		pair = {first = shared, second = {shared, node}}
+		odd = {["a~b/c"] = {}}
+		odd["a~b/c"].me = odd["a~b/c"]
+	`); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		`node`: `{"name":"root","self":{"$ref":"#"}}`,
+		`pair`: `{"first":[1],"second":[[1],{"name":"root","self":{"$ref":"#/second/1"}}]}`,
+		`odd`:  `{"a~b/c":{"me":{"$ref":"#/a~0b~1c"}}}`,
+	}
+	for expr, want := range tests {
+		if got := convertJSON(t, L, expr, nil); got != want {
+			t.Errorf("%s: got %s, want %s", expr, got, want)
+		}
+	}
+	if got := luaToGo(L.GetGlobal("_G")); got == nil {
+		t.Error("expected the globals to convert")
+	}
+}
+
+// TestLuaConvertPrototypes tests type names from prototypes
+func TestLuaConvertPrototypes(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	if err := L.DoString(`
+		Base = {type = "Base"}
+		Base.__index = Base
+		Todo = setmetatable({}, Base)
+		Todo.__index = Todo
+		Named = setmetatable({}, {__name = "Named"})
+	`); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		`setmetatable({items = {}}, Todo)`:   `{"$type":"Base","items":{}}`,
+		`setmetatable({type = "Own"}, Todo)`: `{"type":"Own"}`,
+		`Named`:                              `{"$type":"Named"}`,
+		`setmetatable({}, {})`:               `{}`,
+	}
+	for expr, want := range tests {
+		if got := convertJSON(t, L, expr, nil); got != want {
+			t.Errorf("%s: got %s, want %s", expr, got, want)
+		}
+	}
+}
+
+// TestLuaConvertLimits tests that depth and size limits cut conversion short
+func TestLuaConvertLimits(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	if got := convertJSON(t, L, `{{{{}}}}`, newLuaConverter(2, 100)); got != `[[{"$truncated":"depth"}]]` {
+		t.Errorf("depth: got %s", got)
+	}
+	if got := convertJSON(t, L, `{1, 2, 3, 4}`, newLuaConverter(10, 2)); got != `[1,2,{"$truncated":"size"}]` {
+		t.Errorf("array size: got %s", got)
+	}
+	if got := convertJSON(t, L, `{a = 1, b = 2, c = 3}`, newLuaConverter(10, 2)); got != `{"$truncated":"size","a":1,"b":2}` {
+		t.Errorf("map size: got %s", got)
+	}
+	// A deep chain converts without overflowing the stack
+	if err := L.DoString(`deep = {} local t = deep for i = 1, 100000 do t.next = {} t = t.next end`); err != nil {
+		t.Fatal(err)
+	}
+	if got := convertJSON(t, L, `deep`, nil); !strings.Contains(got, `"$truncated":"depth"`) {
+		t.Error("expected the deep chain to be truncated")
+	}
+}
@@ -0,0 +1,315 @@
+package mcp
+
+// CRC: crc-Metrics.md
+// Prometheus text metrics served at /metrics on the MCP HTTP server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Histogram buckets in seconds
+var (
+	toolBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+	waitBuckets = []float64{0.01, 0.1, 1, 5, 10, 30, 60, 120}
+	luaBuckets  = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 600}
+)
+
+// metrics holds the counters and histograms events update. Gauges such as queue depth are read
+// from the server when /metrics is scraped. A nil *metrics records nothing, so servers built
+// without NewServer still work.
+type metrics struct {
+	mu          sync.Mutex
+	toolCalls   map[[2]string]uint64  // {tool, outcome} -> calls
+	toolSeconds map[string]*histogram // tool -> handler duration
+	waitSeconds map[string]*histogram // /wait result -> hold time
+	luaSeconds  *histogram            // Time functions ran in session executors
+	panics      uint64                // Panics recovered by SafeExecuteInSession
+	pollErrors  map[[2]string]uint64  // {topic, reason} -> subscriber poll errors
+}
+
+// histogram counts observations in cumulative buckets
+type histogram struct {
+	buckets []float64
+	counts  []uint64 // Per bucket, not cumulative; the last is +Inf
+	sum     float64
+	count   uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		toolCalls:   make(map[[2]string]uint64),
+		toolSeconds: make(map[string]*histogram),
+		waitSeconds: make(map[string]*histogram),
+		luaSeconds:  newHistogram(luaBuckets),
+		pollErrors:  make(map[[2]string]uint64),
+	}
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+func (h *histogram) observe(seconds float64) {
+	h.counts[sort.SearchFloat64s(h.buckets, seconds)]++
+	h.sum += seconds
+	h.count++
+}
+
+// toolMiddleware times tool handlers. MCP calls get it from the mcp-go server and Tool API
+// calls from callMCPHandler, so both count under the tool's name.
+func (m *metrics) toolMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		start := time.Now()
+		result, err := next(ctx, request)
+		outcome := "ok"
+		if err != nil || (result != nil && result.IsError) {
+			outcome = "error"
+		}
+		m.observeTool(request.Params.Name, outcome, time.Since(start))
+		return result, err
+	}
+}
+
+func (m *metrics) observeTool(tool, outcome string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.toolCalls[[2]string{tool, outcome}]++
+	h := m.toolSeconds[tool]
+	if h == nil {
+		h = newHistogram(toolBuckets)
+		m.toolSeconds[tool] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// observeWait records how long a /wait request was held: result is events, empty or disconnected
+func (m *metrics) observeWait(result string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.waitSeconds[result]
+	if h == nil {
+		h = newHistogram(waitBuckets)
+		m.waitSeconds[result] = h
+	}
+	h.observe(d.Seconds())
+}
+
+func (m *metrics) observeLua(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.luaSeconds.observe(d.Seconds())
+}
+
+func (m *metrics) countPanic() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.panics++
+}
+
+// countPollError records a failed subscriber poll: connect, status, read, decode or handler
+func (m *metrics) countPollError(topic, reason string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pollErrors[[2]string{topic, reason}]++
+}
+
+// write writes the recorded metrics
+func (m *metrics) write(w *metricWriter) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.family("frictionless_tool_calls_total", "counter", "Tool calls by tool and outcome (ok or error), over MCP and the Tool API")
+	for _, key := range sortedPairs(m.toolCalls) {
+		w.sample("frictionless_tool_calls_total", labels{"tool", key[0], "outcome", key[1]}, float64(m.toolCalls[key]))
+	}
+	w.family("frictionless_tool_duration_seconds", "histogram", "Tool handler duration")
+	for _, tool := range sortedKeys(m.toolSeconds) {
+		w.histogram("frictionless_tool_duration_seconds", labels{"tool", tool}, m.toolSeconds[tool])
+	}
+	w.family("frictionless_wait_hold_seconds", "histogram", "How long /wait requests were held, by result (events, empty or disconnected)")
+	for _, result := range sortedKeys(m.waitSeconds) {
+		w.histogram("frictionless_wait_hold_seconds", labels{"result", result}, m.waitSeconds[result])
+	}
+	w.family("frictionless_lua_execution_seconds", "histogram", "Time functions ran in session executors")
+	w.histogram("frictionless_lua_execution_seconds", nil, m.luaSeconds)
+	w.family("frictionless_execute_panics_total", "counter", "Panics recovered by SafeExecuteInSession")
+	w.sample("frictionless_execute_panics_total", nil, float64(m.panics))
+	w.family("frictionless_subscribe_poll_errors_total", "counter", "Failed publisher polls by topic and reason (connect, status, read, decode or handler)")
+	for _, key := range sortedPairs(m.pollErrors) {
+		w.sample("frictionless_subscribe_poll_errors_total", labels{"topic", key[0], "reason", key[1]}, float64(m.pollErrors[key]))
+	}
+}
+
+// Spec: mcp.md Section 2.7
+// CRC: crc-Metrics.md
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.writeMetrics(w)
+}
+
+// writeMetrics writes the recorded metrics followed by gauges read from the server's state
+func (s *Server) writeMetrics(out io.Writer) {
+	w := &metricWriter{out: out}
+	s.metrics.write(w)
+
+	s.stateWaitersMu.Lock()
+	queued := make(map[string]int, len(s.stateQueue))
+	for session, events := range s.stateQueue {
+		queued[session] = len(events)
+	}
+	polling := make(map[string]int, len(s.stateWaiters))
+	for session, waiters := range s.stateWaiters {
+		polling[session] = len(waiters)
+	}
+	s.stateWaitersMu.Unlock()
+	w.family("frictionless_state_queue_events", "gauge", "mcp.pushState events waiting for /wait, by session")
+	for _, session := range sortedKeys(queued) {
+		w.sample("frictionless_state_queue_events", labels{"session", session}, float64(queued[session]))
+	}
+	w.family("frictionless_wait_polling_clients", "gauge", "Clients waiting on /wait, by session")
+	for _, session := range sortedKeys(polling) {
+		w.sample("frictionless_wait_polling_clients", labels{"session", session}, float64(polling[session]))
+	}
+
+	status := s.Status()
+	w.family("frictionless_browser_sessions", "gauge", "Browser sessions in the UI engine")
+	w.sample("frictionless_browser_sessions", nil, float64(status.Sessions))
+	w.family("frictionless_uptime_seconds", "gauge", "Seconds since the MCP server was created")
+	w.sample("frictionless_uptime_seconds", nil, status.UptimeSeconds)
+	w.family("frictionless_goroutines", "gauge", "Goroutines in the server process")
+	w.sample("frictionless_goroutines", nil, float64(status.Runtime.Goroutines))
+	w.family("frictionless_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects")
+	w.sample("frictionless_heap_alloc_bytes", nil, float64(status.Runtime.HeapAllocBytes))
+	w.family("frictionless_sys_bytes", "gauge", "Bytes of memory obtained from the OS")
+	w.sample("frictionless_sys_bytes", nil, float64(status.Runtime.SysBytes))
+
+	// Publisher counts exist only in the process hosting it
+	s.mu.RLock()
+	pub := s.publisher
+	s.mu.RUnlock()
+	if pub == nil || !pub.Hosting() {
+		return
+	}
+	stats := pub.Stats()
+	w.family("frictionless_publisher_subscribers", "gauge", "Subscribers waiting on each publisher topic")
+	for _, topic := range sortedKeys(stats) {
+		w.sample("frictionless_publisher_subscribers", labels{"topic", topic}, float64(stats[topic].Subscribers))
+	}
+	w.family("frictionless_publisher_publishes_total", "counter", "Messages published to each topic")
+	for _, topic := range sortedKeys(stats) {
+		w.sample("frictionless_publisher_publishes_total", labels{"topic", topic}, float64(stats[topic].Published))
+	}
+	w.family("frictionless_publisher_deliveries_total", "counter", "Messages delivered to subscribers of each topic")
+	for _, topic := range sortedKeys(stats) {
+		w.sample("frictionless_publisher_deliveries_total", labels{"topic", topic}, float64(stats[topic].Delivered))
+	}
+}
+
+// Text exposition format
+
+// labels alternates label names and values
+type labels []string
+
+// metricWriter writes the Prometheus text exposition format
+type metricWriter struct {
+	out io.Writer
+}
+
+func (w *metricWriter) family(name, kind, help string) {
+	fmt.Fprintf(w.out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (w *metricWriter) sample(name string, l labels, value float64) {
+	fmt.Fprintf(w.out, "%s%s %s\n", name, l.format(), formatMetricValue(value))
+}
+
+func (w *metricWriter) histogram(name string, l labels, h *histogram) {
+	cumulative := uint64(0)
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		w.sample(name+"_bucket", append(l[:len(l):len(l)], "le", formatMetricValue(bound)), float64(cumulative))
+	}
+	w.sample(name+"_bucket", append(l[:len(l):len(l)], "le", "+Inf"), float64(h.count))
+	w.sample(name+"_sum", l, h.sum)
+	w.sample(name+"_count", l, float64(h.count))
+}
+
+// format renders {name="value",...}, or nothing without labels
+func (l labels) format() string {
+	if len(l) == 0 {
+		return ""
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, 0, len(l)/2)
+	for i := 0; i+1 < len(l); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, l[i], escape.Replace(l[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedPairs(m map[[2]string]uint64) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a][0] != keys[b][0] {
+			return keys[a][0] < keys[b][0]
+		}
+		return keys[a][1] < keys[b][1]
+	})
+	return keys
+}
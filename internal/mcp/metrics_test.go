@@ -0,0 +1,117 @@
+// Package mcp tests for /metrics
+// Test: test-Metrics.md
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/zot/ui-engine/cli"
+)
+
+// R220-R223: Metrics Tests
+// Test Design: test-Metrics.md
+
+// scrapeMetrics returns the /metrics response body
+func scrapeMetrics(t *testing.T, s *Server) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	return rec.Body.String()
+}
+
+// expectLines fails for each line missing from body
+func expectLines(t *testing.T, body string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(body, "\n"+line+"\n") && !strings.HasPrefix(body, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, body)
+		}
+	}
+}
+
+// TestMetricsTools tests tool call counts and durations through callMCPHandler
+func TestMetricsTools(t *testing.T) {
+	s := NewServer(cli.DefaultConfig(), nil, nil, nil, nil)
+	ok := func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText(`{"ok": true}`), nil
+	}
+	failed := func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultError("bad"), nil
+	}
+	broken := func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return nil, fmt.Errorf("broken")
+	}
+	s.callMCPHandler(context.Background(), "ui_run", ok, nil)
+	s.callMCPHandler(context.Background(), "ui_run", ok, nil)
+	s.callMCPHandler(context.Background(), "ui_run", failed, nil)
+	s.callMCPHandler(context.Background(), "ui_display", broken, nil)
+
+	body := scrapeMetrics(t, s)
+	expectLines(t, body,
+		"# TYPE frictionless_tool_calls_total counter",
+		`frictionless_tool_calls_total{tool="ui_display",outcome="error"} 1`,
+		`frictionless_tool_calls_total{tool="ui_run",outcome="error"} 1`,
+		`frictionless_tool_calls_total{tool="ui_run",outcome="ok"} 2`,
+		"# TYPE frictionless_tool_duration_seconds histogram",
+		`frictionless_tool_duration_seconds_bucket{tool="ui_run",le="+Inf"} 3`,
+		`frictionless_tool_duration_seconds_count{tool="ui_run"} 3`,
+		"frictionless_execute_panics_total 0",
+	)
+}
+
+// TestMetricsHistogram tests cumulative buckets and the sum
+func TestMetricsHistogram(t *testing.T) {
+	m := newMetrics()
+	for _, d := range []time.Duration{500 * time.Millisecond, 2 * time.Second, 200 * time.Second} {
+		m.observeWait("events", d)
+	}
+	m.observeLua(time.Millisecond)
+	m.countPollError(`a"b`, "connect")
+	var out strings.Builder
+	m.write(&metricWriter{out: &out})
+	expectLines(t, out.String(),
+		`frictionless_wait_hold_seconds_bucket{result="events",le="0.1"} 0`,
+		`frictionless_wait_hold_seconds_bucket{result="events",le="1"} 1`,
+		`frictionless_wait_hold_seconds_bucket{result="events",le="5"} 2`,
+		`frictionless_wait_hold_seconds_bucket{result="events",le="120"} 2`,
+		`frictionless_wait_hold_seconds_bucket{result="events",le="+Inf"} 3`,
+		`frictionless_wait_hold_seconds_sum{result="events"} 202.5`,
+		`frictionless_lua_execution_seconds_bucket{le="0.001"} 1`,
+		`frictionless_lua_execution_seconds_count 1`,
+		`frictionless_subscribe_poll_errors_total{topic="a\"b",reason="connect"} 1`,
+	)
+	// A nil registry records nothing
+	var none *metrics
+	none.observeTool("ui_run", "ok", time.Second)
+	none.countPanic()
+}
+
+// TestMetricsGauges tests queue, polling and runtime gauges read at scrape time
+func TestMetricsGauges(t *testing.T) {
+	s := NewServer(cli.DefaultConfig(), nil, nil, nil, nil)
+	s.stateQueue["1"] = []interface{}{"a", "b", "c"}
+	s.stateQueue["2"] = nil
+	s.stateWaiters["1"] = []chan struct{}{make(chan struct{})}
+
+	body := scrapeMetrics(t, s)
+	expectLines(t, body,
+		`frictionless_state_queue_events{session="1"} 3`,
+		`frictionless_state_queue_events{session="2"} 0`,
+		`frictionless_wait_polling_clients{session="1"} 1`,
+		"frictionless_browser_sessions 0",
+		"# TYPE frictionless_goroutines gauge",
+	)
+	if strings.Contains(body, "frictionless_publisher_") {
+		t.Error("expected no publisher metrics without a hosted publisher")
+	}
+}
@@ -0,0 +1,604 @@
+package mcp
+
+// CRC: crc-RunProfile.md | Seq: seq-mcp-run.md
+// Execution profiles: ui_run code in a sandboxed environment without file or process access,
+// optionally seeing session state only through read-only views
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// RunProfile names the environment ui_run code executes in
+type RunProfile string
+
+const (
+	ProfileFull     RunProfile = "full"     // The session's globals, unrestricted
+	ProfileNoIO     RunProfile = "no-io"    // No io, os (beyond time), file loading or debug access in the code itself
+	ProfileReadonly RunProfile = "readonly" // no-io, and session state can be read but not changed
+)
+
+// profileStrictness orders profiles; a token's profile is the loosest it may run
+var profileStrictness = map[RunProfile]int{ProfileFull: 0, ProfileNoIO: 1, ProfileReadonly: 2}
+
+// ParseRunProfile reads a profile name; empty is full
+func ParseRunProfile(name string) (RunProfile, error) {
+	if name == "" {
+		return ProfileFull, nil
+	}
+	profile := RunProfile(name)
+	if _, ok := profileStrictness[profile]; !ok {
+		return "", fmt.Errorf("unknown profile %q (use full, no-io or readonly)", name)
+	}
+	return profile, nil
+}
+
+// Allows reports whether a client limited to p may run code under requested
+func (p RunProfile) Allows(requested RunProfile) bool {
+	return profileStrictness[requested] >= profileStrictness[p]
+}
+
+// GetAPITokens reads storage/settings.json "apiTokens": token -> profile. Entries with unknown
+// profiles are dropped so a typo cannot grant full access. no-io entries are dropped too: functions
+// the code calls still run in the real environment, so no-io is not a security boundary.
+func GetAPITokens(baseDir string) map[string]RunProfile {
+	tokens := make(map[string]RunProfile)
+	settings, err := readSettings(baseDir)
+	if err != nil {
+		return tokens
+	}
+	values, _ := settings["apiTokens"].(map[string]interface{})
+	for token, value := range values {
+		name, _ := value.(string)
+		if profile, err := ParseRunProfile(name); err == nil && name != "" && profile != ProfileNoIO && token != "" {
+			tokens[token] = profile
+		}
+	}
+	return tokens
+}
+
+// requestProfile returns the profile limit of an HTTP Tool API request. Until apiTokens has
+// entries, requests without a token are full; after that every request needs a bearer token
+// from apiTokens, since any local process can reach the server.
+func (s *Server) requestProfile(r *http.Request) (RunProfile, error) {
+	s.mu.RLock()
+	baseDir := s.baseDir
+	s.mu.RUnlock()
+	tokens := GetAPITokens(baseDir)
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		if len(tokens) > 0 {
+			return "", fmt.Errorf("API token required")
+		}
+		return ProfileFull, nil
+	}
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok {
+		return "", fmt.Errorf("Authorization must be a Bearer token")
+	}
+	profile, ok := tokens[strings.TrimSpace(token)]
+	if !ok {
+		return "", fmt.Errorf("unknown API token")
+	}
+	return profile, nil
+}
+
+// tokenProfile returns the profile an API call runs under: requested, or the token's profile
+// when requested is empty. Along with the error, it returns the status to answer with: 401 for
+// a missing or unknown token, 400 for an unknown profile and 403 for one looser than the token's.
+func (s *Server) tokenProfile(r *http.Request, requested string) (RunProfile, int, error) {
+	limit, err := s.requestProfile(r)
+	if err != nil {
+		return "", http.StatusUnauthorized, err
+	}
+	if requested == "" {
+		return limit, 0, nil
+	}
+	profile, err := ParseRunProfile(requested)
+	if err != nil {
+		return "", http.StatusBadRequest, err
+	}
+	if !limit.Allows(profile) {
+		return "", http.StatusForbidden, fmt.Errorf("token is limited to the %s profile", limit)
+	}
+	return profile, 0, nil
+}
+
+// requireFullProfile refuses requests whose token is limited to a sandboxed profile, for
+// endpoints that change the server, the session or files
+func (s *Server) requireFullProfile(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		profile, err := s.requestProfile(r)
+		if err != nil {
+			apiError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if profile != ProfileFull {
+			apiError(w, http.StatusForbidden, fmt.Sprintf("token is limited to the %s profile", profile))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// profileRunGlobal holds the entry point while ui_run code executes
+const profileRunGlobal = "__mcpProfileRun"
+
+// profileCode wraps ui_run code in a function the entry point runs, in the sandbox's
+// environment for sandboxed profiles. The code stays on the first line so error line numbers
+// match the caller's.
+func profileCode(code string) string {
+	return "return " + profileRunGlobal + "(function(...) " + code + "\nend)"
+}
+
+// captureRun returns an entry point that runs the wrapped code, in sb when it is not nil, and
+// keeps the first result in result as a Lua value, so results are converted by luaToGo rather
+// than by LoadCodeDirect
+func captureRun(sb *sandbox, result *lua.LValue) lua.LGFunction {
+	return func(L *lua.LState) int {
+		var n int
+		if sb != nil {
+			n = sb.run(L)
+		} else {
+			base := L.GetTop()
+			L.Push(L.CheckFunction(1))
+			L.Call(0, lua.MultRet)
+			n = L.GetTop() - base
+		}
+		*result = lua.LNil
+		if n > 0 {
+			*result = L.Get(L.GetTop() - n + 1)
+		}
+		return 0
+	}
+}
+
+// readonlyCalls are the session functions readonly code may call; they read state without
+// changing it. Any other function reached through session state raises an error.
+var readonlyCalls = []string{"mcp.status", "mcp.pollingEvents", "mcp.waitTime", "mcp.log", "mcp.renderMarkdown", "session.getApp"}
+
+// noIOHidden are globals sandboxed code cannot reach: files, processes, module loading and
+// the debug library, plus getfenv/setfenv, which would reveal the real globals
+var noIOHidden = map[string]bool{
+	"io": true, "debug": true, "package": true, "dofile": true, "loadfile": true,
+	"getfenv": true, "setfenv": true, "module": true,
+}
+
+// sandbox is one sandboxed ui_run: its environment and, for readonly, the proxies it handed out
+type sandbox struct {
+	profile   RunProfile
+	globals   *lua.LTable
+	env       *lua.LTable
+	overrides *lua.LTable
+	proxies   map[*lua.LTable]*lua.LTable // real -> proxy
+	reals     map[*lua.LTable]*lua.LTable // proxy -> real
+	allowed   map[*lua.LFunction]string   // readonly functions -> name
+}
+
+// newSandbox builds the environment for a no-io or readonly run
+func newSandbox(L *lua.LState, profile RunProfile) *sandbox {
+	sb := &sandbox{
+		profile:   profile,
+		globals:   L.G.Global,
+		env:       L.NewTable(),
+		overrides: L.NewTable(),
+		proxies:   make(map[*lua.LTable]*lua.LTable),
+		reals:     make(map[*lua.LTable]*lua.LTable),
+		allowed:   make(map[*lua.LFunction]string),
+	}
+	sb.overrides.RawSetString("_G", sb.env)
+	sb.overrides.RawSetString("os", sb.osTable(L))
+	sb.overrides.RawSetString("require", L.NewFunction(sb.require))
+	for _, name := range []string{"load", "loadstring"} {
+		if fn, ok := sb.globals.RawGetString(name).(*lua.LFunction); ok {
+			sb.overrides.RawSetString(name, L.NewFunction(sb.loader(fn)))
+		}
+	}
+	if profile == ProfileReadonly {
+		sb.readonlyOverrides(L)
+	}
+
+	mt := L.NewTable()
+	mt.RawSetString("__metatable", lua.LString(string(profile)))
+	mt.RawSetString("__index", L.NewFunction(func(L *lua.LState) int {
+		key := L.Get(2)
+		if name, ok := key.(lua.LString); ok && noIOHidden[string(name)] {
+			L.RaiseError("%s profile: %s is not available", sb.profile, name)
+		}
+		if value := sb.overrides.RawGet(key); value != lua.LNil {
+			L.Push(value)
+			return 1
+		}
+		value := sb.globals.RawGet(key)
+		if sb.profile == ProfileReadonly {
+			value = sb.wrapField(L, key, value)
+		}
+		L.Push(value)
+		return 1
+	}))
+	if profile != ProfileReadonly {
+		// no-io code may change state; new globals land in the session's globals.
+		// Readonly globals stay in the sandbox and are discarded after the run.
+		mt.RawSetString("__newindex", L.NewFunction(func(L *lua.LState) int {
+			sb.globals.RawSet(L.Get(2), L.Get(3))
+			return 0
+		}))
+	}
+	L.SetMetatable(sb.env, mt)
+	return sb
+}
+
+// osTable keeps the clock functions of os
+func (sb *sandbox) osTable(L *lua.LState) *lua.LTable {
+	restricted := L.NewTable()
+	if os, ok := sb.globals.RawGetString("os").(*lua.LTable); ok {
+		for _, name := range []string{"time", "clock", "date", "difftime"} {
+			restricted.RawSetString(name, os.RawGetString(name))
+		}
+	}
+	return restricted
+}
+
+// sandboxLibraries are the standard libraries sandboxed code may require; the others are
+// hidden or, like _G and package, lead back to the real globals
+var sandboxLibraries = map[string]bool{"string": true, "math": true, "table": true, "coroutine": true}
+
+// luaLibraries are the names the standard libraries are loaded under
+var luaLibraries = map[string]bool{
+	"_G": true, "package": true, "io": true, "os": true, "debug": true, "channel": true,
+	"string": true, "math": true, "table": true, "coroutine": true,
+}
+
+// require returns the allowed standard libraries, as the sandbox sees them, and app modules
+// that are already loaded. Nothing is read from disk.
+func (sb *sandbox) require(L *lua.LState) int {
+	name := L.CheckString(1)
+	if sandboxLibraries[name] {
+		module := sb.overrides.RawGetString(name)
+		if module == lua.LNil {
+			module = sb.globals.RawGetString(name)
+		}
+		L.Push(module)
+		return 1
+	}
+	if luaLibraries[name] || noIOHidden[name] {
+		L.RaiseError("%s profile: require %q is not available", sb.profile, name)
+	}
+	var module lua.LValue = lua.LNil
+	if pkg, ok := sb.globals.RawGetString("package").(*lua.LTable); ok {
+		if loaded, ok := pkg.RawGetString("loaded").(*lua.LTable); ok {
+			module = loaded.RawGetString(name)
+		}
+	}
+	if module == lua.LNil {
+		L.RaiseError("%s profile: module %q is not loaded and cannot be read from disk", sb.profile, name)
+	}
+	if sb.isLibrary(module) {
+		L.RaiseError("%s profile: require %q is not available", sb.profile, name)
+	}
+	if sb.profile == ProfileReadonly {
+		module = sb.wrap(L, module)
+	}
+	L.Push(module)
+	return 1
+}
+
+// isLibrary reports whether value is the globals table or a standard library table, so an app
+// module registered under another name cannot hand them out
+func (sb *sandbox) isLibrary(value lua.LValue) bool {
+	if value == sb.globals || value == sb.env {
+		return true
+	}
+	for name := range luaLibraries {
+		if tbl, ok := sb.globals.RawGetString(name).(*lua.LTable); ok && value == tbl {
+			return true
+		}
+	}
+	return false
+}
+
+// loader wraps load/loadstring so compiled chunks run in the sandbox instead of the real globals
+func (sb *sandbox) loader(load *lua.LFunction) lua.LGFunction {
+	return func(L *lua.LState) int {
+		n := sb.callThrough(L, load)
+		if n > 0 {
+			if fn, ok := L.Get(-n).(*lua.LFunction); ok {
+				fn.Env = sb.env
+			}
+		}
+		return n
+	}
+}
+
+// callThrough calls fn with the current arguments and returns the number of results
+func (sb *sandbox) callThrough(L *lua.LState, fn lua.LValue) int {
+	nargs := L.GetTop()
+	args := make([]lua.LValue, nargs)
+	for i := range args {
+		args[i] = L.Get(i + 1)
+	}
+	L.CallByParam(lua.P{Fn: fn, NRet: lua.MultRet, Protect: false}, args...)
+	return L.GetTop() - nargs
+}
+
+// run is profileRunGlobal: it executes the wrapped code in the sandbox and, for readonly,
+// replaces proxies in the results with the values they view so results marshal normally
+func (sb *sandbox) run(L *lua.LState) int {
+	fn := L.CheckFunction(1)
+	fn.Env = sb.env
+	base := L.GetTop()
+	L.Push(fn)
+	L.Call(0, lua.MultRet)
+	n := L.GetTop() - base
+	if sb.profile == ProfileReadonly {
+		seen := make(map[*lua.LTable]bool)
+		for i := base + 1; i <= base+n; i++ {
+			L.Replace(i, sb.unwrapDeep(L.Get(i), seen))
+		}
+	}
+	return n
+}
+
+// Readonly views
+
+// readonlyOverrides gives readonly code the base library directly, copies of the string, math
+// and coroutine libraries so changing them stays in the sandbox, and proxy-aware versions of the
+// functions that read tables raw
+func (sb *sandbox) readonlyOverrides(L *lua.LState) {
+	for _, name := range []string{
+		"print", "tostring", "tonumber", "type", "select", "error", "assert", "pcall", "xpcall",
+		"rawequal", "rawset", "setmetatable", "collectgarbage", "_VERSION",
+	} {
+		sb.overrides.RawSetString(name, sb.globals.RawGetString(name))
+	}
+	for _, name := range []string{"string", "math", "coroutine"} {
+		if lib, ok := sb.globals.RawGetString(name).(*lua.LTable); ok {
+			copied := L.NewTable()
+			lib.ForEach(func(key, value lua.LValue) { copied.RawSet(key, value) })
+			sb.overrides.RawSetString(name, copied)
+		}
+	}
+	// Only tables show their metatables; the string metatable's __index is the real string library
+	getmetatable := sb.globals.RawGetString("getmetatable")
+	sb.overrides.RawSetString("getmetatable", L.NewFunction(func(L *lua.LState) int {
+		if _, ok := L.CheckAny(1).(*lua.LTable); !ok {
+			L.Push(lua.LNil)
+			return 1
+		}
+		return sb.callThrough(L, getmetatable)
+	}))
+	sb.overrides.RawSetString("next", L.NewFunction(sb.next))
+	sb.overrides.RawSetString("pairs", L.NewFunction(func(L *lua.LState) int {
+		L.Push(L.NewFunction(sb.next))
+		L.Push(L.CheckTable(1))
+		L.Push(lua.LNil)
+		return 3
+	}))
+	sb.overrides.RawSetString("ipairs", L.NewFunction(func(L *lua.LState) int {
+		L.Push(L.NewFunction(func(L *lua.LState) int {
+			i := L.CheckInt(2) + 1
+			value := sb.real(L.CheckTable(1)).RawGetInt(i)
+			if value == lua.LNil {
+				return 0
+			}
+			L.Push(lua.LNumber(i))
+			L.Push(sb.wrapIn(L, L.CheckTable(1), value))
+			return 2
+		}))
+		L.Push(L.CheckTable(1))
+		L.Push(lua.LNumber(0))
+		return 3
+	}))
+	sb.overrides.RawSetString("rawget", L.NewFunction(func(L *lua.LState) int {
+		tbl := L.CheckTable(1)
+		L.Push(sb.wrapIn(L, tbl, sb.real(tbl).RawGet(sb.unwrap(L.CheckAny(2)))))
+		return 1
+	}))
+	unpack := L.NewFunction(sb.unpack)
+	sb.overrides.RawSetString("unpack", unpack)
+
+	table := L.NewTable()
+	if lib, ok := sb.globals.RawGetString("table").(*lua.LTable); ok {
+		lib.ForEach(func(key, value lua.LValue) { table.RawSet(key, value) })
+		for _, name := range []string{"insert", "remove", "sort"} {
+			original := lib.RawGetString(name)
+			table.RawSetString(name, L.NewFunction(func(L *lua.LState) int {
+				if _, ok := sb.reals[L.CheckTable(1)]; ok {
+					L.RaiseError("readonly profile: cannot modify session state with table.%s", name)
+				}
+				return sb.callThrough(L, original)
+			}))
+		}
+		for _, name := range []string{"concat", "getn", "maxn"} {
+			original := lib.RawGetString(name)
+			table.RawSetString(name, L.NewFunction(func(L *lua.LState) int {
+				L.Replace(1, sb.real(L.CheckTable(1)))
+				return sb.callThrough(L, original)
+			}))
+		}
+		table.RawSetString("unpack", unpack)
+	}
+	sb.overrides.RawSetString("table", table)
+
+	for _, path := range readonlyCalls {
+		owner, name, _ := strings.Cut(path, ".")
+		if tbl, ok := sb.globals.RawGetString(owner).(*lua.LTable); ok {
+			if fn, ok := sb.lookup(tbl, lua.LString(name)).(*lua.LFunction); ok {
+				sb.allowed[fn] = path
+			}
+		}
+	}
+}
+
+// next iterates a proxy's real table, handing out views of its keys and values
+func (sb *sandbox) next(L *lua.LState) int {
+	tbl := L.CheckTable(1)
+	key, value := sb.real(tbl).Next(sb.unwrap(L.Get(2)))
+	if key == lua.LNil {
+		L.Push(lua.LNil)
+		return 1
+	}
+	L.Push(sb.wrapIn(L, tbl, key))
+	L.Push(sb.wrapIn(L, tbl, value))
+	return 2
+}
+
+// unpack returns a table's elements, as views when it is a proxy
+func (sb *sandbox) unpack(L *lua.LState) int {
+	tbl := L.CheckTable(1)
+	real := sb.real(tbl)
+	first, last := L.OptInt(2, 1), L.OptInt(3, real.Len())
+	for i := first; i <= last; i++ {
+		L.Push(sb.wrapIn(L, tbl, real.RawGetInt(i)))
+	}
+	if last < first {
+		return 0
+	}
+	return last - first + 1
+}
+
+// real returns the table a proxy views, or tbl itself
+func (sb *sandbox) real(tbl *lua.LTable) *lua.LTable {
+	if real, ok := sb.reals[tbl]; ok {
+		return real
+	}
+	return tbl
+}
+
+// unwrap returns the value a proxy views, or value itself
+func (sb *sandbox) unwrap(value lua.LValue) lua.LValue {
+	if tbl, ok := value.(*lua.LTable); ok {
+		return sb.real(tbl)
+	}
+	return value
+}
+
+// wrapIn wraps value when it came out of a proxy; the code's own tables hold what it put there
+func (sb *sandbox) wrapIn(L *lua.LState, from *lua.LTable, value lua.LValue) lua.LValue {
+	if _, ok := sb.reals[from]; ok {
+		return sb.wrap(L, value)
+	}
+	return value
+}
+
+// lookup reads key from tbl, following __index tables as prototypes do. __index functions are
+// not called, since they could run code that changes state.
+func (sb *sandbox) lookup(tbl *lua.LTable, key lua.LValue) lua.LValue {
+	for depth := 0; depth < 100; depth++ {
+		if value := tbl.RawGet(key); value != lua.LNil {
+			return value
+		}
+		mt, ok := tbl.Metatable.(*lua.LTable)
+		if !ok {
+			return lua.LNil
+		}
+		next, ok := mt.RawGetString("__index").(*lua.LTable)
+		if !ok {
+			return lua.LNil
+		}
+		tbl = next
+	}
+	return lua.LNil
+}
+
+// wrapField wraps a value read from key, naming the key in errors for blocked functions
+func (sb *sandbox) wrapField(L *lua.LState, key, value lua.LValue) lua.LValue {
+	if fn, ok := value.(*lua.LFunction); ok {
+		return sb.wrapFunction(L, key.String(), fn)
+	}
+	return sb.wrap(L, value)
+}
+
+// wrap returns a read-only view of a session value: tables become proxies that reject
+// assignment, and functions can only be called if they are in readonlyCalls
+func (sb *sandbox) wrap(L *lua.LState, value lua.LValue) lua.LValue {
+	switch v := value.(type) {
+	case *lua.LTable:
+		if _, ok := sb.reals[v]; ok {
+			return v
+		}
+		if proxy, ok := sb.proxies[v]; ok {
+			return proxy
+		}
+		return sb.newProxy(L, v)
+	case *lua.LFunction:
+		return sb.wrapFunction(L, "function", v)
+	}
+	return value
+}
+
+// newProxy makes the read-only view of a table
+func (sb *sandbox) newProxy(L *lua.LState, real *lua.LTable) *lua.LTable {
+	proxy := L.NewTable()
+	sb.proxies[real] = proxy
+	sb.reals[proxy] = real
+	mt := L.NewTable()
+	mt.RawSetString("__metatable", lua.LString("readonly"))
+	mt.RawSetString("__index", L.NewFunction(func(L *lua.LState) int {
+		key := L.Get(2)
+		L.Push(sb.wrapField(L, key, sb.lookup(real, sb.unwrap(key))))
+		return 1
+	}))
+	mt.RawSetString("__newindex", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("readonly profile: cannot assign %s", L.Get(2).String())
+		return 0
+	}))
+	mt.RawSetString("__len", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LNumber(real.Len()))
+		return 1
+	}))
+	L.SetMetatable(proxy, mt)
+	return proxy
+}
+
+// wrapFunction lets readonlyCalls run on the real values, wrapping what they return, and
+// replaces every other function with one that raises an error
+func (sb *sandbox) wrapFunction(L *lua.LState, name string, fn *lua.LFunction) lua.LValue {
+	if _, ok := sb.allowed[fn]; !ok {
+		return L.NewFunction(func(L *lua.LState) int {
+			L.RaiseError("readonly profile: cannot call %s; only %s are allowed", name, strings.Join(readonlyCalls, ", "))
+			return 0
+		})
+	}
+	return L.NewFunction(func(L *lua.LState) int {
+		for i := 1; i <= L.GetTop(); i++ {
+			L.Replace(i, sb.unwrap(L.Get(i)))
+		}
+		n := sb.callThrough(L, fn)
+		for i := L.GetTop() - n + 1; i <= L.GetTop(); i++ {
+			L.Replace(i, sb.wrap(L, L.Get(i)))
+		}
+		return n
+	})
+}
+
+// unwrapDeep replaces proxies in a result, including inside tables the code built, with the
+// values they view. Those tables are the code's own, so they are changed in place.
+func (sb *sandbox) unwrapDeep(value lua.LValue, seen map[*lua.LTable]bool) lua.LValue {
+	tbl, ok := value.(*lua.LTable)
+	if !ok {
+		return value
+	}
+	if real, ok := sb.reals[tbl]; ok {
+		return real
+	}
+	if seen[tbl] {
+		return tbl
+	}
+	seen[tbl] = true
+	type entry struct{ key, value lua.LValue }
+	var changed []entry
+	tbl.ForEach(func(key, value lua.LValue) {
+		k, v := sb.unwrapDeep(key, seen), sb.unwrapDeep(value, seen)
+		if k != key || v != value {
+			changed = append(changed, entry{key, lua.LNil}, entry{k, v})
+		}
+	})
+	for _, e := range changed {
+		tbl.RawSet(e.key, e.value)
+	}
+	return tbl
+}
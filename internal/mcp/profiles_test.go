@@ -0,0 +1,237 @@
+// Package mcp tests for ui_run execution profiles
+// Test: test-RunProfile.md
+package mcp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zot/ui-engine/cli"
+)
+
+// R199-R203: Execution Profile Tests
+// Test Design: test-RunProfile.md
+
+// newProfileState returns a Lua state with an mcp table holding an app, as a session would
+func newProfileState(t *testing.T) *lua.LState {
+	t.Helper()
+	L := lua.NewState()
+	t.Cleanup(L.Close)
+	err := L.DoString(`
+		Todo = {}
+		Todo.__index = Todo
+		function Todo:count() return #self.items end
+		function Todo:add(name) table.insert(self.items, {name = name}) end
+		mcp = {type = "MCP", value = setmetatable({items = {{name = "milk"}, {name = "eggs"}}}, Todo)}
+		package.loaded.app = {name = "todo"}
+		package.loaded.escape = _G
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcpTable := L.GetGlobal("mcp").(*lua.LTable)
+	L.SetField(mcpTable, "status", L.NewFunction(func(L *lua.LState) int {
+		status := L.NewTable()
+		status.RawSetString("base_dir", lua.LString(".ui"))
+		L.Push(status)
+		return 1
+	}))
+	L.SetField(mcpTable, "display", L.NewFunction(func(L *lua.LState) int {
+		L.SetField(mcpTable, "value", lua.LNil)
+		return 0
+	}))
+	return L
+}
+
+// runProfile runs code the way handleRun does for a sandboxed profile and returns the first result
+func runProfile(L *lua.LState, profile RunProfile, code string) (lua.LValue, error) {
+	sb := newSandbox(L, profile)
+	L.SetGlobal(profileRunGlobal, L.NewFunction(sb.run))
+	defer L.SetGlobal(profileRunGlobal, lua.LNil)
+	top := L.GetTop()
+	if err := L.DoString(profileCode(code)); err != nil {
+		return lua.LNil, err
+	}
+	defer L.SetTop(top)
+	if L.GetTop() == top {
+		return lua.LNil, nil
+	}
+	return L.Get(top + 1), nil
+}
+
+// TestProfileNoIO tests that no-io hides files and processes but keeps state changes
+func TestProfileNoIO(t *testing.T) {
+	L := newProfileState(t)
+	for _, code := range []string{
+		`return io.open("x")`,
+		`return os.execute("true")`,
+		`return dofile("x.lua")`,
+		`return getfenv(0).io`,
+		`return require("io")`,
+		`return require("notloaded")`,
+		`return loadstring("return io")()`,
+		`return require("_G").io.popen`,
+		`return require("package").loaded._G`,
+		`return require("debug")`,
+		`return require("escape").os.execute`,
+	} {
+		if _, err := runProfile(L, ProfileNoIO, code); err == nil {
+			t.Errorf("%s: expected an error", code)
+		}
+	}
+	// The environment's metatable is protected
+	if v, err := runProfile(L, ProfileNoIO, `return getmetatable(_G)`); err != nil || v.String() != "no-io" {
+		t.Errorf("expected a protected metatable, got %v, %v", v, err)
+	}
+	if v, err := runProfile(L, ProfileNoIO, `return os.time() > 0 and string.upper("ok")`); err != nil || v.String() != "OK" {
+		t.Errorf("expected os.time and string, got %v, %v", v, err)
+	}
+	if v, err := runProfile(L, ProfileNoIO, `return require("string").upper(require("app").name)`); err != nil || v.String() != "TODO" {
+		t.Errorf("expected allowed libraries and app modules, got %v, %v", v, err)
+	}
+	if _, err := runProfile(L, ProfileNoIO, `mcp.value:add("bread") counter = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if L.GetGlobal("counter") != lua.LNumber(1) {
+		t.Error("expected no-io globals to persist")
+	}
+	if v, _ := runProfile(L, ProfileNoIO, `return mcp.value:count()`); v != lua.LNumber(3) {
+		t.Errorf("expected no-io to change state, got %v", v)
+	}
+}
+
+// TestProfileReadonly tests that readonly code can read state but not change it
+func TestProfileReadonly(t *testing.T) {
+	L := newProfileState(t)
+	reads := map[string]string{
+		`return mcp.value.items[2].name`: "eggs",
+		`return #mcp.value.items`:        "2",
+		`local n = 0 for _, item in ipairs(mcp.value.items) do n = n + 1 end return n`: "2",
+		`local s = "" for k in pairs(mcp.value) do s = s .. k end return s`:            "items",
+		`return table.concat({mcp.type, "x"}, ",")`:                                    "MCP,x",
+		`return mcp:status().base_dir`:                                                 ".ui",
+		`local x = 5 y = x return y`:                                                   "5",
+	}
+	for code, want := range reads {
+		v, err := runProfile(L, ProfileReadonly, code)
+		if err != nil || v.String() != want {
+			t.Errorf("%s: got %v, %v; want %s", code, v, err, want)
+		}
+	}
+	for _, code := range []string{
+		`mcp.value.items[1].name = "cheese"`,
+		`mcp.value = nil`,
+		`table.insert(mcp.value.items, {})`,
+		`mcp.value:add("bread")`,
+		`mcp:display("todo")`,
+		`return io.open("x")`,
+		`getmetatable("").__index.lower = nil`,
+	} {
+		if _, err := runProfile(L, ProfileReadonly, code); err == nil {
+			t.Errorf("%s: expected an error", code)
+		}
+	}
+	// Changing the libraries only changes the sandbox's copies
+	if _, err := runProfile(L, ProfileReadonly, `string.upper = nil math.pi = 3 coroutine.wrap = nil`); err != nil {
+		t.Fatal(err)
+	}
+	if err := L.DoString(`assert(string.upper("a") == "A" and math.pi > 3.14 and coroutine.wrap and ("a"):lower() == "a")`); err != nil {
+		t.Errorf("libraries changed: %v", err)
+	}
+	if L.GetGlobal("y") != lua.LNil {
+		t.Error("readonly globals should not reach the session")
+	}
+	if err := L.DoString(`assert(#mcp.value.items == 2 and mcp.value.items[1].name == "milk")`); err != nil {
+		t.Errorf("state changed: %v", err)
+	}
+
+	// Results are the real values, so they marshal normally
+	v, err := runProfile(L, ProfileReadonly, `return {first = mcp.value.items[1]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := v.(*lua.LTable).RawGetString("first").(*lua.LTable)
+	if first.RawGetString("name") != lua.LString("milk") {
+		t.Errorf("expected the real item in the result, got %v", first)
+	}
+}
+
+// TestProfileErrorLines tests that sandboxed errors report the caller's line numbers
+func TestProfileErrorLines(t *testing.T) {
+	L := newProfileState(t)
+	_, err := runProfile(L, ProfileReadonly, "local a = 1\nerror('boom')")
+	if err == nil || !strings.Contains(err.Error(), ":2: boom") {
+		t.Errorf("expected the error on line 2, got %v", err)
+	}
+}
+
+// TestParseRunProfile tests profile names and ordering
+func TestParseRunProfile(t *testing.T) {
+	if p, err := ParseRunProfile(""); err != nil || p != ProfileFull {
+		t.Errorf("empty: got %q, %v", p, err)
+	}
+	if _, err := ParseRunProfile("sandbox"); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+	if !ProfileNoIO.Allows(ProfileReadonly) || ProfileNoIO.Allows(ProfileFull) || !ProfileFull.Allows(ProfileNoIO) {
+		t.Error("profiles should allow themselves and stricter ones only")
+	}
+}
+
+// TestAPITokenProfiles tests tokens from settings limiting ui_run and refusing other endpoints
+func TestAPITokenProfiles(t *testing.T) {
+	baseDir := t.TempDir()
+	os.MkdirAll(filepath.Join(baseDir, "storage"), 0755)
+	os.WriteFile(filepath.Join(baseDir, "storage", "settings.json"),
+		[]byte(`{"apiTokens": {"reader": "readonly", "admin": "full", "typo": "readnoly", "sandbox": "no-io"}}`), 0644)
+	s := &Server{cfg: cli.DefaultConfig(), baseDir: baseDir}
+
+	tokens := GetAPITokens(baseDir)
+	if len(tokens) != 2 || tokens["reader"] != ProfileReadonly || tokens["admin"] != ProfileFull {
+		t.Errorf("unexpected tokens %v", tokens)
+	}
+
+	tests := []struct {
+		auth, body string
+		status     int
+	}{
+		{"", `{"code": "return 1"}`, 401},
+		{"Bearer nope", `{"code": "return 1"}`, 401},
+		{"Basic abc", `{"code": "return 1"}`, 401},
+		{"Bearer sandbox", `{"code": "return 1"}`, 401},
+		{"Bearer reader", `{"code": "return 1", "profile": "full"}`, 403},
+		{"Bearer reader", `{"code": "return 1", "profile": "no-io"}`, 403},
+		{"Bearer admin", `{"code": "return 1", "profile": "sandbox"}`, 400},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/api/ui_run", strings.NewReader(tt.body))
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		s.handleAPIRun(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s %s: got %d, want %d", tt.auth, tt.body, w.Code, tt.status)
+		}
+	}
+
+	called := false
+	handler := s.requireFullProfile(func(w http.ResponseWriter, r *http.Request) { called = true })
+	r := httptest.NewRequest("POST", "/api/ui_display", nil)
+	r.Header.Set("Authorization", "Bearer reader")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != 403 || called {
+		t.Errorf("readonly token should be refused, got %d", w.Code)
+	}
+	r.Header.Set("Authorization", "Bearer admin")
+	handler(httptest.NewRecorder(), r)
+	if !called {
+		t.Error("full token should be allowed")
+	}
+}
@@ -0,0 +1,355 @@
+package mcp
+
+// CRC: crc-LuaREPL.md | Seq: seq-lua-repl.md
+// Lua REPL: a WebSocket connection to the session with its own local environment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+	"golang.org/x/net/websocket"
+)
+
+// REPLRequest is a message from the REPL client
+type REPLRequest struct {
+	Type string `json:"type"`           // "eval" or "complete"
+	Code string `json:"code,omitempty"` // eval: the entry, possibly several lines
+	Text string `json:"text,omitempty"` // complete: the line up to the cursor
+}
+
+// REPLReply answers one REPLRequest
+type REPLReply struct {
+	Type        string   `json:"type"`                  // "result" or "completions"
+	Values      []string `json:"values,omitempty"`      // result: each returned value, pretty-printed
+	Stdout      string   `json:"stdout,omitempty"`      // result: print and io.write output
+	Error       string   `json:"error,omitempty"`       // result: error message
+	Traceback   string   `json:"traceback,omitempty"`   // result: Lua stack traceback
+	Incomplete  bool     `json:"incomplete,omitempty"`  // result: the entry needs more lines
+	DurationMS  float64  `json:"duration_ms,omitempty"` // result: execution time
+	Completions []string `json:"completions,omitempty"` // completions: candidates for the last word
+	Start       int      `json:"start,omitempty"`       // completions: where the completed word starts in text
+}
+
+// replConn is one REPL connection: its local environment and, for sandboxed profiles, its sandbox
+type replConn struct {
+	profile RunProfile
+	sandbox *sandbox
+	env     *lua.LTable
+}
+
+// replWordPattern matches the identifier path being completed at the end of a line
+var replWordPattern = regexp.MustCompile(`[A-Za-z_][\w]*(?:[.:][A-Za-z_]?[\w]*)*[.:]?$`)
+
+// handleREPL serves /repl. The connection runs under the token's profile, or a stricter one
+// named by ?profile=. Only pages from this machine may connect, so a website cannot drive it.
+// Spec: mcp.md Section 2.6
+// CRC: crc-LuaREPL.md
+func (s *Server) handleREPL(w http.ResponseWriter, r *http.Request) {
+	profile, status, err := s.tokenProfile(r, r.URL.Query().Get("profile"))
+	if err != nil {
+		apiError(w, status, err.Error())
+		return
+	}
+	ws := websocket.Server{
+		Handshake: checkREPLOrigin,
+		Handler: func(ws *websocket.Conn) {
+			s.serveREPL(ws, &replConn{profile: profile})
+		},
+	}
+	ws.ServeHTTP(w, r)
+}
+
+// checkREPLOrigin accepts connections without an Origin (the CLI) or from localhost
+func checkREPLOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if host := u.Hostname(); host != "localhost" && host != "127.0.0.1" && host != "::1" {
+		return fmt.Errorf("REPL connections from %s are not allowed", origin)
+	}
+	config.Origin = u
+	return nil
+}
+
+// serveREPL answers requests until the client disconnects
+func (s *Server) serveREPL(ws *websocket.Conn, conn *replConn) {
+	defer ws.Close()
+	for {
+		var req REPLRequest
+		if err := websocket.JSON.Receive(ws, &req); err != nil {
+			return
+		}
+		var reply REPLReply
+		switch req.Type {
+		case "eval":
+			reply = s.replEval(conn, req.Code)
+		case "complete":
+			reply = s.replComplete(conn, req.Text)
+		default:
+			reply = REPLReply{Type: "result", Error: fmt.Sprintf("unknown request type %q", req.Type)}
+		}
+		if err := websocket.JSON.Send(ws, reply); err != nil {
+			return
+		}
+	}
+}
+
+// replEval runs an entry in the current session's executor under the default ui_run timeout
+func (s *Server) replEval(conn *replConn, code string) REPLReply {
+	vendedID := s.currentVendedID
+	session := s.UiServer.GetLuaSession(vendedID)
+	if session == nil {
+		return REPLReply{Type: "result", Error: "no active session"}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRunTimeout)
+	defer cancel()
+	var reply REPLReply
+	_, err := s.SafeExecuteInSession(vendedID, func() (interface{}, error) {
+		stdout, _, _ := s.inLuaContext(ctx, session.State, vendedID, func() error {
+			reply = conn.eval(session.State, code)
+			return nil
+		})
+		reply.Stdout = stdout
+		return nil, nil
+	})
+	if err != nil {
+		reply = REPLReply{Type: "result", Error: err.Error()}
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		reply.Error, reply.Traceback = fmt.Sprintf("timed out after %s", defaultRunTimeout), ""
+	}
+	if reply.Error != "" {
+		s.logLua(LogEntry{Level: LogError, Session: vendedID, Source: logSourceRun, Message: "repl: " + reply.Error})
+	}
+	return reply
+}
+
+// replComplete lists completions in the session's executor, where the environment lives
+func (s *Server) replComplete(conn *replConn, text string) REPLReply {
+	vendedID := s.currentVendedID
+	session := s.UiServer.GetLuaSession(vendedID)
+	if session == nil {
+		return REPLReply{Type: "completions"}
+	}
+	var reply REPLReply
+	s.SafeExecuteInSession(vendedID, func() (interface{}, error) {
+		reply = conn.complete(session.State, text)
+		return nil, nil
+	})
+	return reply
+}
+
+// environment returns the connection's local environment, creating it on first use. Reads fall
+// through to the session's globals (or the profile's sandbox); assignments stay local, so
+// `_G.name = value` is how an entry sets a real global.
+func (c *replConn) environment(L *lua.LState) *lua.LTable {
+	if c.env != nil {
+		return c.env
+	}
+	var base lua.LValue = L.G.Global
+	if c.profile != ProfileFull {
+		c.sandbox = newSandbox(L, c.profile)
+		base = c.sandbox.env
+	}
+	c.env = L.NewTable()
+	mt := L.NewTable()
+	mt.RawSetString("__index", base)
+	L.SetMetatable(c.env, mt)
+	return c.env
+}
+
+// eval compiles an entry as an expression first, then as statements, and runs it in the
+// connection's environment. An entry that ends before its block does is incomplete.
+func (c *replConn) eval(L *lua.LState, code string) REPLReply {
+	reply := REPLReply{Type: "result"}
+	env := c.environment(L)
+	fn, err := L.Load(strings.NewReader("return "+code), "repl")
+	if err != nil {
+		fn, err = loadREPLStatements(L, code)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "at EOF") {
+			reply.Incomplete = true
+		} else {
+			reply.Error = err.Error()
+		}
+		return reply
+	}
+	fn.Env = env
+
+	start := time.Now()
+	base := L.GetTop()
+	L.Push(fn)
+	err = L.PCall(0, lua.MultRet, nil)
+	reply.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		reply.Error, reply.Traceback = luaErrorParts(err)
+		L.SetTop(base)
+		return reply
+	}
+	seen := make(map[*lua.LTable]bool)
+	for i := base + 1; i <= L.GetTop(); i++ {
+		value := L.Get(i)
+		if c.sandbox != nil {
+			value = c.sandbox.unwrapDeep(value, seen)
+		}
+		reply.Values = append(reply.Values, formatREPLValue(value))
+	}
+	L.SetTop(base)
+	return reply
+}
+
+// loadREPLStatements compiles an entry as statements, turning its top-level `local` declarations
+// into assignments so they land in the connection's environment and are kept for later entries.
+// Locals inside blocks and functions stay local, as in Lua.
+func loadREPLStatements(L *lua.LState, code string) (*lua.LFunction, error) {
+	chunk, err := parse.Parse(strings.NewReader(code), "repl")
+	if err != nil {
+		return nil, err
+	}
+	for i, stmt := range chunk {
+		local, ok := stmt.(*ast.LocalAssignStmt)
+		if !ok {
+			continue
+		}
+		assign := &ast.AssignStmt{Rhs: local.Exprs}
+		for _, name := range local.Names {
+			ident := &ast.IdentExpr{Value: name}
+			ident.SetLine(local.Line())
+			assign.Lhs = append(assign.Lhs, ident)
+		}
+		if len(assign.Rhs) == 0 {
+			assign.Rhs = []ast.Expr{&ast.NilExpr{}} // local x
+		}
+		assign.SetLine(local.Line())
+		assign.SetLastLine(local.LastLine())
+		chunk[i] = assign
+	}
+	proto, err := lua.Compile(chunk, "repl")
+	if err != nil {
+		return nil, err
+	}
+	return L.NewFunctionFromProto(proto), nil
+}
+
+// REPL display limits: deep or large tables are cut short rather than filling the terminal
+const (
+	replConvertDepth = 8
+	replConvertItems = 2000
+)
+
+// formatREPLValue pretty-prints tables as JSON through luaConverter, with shared and cyclic
+// tables as $ref markers
+func formatREPLValue(value lua.LValue) string {
+	switch v := value.(type) {
+	case lua.LString:
+		return fmt.Sprintf("%q", string(v))
+	case *lua.LTable:
+		data, err := json.MarshalIndent(newLuaConverter(replConvertDepth, replConvertItems).convert(v, "", 0), "", "  ")
+		if err != nil {
+			return v.String()
+		}
+		return string(data)
+	}
+	return value.String()
+}
+
+// complete lists the names that can follow the identifier path at the end of text: globals
+// and locals for a bare name, fields and prototype fields after `.` or `:`
+func (c *replConn) complete(L *lua.LState, text string) REPLReply {
+	reply := REPLReply{Type: "completions", Start: len(text)}
+	word := replWordPattern.FindString(text)
+	if word == "" {
+		return reply
+	}
+	cut := strings.LastIndexAny(word, ".:")
+	var tbl lua.LValue = c.environment(L)
+	prefix := word
+	if cut >= 0 {
+		prefix = word[cut+1:]
+		for _, name := range strings.FieldsFunc(word[:cut], func(r rune) bool { return r == '.' || r == ':' }) {
+			tbl = c.field(L, tbl, name)
+		}
+	}
+	reply.Start = len(text) - len(prefix)
+	methods := cut >= 0 && word[cut] == ':'
+	seen := make(map[string]bool)
+	for depth := 0; depth < 20; depth++ {
+		t, ok := tbl.(*lua.LTable)
+		if !ok {
+			break
+		}
+		if c.sandbox != nil {
+			t = c.sandbox.real(t)
+		}
+		t.ForEach(func(key, value lua.LValue) {
+			name, ok := key.(lua.LString)
+			if !ok || !strings.HasPrefix(string(name), prefix) || seen[string(name)] {
+				return
+			}
+			if c.sandbox != nil && noIOHidden[string(name)] {
+				return
+			}
+			if methods && value.Type() != lua.LTFunction {
+				return
+			}
+			seen[string(name)] = true
+			reply.Completions = append(reply.Completions, string(name))
+		})
+		tbl = c.inherits(L, t)
+	}
+	sort.Strings(reply.Completions)
+	return reply
+}
+
+// field reads name from a table, following prototype tables, without running any code
+func (c *replConn) field(L *lua.LState, tbl lua.LValue, name string) lua.LValue {
+	for depth := 0; depth < 20; depth++ {
+		t, ok := tbl.(*lua.LTable)
+		if !ok {
+			return lua.LNil
+		}
+		if c.sandbox != nil {
+			t = c.sandbox.real(t)
+		}
+		if value := t.RawGetString(name); value != lua.LNil {
+			return value
+		}
+		tbl = c.inherits(L, t)
+	}
+	return lua.LNil
+}
+
+// inherits returns the table tbl falls back to: a sandbox's globals, or a prototype
+func (c *replConn) inherits(L *lua.LState, tbl *lua.LTable) lua.LValue {
+	if c.sandbox != nil && tbl == c.sandbox.env {
+		return L.G.Global
+	}
+	return replPrototype(tbl)
+}
+
+// replPrototype returns the __index table a table inherits from, if any
+func replPrototype(tbl *lua.LTable) lua.LValue {
+	if mt, ok := tbl.Metatable.(*lua.LTable); ok {
+		if index, ok := mt.RawGetString("__index").(*lua.LTable); ok {
+			return index
+		}
+	}
+	return lua.LNil
+}
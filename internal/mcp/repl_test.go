@@ -0,0 +1,143 @@
+// Package mcp tests for the Lua REPL
+// Test: test-LuaREPL.md
+package mcp
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"golang.org/x/net/websocket"
+)
+
+// R204-R207: REPL Tests
+// Test Design: test-LuaREPL.md
+
+// replValues evaluates an entry and fails the test on an error
+func replValues(t *testing.T, conn *replConn, L *lua.LState, code string) []string {
+	t.Helper()
+	reply := conn.eval(L, code)
+	if reply.Error != "" || reply.Incomplete {
+		t.Fatalf("%s: %+v", code, reply)
+	}
+	return reply.Values
+}
+
+// TestREPLEval tests expressions, connection locals, multi-line entries and errors
+func TestREPLEval(t *testing.T) {
+	L := newProfileState(t)
+	conn := &replConn{profile: ProfileFull}
+
+	if got := replValues(t, conn, L, "1 + 1"); len(got) != 1 || got[0] != "2" {
+		t.Errorf("expression: got %q", got)
+	}
+	replValues(t, conn, L, "local x = 41")
+	if got := replValues(t, conn, L, "x + 1"); got[0] != "42" {
+		t.Errorf("local kept across entries: got %q", got)
+	}
+	if L.GetGlobal("x") != lua.LNil {
+		t.Error("connection locals should not become globals")
+	}
+	replValues(t, conn, L, "y = 1 local z, w = 2, 5 do local inner = 3 end local none")
+	if got := replValues(t, conn, L, "y + z, w, inner, none"); len(got) != 4 || got[0] != "3" || got[1] != "5" || got[2] != "nil" || got[3] != "nil" {
+		t.Errorf("top-level locals after other statements should be kept, block locals not: got %q", got)
+	}
+	replValues(t, conn, L, "_G.shared = true")
+	if L.GetGlobal("shared") != lua.LTrue {
+		t.Error("_G assignments should set globals")
+	}
+
+	if reply := conn.eval(L, "local function double(n)"); !reply.Incomplete {
+		t.Errorf("expected an incomplete entry, got %+v", reply)
+	}
+	replValues(t, conn, L, "local function double(n)\n  return n * 2\nend")
+	if got := replValues(t, conn, L, "double(4), 'ok'"); len(got) != 2 || got[0] != "8" || got[1] != `"ok"` {
+		t.Errorf("multiple values: got %q", got)
+	}
+	if got := replValues(t, conn, L, "mcp.value.items[1]"); got[0] != "{\n  \"name\": \"milk\"\n}" {
+		t.Errorf("table: got %q", got)
+	}
+	if got := replValues(t, conn, L, "_G"); !strings.Contains(got[0], `"$ref": "#"`) || !strings.Contains(got[0], "mcp") {
+		t.Errorf("cyclic table: got %q", got)
+	}
+
+	reply := conn.eval(L, "error('boom')")
+	if !strings.Contains(reply.Error, "boom") || !strings.Contains(reply.Traceback, "stack traceback") {
+		t.Errorf("error: got %+v", reply)
+	}
+	if reply := conn.eval(L, "x = = 1"); reply.Error == "" || reply.Incomplete {
+		t.Errorf("syntax error: got %+v", reply)
+	}
+}
+
+// TestREPLProfile tests that a readonly connection keeps its own locals but cannot change state
+func TestREPLProfile(t *testing.T) {
+	L := newProfileState(t)
+	conn := &replConn{profile: ProfileReadonly}
+	replValues(t, conn, L, "local n = #mcp.value.items")
+	if got := replValues(t, conn, L, "n"); got[0] != "2" {
+		t.Errorf("got %q", got)
+	}
+	if reply := conn.eval(L, "mcp.value.items[1].name = 'x'"); !strings.Contains(reply.Error, "readonly profile") {
+		t.Errorf("expected a readonly error, got %+v", reply)
+	}
+	if got := replValues(t, conn, L, "mcp.value.items[2]"); got[0] != "{\n  \"name\": \"eggs\"\n}" {
+		t.Errorf("views print as their tables, got %q", got)
+	}
+}
+
+// TestREPLComplete tests completion of globals, locals, fields and prototype methods
+func TestREPLComplete(t *testing.T) {
+	L := newProfileState(t)
+	conn := &replConn{profile: ProfileFull}
+	replValues(t, conn, L, "local mine = 1")
+
+	tests := []struct {
+		text  string
+		start int
+		want  []string
+	}{
+		{"mi", 0, []string{"mine"}},
+		{"return mc", 7, []string{"mcp"}},
+		{"mcp.va", 4, []string{"value"}},
+		{"mcp.value:c", 10, []string{"count"}},
+		{"mcp.value:", 10, []string{"add", "count"}},
+		{"mcp.value.", 10, []string{"__index", "add", "count", "items"}},
+		{"1 + ", 4, nil},
+	}
+	for _, tt := range tests {
+		reply := conn.complete(L, tt.text)
+		if reply.Start != tt.start || strings.Join(reply.Completions, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%q: got %d %q, want %d %q", tt.text, reply.Start, reply.Completions, tt.start, tt.want)
+		}
+	}
+
+	sandboxed := &replConn{profile: ProfileNoIO}
+	if reply := sandboxed.complete(L, "i"); strings.Contains(strings.Join(reply.Completions, ","), "io") {
+		t.Errorf("hidden globals should not complete, got %q", reply.Completions)
+	}
+	if reply := sandboxed.complete(L, "mcp.st"); strings.Join(reply.Completions, ",") != "status" {
+		t.Errorf("sandboxed fields: got %q", reply.Completions)
+	}
+}
+
+// TestREPLOrigin tests that only local pages may open the REPL
+func TestREPLOrigin(t *testing.T) {
+	for origin, ok := range map[string]bool{
+		"":                        true,
+		"http://127.0.0.1/":       true,
+		"http://localhost:8000":   true,
+		"https://example.com":     false,
+		"http://127.0.0.1.nip.io": false,
+	} {
+		r := httptest.NewRequest("GET", "/repl", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		err := checkREPLOrigin(&websocket.Config{}, r)
+		if (err == nil) != ok {
+			t.Errorf("%q: got %v", origin, err)
+		}
+	}
+}
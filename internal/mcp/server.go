@@ -40,7 +40,7 @@ type Server struct {
 	viewdefs        *cli.ViewdefManager
 	startFunc       func(port int) (string, error) // Callback to start HTTP server
 	getSessionCount func() int                     // Callback to get active session count
-	onClearLogs     func()                         // Callback to reopen Go log file after clearing logs
+	onClearLogs     func()                         // Callback to reopen Go log file after rotating logs
 
 	mu              sync.RWMutex
 	state           State
@@ -61,11 +61,46 @@ type Server struct {
 
 	// Wait time tracking (Spec: mcp.md Section 8.3)
 	waitStartTime time.Time // When agent last responded (updated on /wait return)
+
+	// Project audit cache, keyed by app file hashes (CRC: crc-Auditor.md)
+	auditCache *AuditCache
+
+	// Continuous audit results, published to Lua as mcp.diagnostics (CRC: crc-Auditor.md)
+	diagnostics    map[string]*AuditResult // app name -> latest audit
+	stopAuditWatch func()                  // Stops the app file watcher
+
+	// Theme selection (CRC: crc-ThemeManager.md)
+	sessionThemes map[string]string // vended session ID -> per-session theme override
+	appliedThemes map[string]string // vended session ID -> theme last pushed to the browser
+
+	// Structured Lua log, log/lua.jsonl (CRC: crc-LuaLog.md)
+	luaLog      *StructuredLog
+	stopErrTail func() // Stops tailing lua-err.log into luaLog
+
+	// Lua output wrappers and ui_run capture (CRC: crc-LuaLog.md)
+	outputWrappers map[string][2]*lua.LFunction // vended session ID -> print and io.write wrappers
+	runOutput      map[string]*strings.Builder  // vended session ID -> output of the ui_run in progress
+
+	// Log rotation (Spec: mcp.md Section 5.1)
+	logStarted      map[string]time.Time // log file name -> when it was last rotated or first seen
+	stopLogRotation func()               // Stops the periodic size and age check
+
+	// Status (Spec: mcp.md Section 5.4)
+	started       time.Time            // When the server was created, for uptime
+	publisher     *publisher.Publisher // The publisher this process tried to host
+	loadedApps    map[string][]string  // vended session ID -> apps loaded with mcp:app or mcp:display
+	displayedApps map[string]string    // vended session ID -> app last shown with mcp:display
+	subscriptions map[string][]string  // vended session ID -> topics from mcp:subscribe
+	browsers      map[string]int       // vended session ID -> open /browser-presence streams
+
+	// Prometheus metrics at /metrics (CRC: crc-Metrics.md)
+	metrics *metrics
 }
 
 // NewServer creates a new MCP server.
 func NewServer(cfg *cli.Config, uiServer *cli.Server, viewdefs *cli.ViewdefManager, startFunc func(port int) (string, error), getSessionCount func() int) *Server {
-	s := server.NewMCPServer("ui-server", "0.1.0")
+	m := newMetrics()
+	s := server.NewMCPServer("ui-server", "0.1.0", server.WithToolHandlerMiddleware(m.toolMiddleware))
 	srv := &Server{
 		mcpServer:       s,
 		cfg:             cfg,
@@ -77,6 +112,17 @@ func NewServer(cfg *cli.Config, uiServer *cli.Server, viewdefs *cli.ViewdefManag
 		stateWaiters:    make(map[string][]chan struct{}),
 		stateQueue:      make(map[string][]interface{}),
 		waitStartTime:   time.Now(), // Spec: mcp.md Section 8.3
+		auditCache:      NewAuditCache(),
+		diagnostics:     make(map[string]*AuditResult),
+		sessionThemes:   make(map[string]string),
+		appliedThemes:   make(map[string]string),
+		logStarted:      make(map[string]time.Time),
+		started:         time.Now(),
+		loadedApps:      make(map[string][]string),
+		displayedApps:   make(map[string]string),
+		subscriptions:   make(map[string][]string),
+		browsers:        make(map[string]int),
+		metrics:         m,
 	}
 	srv.registerTools()
 	srv.registerResources()
@@ -88,10 +134,45 @@ func (s *Server) SafeExecuteInSession(sessionID string, fn func() (interface{},
 	defer func() {
 		if r := recover(); r != nil {
 			s.cfg.Log(0, "PANIC in ExecuteInSession: %v", r)
+			s.metrics.countPanic()
 			err = fmt.Errorf("panic during execution: %v", r)
 		}
 	}()
-	return s.UiServer.ExecuteInSession(sessionID, fn)
+	return s.UiServer.ExecuteInSession(sessionID, func() (interface{}, error) {
+		start := time.Now()
+		defer func() { s.metrics.observeLua(time.Since(start)) }()
+		return fn()
+	})
+}
+
+// inLuaContext runs fn, within a session executor turn, with ctx as L's context so the VM stops
+// at its deadline or cancellation. Unless sessionID is empty, print and io.write output is
+// captured for that session and returned. The previous context is put back afterwards, even
+// when fn panics.
+func (s *Server) inLuaContext(ctx context.Context, L *lua.LState, sessionID string, fn func() error) (stdout string, elapsed time.Duration, err error) {
+	previous := L.Context()
+	L.SetContext(ctx)
+	if sessionID != "" {
+		s.startRunCapture(sessionID)
+	}
+	start := time.Now()
+	defer func() {
+		elapsed = time.Since(start)
+		if sessionID != "" {
+			stdout = s.stopRunCapture(sessionID)
+		}
+		if previous != nil {
+			L.SetContext(previous)
+		} else {
+			L.RemoveContext()
+		}
+	}()
+	return "", 0, fn()
+}
+
+// durationMS converts an execution time to the milliseconds ui_run and ui_batch report
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
 }
 
 // ServeStdio starts the MCP server on Stdin/Stdout.
@@ -109,6 +190,7 @@ func (s *Server) ServeSSE(addr string) error {
 	mux.HandleFunc("/variables", s.handleVariables)
 	mux.HandleFunc("/state", s.handleState)
 	mux.HandleFunc("/wait", s.handleWait)
+	mux.HandleFunc("/metrics", s.handleMetrics)
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		sseServer.ServeHTTP(w, r)
 	})
@@ -141,23 +223,28 @@ func (s *Server) StartHTTPServer() (int, error) {
 	mux.HandleFunc("/variables", s.handleVariables)
 	mux.HandleFunc("/state", s.handleState)
 	mux.HandleFunc("/wait", s.handleWait)
+	mux.HandleFunc("/browser-presence", s.handleBrowserPresence)
 
 	// Tool API endpoints (Spec 2.5)
 	mux.HandleFunc("/api/ui_status", s.handleAPIStatus)
 	mux.HandleFunc("/api/ui_run", s.handleAPIRun)
-	mux.HandleFunc("/api/ui_display", s.handleAPIDisplay)
-	mux.HandleFunc("/api/ui_configure", s.handleAPIConfigure)
-	mux.HandleFunc("/api/ui_install", s.handleAPIInstall)
-	mux.HandleFunc("/api/ui_update", s.handleAPIUpdate)
-	mux.HandleFunc("/api/ui_open_browser", s.handleAPIOpenBrowser)
-	mux.HandleFunc("/api/ui_audit", s.handleAPIAudit)
-	mux.HandleFunc("/api/ui_theme", s.handleAPITheme)
+	mux.HandleFunc("/api/ui_batch", s.handleAPIBatch)
+	mux.HandleFunc("/api/ui_snapshot", s.requireFullProfile(s.handleAPISnapshot))
+	mux.HandleFunc("/api/ui_display", s.requireFullProfile(s.handleAPIDisplay))
+	mux.HandleFunc("/api/ui_configure", s.requireFullProfile(s.handleAPIConfigure))
+	mux.HandleFunc("/api/ui_install", s.requireFullProfile(s.handleAPIInstall))
+	mux.HandleFunc("/api/ui_update", s.requireFullProfile(s.handleAPIUpdate))
+	mux.HandleFunc("/api/ui_open_browser", s.requireFullProfile(s.handleAPIOpenBrowser))
+	mux.HandleFunc("/api/ui_audit", s.requireFullProfile(s.handleAPIAudit))
+	mux.HandleFunc("/api/ui_theme", s.requireFullProfile(s.handleAPITheme))
+	mux.HandleFunc("/api/logs", s.handleAPILogs)
 	mux.HandleFunc("/api/resource/", s.handleAPIResource)
+	mux.HandleFunc("/repl", s.handleREPL)
 	mux.HandleFunc("/app/", s.handleAppReadme)
 	mux.HandleFunc("/", s.handleStaticFile)
 
-	// Listen on random port
-	listener, err := net.Listen("tcp", ":0")
+	// Listen on a random localhost port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		return 0, fmt.Errorf("failed to listen: %w", err)
 	}
@@ -175,7 +262,7 @@ func (s *Server) StartHTTPServer() (int, error) {
 		}
 	}()
 
-	s.cfg.Log(0, "HTTP server listening on port %d (/variables, /state, /wait, /api/*)", port)
+	s.cfg.Log(0, "HTTP server listening on port %d (/variables, /state, /wait, /metrics, /api/*, /repl)", port)
 
 	// Write mcp-port file
 	if err := s.WriteMCPPortFile(port); err != nil {
@@ -247,22 +334,24 @@ func (s *Server) Configure(baseDir string) error {
 	s.mu.Lock()
 	s.baseDir = baseDir
 	s.state = Configured // Temporary state during configuration
-	s.mu.Unlock()        // Release lock before I/O operations
+	// Store log paths for session setup
+	s.logPath = filepath.Join(baseDir, "log", "lua.log")
+	s.errPath = filepath.Join(baseDir, "log", "lua-err.log")
+	s.mu.Unlock() // Release lock before I/O operations
 
 	// Create base directory and log directory
 	if err := os.MkdirAll(filepath.Join(baseDir, "log"), 0755); err != nil {
 		return fmt.Errorf("failed to create directories: %w", err)
 	}
 
-	// Clear existing log files and reopen Go log handles
-	// Spec: mcp.md Section 5.1 - ui_configure clears logs
-	if err := s.ClearLogs(); err != nil {
-		s.cfg.Log(1, "Warning: failed to clear logs: %v", err)
+	// Archive existing log files and reopen Go log handles. The Lua output is not reopened
+	// here: openLuaLog replaces the structured log and the next session redirects to the new paths.
+	// Spec: mcp.md Section 5.1 - ui_configure rotates logs
+	if err := s.rotateLogs(true, false); err != nil {
+		s.cfg.Log(1, "Warning: failed to rotate logs: %v", err)
 	}
-
-	// Store log paths for session setup
-	s.logPath = filepath.Join(baseDir, "log", "lua.log")
-	s.errPath = filepath.Join(baseDir, "log", "lua-err.log")
+	s.startLogRotation()
+	s.openLuaLog(baseDir)
 
 	// Auto-install if README.md is missing
 	// Spec: mcp.md Section 3.1 - Startup Behavior
@@ -285,7 +374,7 @@ func (s *Server) SetBaseDir(baseDir string) {
 	s.baseDir = baseDir
 }
 
-// SetOnClearLogs sets a callback to be called after logs are cleared.
+// SetOnClearLogs sets a callback to be called after logs are rotated.
 // Used by main.go to reopen the Go log file handle.
 // CRC: crc-MCPServer.md
 func (s *Server) SetOnClearLogs(fn func()) {
@@ -294,48 +383,6 @@ func (s *Server) SetOnClearLogs(fn func()) {
 	s.onClearLogs = fn
 }
 
-// ClearLogs clears all log files in the log directory.
-// After clearing, calls the onClearLogs callback to allow reopening Go log file handles.
-// Spec: mcp.md Section 5.1 - ui_configure clears logs
-// CRC: crc-MCPServer.md
-func (s *Server) ClearLogs() error {
-	s.mu.RLock()
-	baseDir := s.baseDir
-	callback := s.onClearLogs
-	s.mu.RUnlock()
-
-	if baseDir == "" {
-		return nil // No base directory configured yet
-	}
-
-	logDir := filepath.Join(baseDir, "log")
-	entries, err := os.ReadDir(logDir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil // Log directory doesn't exist, nothing to clear
-		}
-		return fmt.Errorf("failed to read log directory: %w", err)
-	}
-
-	// Delete all files in the log directory
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue // Skip subdirectories
-		}
-		path := filepath.Join(logDir, entry.Name())
-		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
-			s.cfg.Log(1, "Warning: failed to remove log file %s: %v", path, err)
-		}
-	}
-
-	// Call callback to reopen Go log file handles
-	if callback != nil {
-		callback()
-	}
-
-	return nil
-}
-
 // StartAndCreateSession starts the UI server and creates a session with mcp global.
 // This is called both on process startup (auto-start) and by ui_configure (reconfiguration).
 // Spec: mcp.md Section 3.1 - Server auto-starts
@@ -360,6 +407,8 @@ func (s *Server) StartAndCreateSession() (string, error) {
 			s.mu.RUnlock()
 			http.ServeFile(w, r, filepath.Join(baseDir, "html", "variables.html"))
 		})
+		// Browser tabs hold a presence stream open so ui_open_browser and ui_status can count them
+		s.UiServer.HttpEndpoint.HandleFunc("/browser-presence", s.handleBrowserPresence)
 		s.variablesRegistered = true
 	}
 
@@ -390,11 +439,16 @@ func (s *Server) StartAndCreateSession() (string, error) {
 	s.currentVendedID = vendedID
 
 	// Apply Lua I/O redirection to the new session (if paths were set at configure time)
-	if s.logPath != "" && s.errPath != "" {
+	s.mu.RLock()
+	logPath, errPath := s.logPath, s.errPath
+	s.mu.RUnlock()
+	if logPath != "" && errPath != "" {
 		luaSession := s.UiServer.GetLuaSession(vendedID)
 		if luaSession != nil {
-			if err := luaSession.RedirectOutput(s.logPath, s.errPath); err != nil {
+			if err := luaSession.RedirectOutput(logPath, errPath); err != nil {
 				s.cfg.Log(0, "Warning: failed to redirect Lua output: %v", err)
+			} else {
+				s.startErrTail(vendedID)
 			}
 		}
 	}
@@ -456,6 +510,10 @@ func (s *Server) Start() (string, error) {
 		_ = stopWatch // Watcher runs for server lifetime
 	}
 
+	// Re-audit apps as their files are saved, publishing results to mcp.diagnostics
+	// CRC: crc-Auditor.md
+	s.startAuditWatcher()
+
 	// Host the publisher on the fixed port (best-effort, first MCP server wins)
 	// CRC: crc-MCPServer.md | Seq: seq-publisher-lifecycle.md
 	go s.tryStartPublisher()
@@ -473,7 +531,14 @@ func (s *Server) Start() (string, error) {
 // If the port is already taken by another MCP server, it exits silently.
 // CRC: crc-MCPServer.md | Seq: seq-publisher-lifecycle.md
 func (s *Server) tryStartPublisher() {
+	s.mu.Lock()
+	if s.publisher != nil && s.publisher.Hosting() {
+		s.mu.Unlock()
+		return // Still hosting from before a reconfiguration
+	}
 	pub := publisher.New(publisher.DefaultAddr)
+	s.publisher = pub
+	s.mu.Unlock()
 	if err := pub.ListenAndServe(); err != nil {
 		s.cfg.Log(1, "Publisher: %v (another instance may be hosting it)", err)
 	}
@@ -493,6 +558,9 @@ func (s *Server) Stop() error {
 	vendedID := s.currentVendedID
 	s.mu.Unlock() // Release before calling DestroySession to avoid deadlock
 
+	// Stop re-auditing; StartAndCreateSession starts a new watcher
+	s.stopAuditWatcher()
+
 	// Notify waiters before destroying session. Use SafeExecuteInSession to
 	// serialize with other Lua operations (prevents stomping on stdout writes).
 	// Spec: mcp.md Section 3.2 - Reconfiguration notifies waiters
@@ -519,6 +587,9 @@ func (s *Server) Stop() error {
 	s.currentVendedID = ""
 	s.state = Configured
 	s.url = ""
+	delete(s.loadedApps, vendedID)
+	delete(s.displayedApps, vendedID)
+	delete(s.subscriptions, vendedID)
 	s.mu.Unlock()
 
 	return nil
@@ -678,15 +749,18 @@ func writeEventsJSON(w http.ResponseWriter, events []interface{}) bool {
 }
 
 // respondWithEvents drains the queue, updates waitStartTime, and writes response.
+// Returns the /wait result for metrics: events or empty.
 // Used by handleWait to consolidate the response logic for signal and timeout cases.
-func (s *Server) respondWithEvents(w http.ResponseWriter, sessionID string) {
+func (s *Server) respondWithEvents(w http.ResponseWriter, sessionID string) string {
 	s.mu.Lock()
 	s.waitStartTime = time.Now()
 	s.mu.Unlock()
 
 	if !writeEventsJSON(w, s.drainStateQueue(sessionID)) {
 		w.WriteHeader(http.StatusNoContent)
+		return "empty"
 	}
+	return "events"
 }
 
 // handleWait handles GET /wait - long-poll for state changes on the current session.
@@ -718,10 +792,12 @@ func (s *Server) handleWait(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if there are already events queued
+	start := time.Now()
 	if writeEventsJSON(w, s.drainStateQueue(sessionID)) {
 		s.mu.Lock()
 		s.waitStartTime = time.Now()
 		s.mu.Unlock()
+		s.metrics.observeWait("events", time.Since(start))
 		return
 	}
 
@@ -759,13 +835,14 @@ func (s *Server) handleWait(w http.ResponseWriter, r *http.Request) {
 	// Wait for signal or timeout
 	select {
 	case <-waiterCh:
-		s.respondWithEvents(w, sessionID)
+		s.metrics.observeWait(s.respondWithEvents(w, sessionID), time.Since(start))
 	case <-time.After(time.Duration(timeout) * time.Second):
-		s.respondWithEvents(w, sessionID)
+		s.metrics.observeWait(s.respondWithEvents(w, sessionID), time.Since(start))
 	case <-r.Context().Done():
 		// Client disconnected - update waitStartTime so waitTime() resets
 		s.mu.Lock()
 		s.waitStartTime = time.Now()
 		s.mu.Unlock()
+		s.metrics.observeWait("disconnected", time.Since(start))
 	}
 }
@@ -0,0 +1,510 @@
+package mcp
+
+// CRC: crc-Snapshot.md | Seq: seq-mcp-snapshot.md
+// ui_snapshot: render mcp.value's viewdef tree to HTML on the server, without a browser
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zot/ui-engine/cli"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Snapshot limits: nested views, views in total and items per list
+const (
+	maxSnapshotDepth = 32
+	maxSnapshotViews = 2000
+	maxSnapshotItems = 1000
+)
+
+// valueElements take ui-value as their value attribute; other elements take it as text
+var valueElements = map[string]bool{
+	"input": true, "textarea": true, "select": true,
+	"sl-input": true, "sl-textarea": true, "sl-select": true, "sl-radio-group": true,
+	"sl-range": true, "sl-rating": true, "sl-color-picker": true,
+}
+
+// SnapshotResult is the ui_snapshot response
+type SnapshotResult struct {
+	HTML     string          `json:"html"`              // Rendered mcp.value, bindings resolved
+	Type     string          `json:"type"`              // mcp.value's type
+	Viewdefs []string        `json:"viewdefs"`          // Viewdefs used, as TYPE.NAMESPACE
+	Missing  []string        `json:"missing,omitempty"` // TYPE.NAMESPACE lookups with no viewdef
+	Errors   []SnapshotError `json:"errors,omitempty"`  // Bindings that could not be resolved
+}
+
+// SnapshotError is a binding the renderer could not resolve. The element also carries it as
+// data-snapshot-error.
+type SnapshotError struct {
+	Viewdef   string `json:"viewdef"`
+	Attribute string `json:"attribute"`
+	Path      string `json:"path"`
+	Error     string `json:"error"`
+}
+
+// snapshotRenderer renders Lua objects with viewdefs the way the browser does, resolving
+// binding paths against the Lua objects themselves
+type snapshotRenderer struct {
+	L        *lua.LState
+	viewdefs map[string]string // TYPE.NAMESPACE -> viewdef HTML
+	used     map[string]bool
+	missing  map[string]bool
+	errors   []SnapshotError
+	views    int
+}
+
+func newSnapshotRenderer(L *lua.LState, viewdefs map[string]string) *snapshotRenderer {
+	return &snapshotRenderer{L: L, viewdefs: viewdefs, used: make(map[string]bool), missing: make(map[string]bool)}
+}
+
+// loadSnapshotViewdefs reads viewdefs from the sources the UI engine's viewdef manager loads:
+// {base_dir}/viewdefs, then those of apps not linked there, then the binary's bundle. The
+// manager itself is not asked; see spec Section 5.8.
+func loadSnapshotViewdefs(baseDir string) map[string]string {
+	viewdefs := make(map[string]string)
+	add := func(file string, read func(string) ([]byte, error)) {
+		key := strings.TrimSuffix(filepath.Base(file), ".html")
+		if _, ok := viewdefs[key]; ok || !strings.HasSuffix(file, ".html") {
+			return
+		}
+		if content, err := read(file); err == nil {
+			viewdefs[key] = string(content)
+		}
+	}
+	dirs := []string{filepath.Join(baseDir, "viewdefs")}
+	appDirs, _ := filepath.Glob(filepath.Join(baseDir, "apps", "*", "viewdefs"))
+	sort.Strings(appDirs)
+	for _, dir := range append(dirs, appDirs...) {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.html"))
+		for _, file := range files {
+			add(file, os.ReadFile)
+		}
+	}
+	if bundled, _ := cli.IsBundled(); bundled {
+		files, _ := cli.BundleListFiles("viewdefs")
+		for _, file := range files {
+			add(file, cli.BundleReadFile)
+		}
+	}
+	return viewdefs
+}
+
+// render renders value as the top-level view and returns the result
+func (r *snapshotRenderer) render(value lua.LValue, namespace string) *SnapshotResult {
+	result := &SnapshotResult{Viewdefs: []string{}}
+	if tbl, ok := value.(*lua.LTable); ok {
+		result.Type = snapshotText(r.L.GetField(tbl, "type"))
+	}
+	var out strings.Builder
+	for _, node := range r.renderView(value, namespace, 0) {
+		html.Render(&out, node)
+	}
+	result.HTML = out.String()
+	for key := range r.used {
+		result.Viewdefs = append(result.Viewdefs, key)
+	}
+	for key := range r.missing {
+		result.Missing = append(result.Missing, key)
+	}
+	sort.Strings(result.Viewdefs)
+	sort.Strings(result.Missing)
+	result.Errors = r.errors
+	return result
+}
+
+// renderView renders an object with the viewdef for its type in namespace, falling back to
+// DEFAULT. Values that are not objects render nothing, as in the browser.
+func (r *snapshotRenderer) renderView(value lua.LValue, namespace string, depth int) []*html.Node {
+	tbl, ok := value.(*lua.LTable)
+	if !ok {
+		return nil
+	}
+	if depth >= maxSnapshotDepth || r.views >= maxSnapshotViews {
+		return []*html.Node{{Type: html.CommentNode, Data: " snapshot: view limit reached "}}
+	}
+	r.views++
+	typeName := snapshotText(r.L.GetField(tbl, "type"))
+	key, source := r.lookup(typeName, namespace)
+	if source == "" {
+		missing := typeName + "." + namespace
+		if typeName == "" {
+			missing = "(no type)"
+		}
+		r.missing[missing] = true
+		placeholder := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+		setAttr(placeholder, "data-snapshot-missing", missing)
+		return []*html.Node{placeholder}
+	}
+	r.used[key] = true
+	nodes, err := parseViewdef(source)
+	if err != nil {
+		r.errors = append(r.errors, SnapshotError{Viewdef: key, Error: err.Error()})
+		return nil
+	}
+	for _, node := range nodes {
+		r.walk(node, tbl, key, namespace, depth)
+	}
+	return nodes
+}
+
+// lookup finds the viewdef for a type in namespace or DEFAULT
+func (r *snapshotRenderer) lookup(typeName, namespace string) (string, string) {
+	if typeName == "" {
+		return "", ""
+	}
+	for _, ns := range []string{namespace, "DEFAULT"} {
+		key := typeName + "." + ns
+		if source, ok := r.viewdefs[key]; ok {
+			return key, source
+		}
+	}
+	return "", ""
+}
+
+// parseViewdef returns the nodes inside a viewdef's <template>, or all of it without one
+func parseViewdef(source string) ([]*html.Node, error) {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(source), body)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		if node.Type == html.ElementNode && node.Data == "template" {
+			var children []*html.Node
+			for child := node.FirstChild; child != nil; {
+				next := child.NextSibling
+				node.RemoveChild(child)
+				children = append(children, child)
+				child = next
+			}
+			return children, nil
+		}
+	}
+	return nodes, nil
+}
+
+// walk resolves the bindings of n and its descendants against self
+func (r *snapshotRenderer) walk(n *html.Node, self *lua.LTable, viewdef, namespace string, depth int) {
+	if n.Type != html.ElementNode {
+		return
+	}
+	var children []*html.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		children = append(children, child)
+	}
+	for _, child := range children {
+		r.walk(child, self, viewdef, namespace, depth)
+	}
+	childNamespace := namespace
+	if ns := getAttr(n, "ui-namespace"); ns != "" {
+		childNamespace = ns
+	}
+
+	attrs := append([]html.Attribute(nil), n.Attr...)
+	for _, attr := range attrs {
+		name := attr.Key
+		if !strings.HasPrefix(name, "ui-") || name == "ui-namespace" || name == "ui-action" || name == "ui-code" || strings.HasPrefix(name, "ui-event-") {
+			continue
+		}
+		path, params := splitBindingPath(attr.Val)
+		value, err := r.resolve(self, path)
+		if err != nil {
+			r.errors = append(r.errors, SnapshotError{Viewdef: viewdef, Attribute: name, Path: attr.Val, Error: err.Error()})
+			setAttr(n, "data-snapshot-error", err.Error())
+			continue
+		}
+		switch {
+		case name == "ui-value":
+			if valueElements[n.Data] {
+				setAttr(n, "value", snapshotText(value))
+			} else {
+				for n.FirstChild != nil {
+					n.RemoveChild(n.FirstChild)
+				}
+				n.AppendChild(&html.Node{Type: html.TextNode, Data: snapshotText(value)})
+			}
+		case strings.HasPrefix(name, "ui-attr-"):
+			target := strings.TrimPrefix(name, "ui-attr-")
+			switch {
+			case !lua.LVAsBool(value):
+				removeAttr(n, target)
+			case value == lua.LTrue:
+				setAttr(n, target, "")
+			default:
+				setAttr(n, target, snapshotText(value))
+			}
+		case strings.HasPrefix(name, "ui-class-"):
+			if lua.LVAsBool(value) {
+				setAttr(n, "class", strings.TrimSpace(getAttr(n, "class")+" "+strings.TrimPrefix(name, "ui-class-")))
+			}
+		case strings.HasPrefix(name, "ui-style-"):
+			if text := snapshotText(value); text != "" {
+				style := strings.TrimSuffix(strings.TrimSpace(getAttr(n, "style")), ";")
+				if style != "" {
+					style += "; "
+				}
+				setAttr(n, "style", style+strings.TrimPrefix(name, "ui-style-")+": "+text)
+			}
+		case name == "ui-view":
+			if params.Get("wrapper") == "lua.ViewList" {
+				list := r.L.NewTable()
+				list.RawSetString("type", lua.LString("lua.ViewList"))
+				list.RawSetString("items", value)
+				value = list
+			} else if wrapper := params.Get("wrapper"); wrapper != "" {
+				r.note(n, viewdef, name, attr.Val, fmt.Sprintf("wrapper %s is not applied in snapshots", wrapper))
+			}
+			for _, node := range r.renderView(value, childNamespace, depth+1) {
+				n.AppendChild(node)
+			}
+		case name == "ui-viewlist":
+			if presenter := params.Get("item"); presenter != "" {
+				r.note(n, viewdef, name, attr.Val, fmt.Sprintf("item presenter %s is not applied in snapshots", presenter))
+			}
+			itemNamespace := "list-item"
+			if ns := getAttr(n, "ui-namespace"); ns != "" {
+				itemNamespace = ns
+			}
+			r.renderList(n, value, itemNamespace, viewdef, attr.Val, depth)
+		}
+	}
+}
+
+// renderList appends a lua.ViewListItem view for each element of an array
+func (r *snapshotRenderer) renderList(n *html.Node, value lua.LValue, namespace, viewdef, path string, depth int) {
+	items, ok := value.(*lua.LTable)
+	if !ok {
+		return
+	}
+	for i := 1; ; i++ {
+		element := items.RawGetInt(i)
+		if element == lua.LNil {
+			break
+		}
+		if i > maxSnapshotItems {
+			r.note(n, viewdef, "ui-viewlist", path, fmt.Sprintf("list truncated at %d items", maxSnapshotItems))
+			break
+		}
+		item := r.L.NewTable()
+		item.RawSetString("type", lua.LString("lua.ViewListItem"))
+		item.RawSetString("item", element)
+		item.RawSetString("baseItem", element)
+		item.RawSetString("index", lua.LNumber(i-1))
+		item.RawSetString("list", items)
+		for _, node := range r.renderView(item, namespace, depth+1) {
+			n.AppendChild(node)
+		}
+	}
+}
+
+// note records a limitation of the snapshot on an element
+func (r *snapshotRenderer) note(n *html.Node, viewdef, attribute, path, message string) {
+	r.errors = append(r.errors, SnapshotError{Viewdef: viewdef, Attribute: attribute, Path: path, Error: message})
+	setAttr(n, "data-snapshot-error", message)
+}
+
+// resolve follows a binding path from self: fields, zero-argument method calls and [index]
+// elements, where an index is a 0-based number or a field holding one
+func (r *snapshotRenderer) resolve(self *lua.LTable, path string) (lua.LValue, error) {
+	var current lua.LValue = self
+	rest := path
+	for rest != "" {
+		end := strings.IndexAny(rest, ".([")
+		if end < 0 {
+			end = len(rest)
+		}
+		name := rest[:end]
+		rest = rest[end:]
+		if name == "" {
+			return nil, fmt.Errorf("invalid path")
+		}
+		tbl, ok := current.(*lua.LTable)
+		if !ok {
+			return nil, fmt.Errorf("cannot read %s of a %s value", name, current.Type())
+		}
+		current = r.L.GetField(tbl, name)
+		if strings.HasPrefix(rest, "(") {
+			closing := strings.Index(rest, ")")
+			if closing < 0 {
+				return nil, fmt.Errorf("unclosed call to %s", name)
+			}
+			if strings.TrimSpace(rest[1:closing]) != "" {
+				return nil, fmt.Errorf("%s: method arguments are not supported in snapshots", name)
+			}
+			rest = rest[closing+1:]
+			fn, ok := current.(*lua.LFunction)
+			if !ok {
+				return nil, fmt.Errorf("%s is not a method", name)
+			}
+			if err := r.L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, tbl); err != nil {
+				message, _ := luaErrorParts(err)
+				return nil, fmt.Errorf("%s(): %s", name, message)
+			}
+			current = r.L.Get(-1)
+			r.L.Pop(1)
+		}
+		for strings.HasPrefix(rest, "[") {
+			closing := strings.Index(rest, "]")
+			if closing < 0 {
+				return nil, fmt.Errorf("unclosed index after %s", name)
+			}
+			index, err := r.index(self, rest[1:closing])
+			if err != nil {
+				return nil, err
+			}
+			rest = rest[closing+1:]
+			list, ok := current.(*lua.LTable)
+			if !ok {
+				return nil, fmt.Errorf("cannot index a %s value", current.Type())
+			}
+			current = list.RawGetInt(index + 1)
+		}
+		rest = strings.TrimPrefix(rest, ".")
+	}
+	return current, nil
+}
+
+// index reads a 0-based index: a number, or a field of self holding one
+func (r *snapshotRenderer) index(self *lua.LTable, text string) (int, error) {
+	if n, err := strconv.Atoi(text); err == nil {
+		return n, nil
+	}
+	if n, ok := r.L.GetField(self, text).(lua.LNumber); ok {
+		return int(n), nil
+	}
+	return 0, fmt.Errorf("index %s is not a number", text)
+}
+
+// splitBindingPath separates a binding path from its ?parameters
+func splitBindingPath(binding string) (string, url.Values) {
+	path, query, _ := strings.Cut(binding, "?")
+	params, _ := url.ParseQuery(query)
+	return strings.TrimSpace(path), params
+}
+
+// snapshotText is the text the browser shows for a bound value
+func snapshotText(v lua.LValue) string {
+	switch val := v.(type) {
+	case *lua.LNilType:
+		return ""
+	case lua.LString:
+		return string(val)
+	case lua.LNumber, lua.LBool:
+		return val.String()
+	case *lua.LTable:
+		data, _ := json.Marshal(luaToGo(val))
+		return string(data)
+	}
+	return ""
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, value string) {
+	for i, attr := range n.Attr {
+		if attr.Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
+
+func removeAttr(n *html.Node, key string) {
+	for i, attr := range n.Attr {
+		if attr.Key == key {
+			n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+			return
+		}
+	}
+}
+
+// Spec: mcp.md Section 5.8
+// CRC: crc-Snapshot.md
+// Sequence: seq-mcp-snapshot.md
+func (s *Server) handleSnapshot(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args, _ := request.Params.Arguments.(map[string]interface{})
+	sessionID, ok := args["sessionId"].(string)
+	if !ok || sessionID == "" {
+		sessionID = s.currentVendedID
+	}
+	if sessionID == "" {
+		return mcp.NewToolResultError("no active session - server may not have started correctly"), nil
+	}
+	session := s.UiServer.GetLuaSession(sessionID)
+	if session == nil {
+		return mcp.NewToolResultError(fmt.Sprintf("session %s not found", sessionID)), nil
+	}
+	namespace, _ := args["namespace"].(string)
+	if namespace == "" {
+		namespace = "DEFAULT"
+	}
+	timeout, err := runTimeout(args["timeout"])
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	s.mu.RLock()
+	baseDir := s.baseDir
+	s.mu.RUnlock()
+	viewdefs := loadSnapshotViewdefs(baseDir)
+
+	// Methods in binding paths run in the session executor, stopped at the timeout like ui_run
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var snapshot *SnapshotResult
+	_, err = s.SafeExecuteInSession(sessionID, func() (interface{}, error) {
+		L := session.State
+		_, _, err := s.inLuaContext(runCtx, L, "", func() error {
+			mcpTable, ok := L.GetGlobal("mcp").(*lua.LTable)
+			if !ok {
+				return fmt.Errorf("mcp global not found")
+			}
+			value := L.GetField(mcpTable, "value")
+			if value == lua.LNil {
+				return fmt.Errorf("nothing is displayed: mcp.value is nil")
+			}
+			snapshot = newSnapshotRenderer(L, viewdefs).render(value, namespace)
+			return nil
+		})
+		return nil, err
+	})
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("snapshot failed: %v", err)), nil
+	}
+	jsonResult, _ := json.MarshalIndent(snapshot, "", "  ")
+	return mcp.NewToolResultText(string(jsonResult)), nil
+}
+
+// handleAPISnapshot handles POST /api/ui_snapshot
+func (s *Server) handleAPISnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, http.StatusMethodNotAllowed, "POST required")
+		return
+	}
+	args, err := parseJSONBody(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	result, err := s.callMCPHandler(r.Context(), "ui_snapshot", s.handleSnapshot, args)
+	apiResponse(w, result, err)
+}
@@ -0,0 +1,136 @@
+// Package mcp tests for ui_snapshot
+// Test: test-Snapshot.md
+package mcp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// R224-R227: Snapshot Tests
+// Test Design: test-Snapshot.md
+
+// snapshotViewdefs are a small todo app's viewdefs
+var snapshotViewdefs = map[string]string{
+	"Todo.DEFAULT": `<template>
+  <div class="todo" ui-class-busy="busy" ui-style-color="color">
+    <h1 ui-value="title">placeholder</h1>
+    <sl-input ui-value="draft?keypress"></sl-input>
+    <span ui-value="count()"></span>
+    <sl-button ui-action="add()" ui-attr-disabled="empty()">Add</sl-button>
+    <span ui-value="items[0].name"></span>
+    <div ui-viewlist="items"></div>
+    <div ui-view="selected"></div>
+    <span ui-value="broken()"></span>
+  </div>
+</template>`,
+	"lua.ViewListItem.list-item": `<template><div ui-view="item"></div></template>`,
+	"Item.list-item":             `<template><li ui-value="name" ui-class-done="done"></li></template>`,
+}
+
+// newSnapshotState returns a state holding a displayed todo app
+func newSnapshotState(t *testing.T) *lua.LState {
+	t.Helper()
+	L := lua.NewState()
+	t.Cleanup(L.Close)
+	err := L.DoString(`
+		Todo = {type = "Todo"}
+		Todo.__index = Todo
+		function Todo:count() return #self.items end
+		function Todo:empty() return self.draft == "" end
+		function Todo:broken() error("no luck") end
+		Item = {type = "Item"}
+		Item.__index = Item
+		app = setmetatable({
+			title = "Groceries", draft = "", busy = true, color = "red",
+			items = {setmetatable({name = "milk", done = true}, Item), setmetatable({name = "eggs"}, Item)},
+			selected = {type = "Detail"},
+		}, Todo)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return L
+}
+
+// TestSnapshotRender tests bindings, lists, nested views and missing viewdefs
+func TestSnapshotRender(t *testing.T) {
+	L := newSnapshotState(t)
+	result := newSnapshotRenderer(L, snapshotViewdefs).render(L.GetGlobal("app"), "DEFAULT")
+
+	for _, want := range []string{
+		`<div class="todo busy" ui-class-busy="busy" ui-style-color="color" style="color: red">`,
+		`<h1 ui-value="title">Groceries</h1>`,
+		`<sl-input ui-value="draft?keypress" value=""></sl-input>`,
+		`<span ui-value="count()">2</span>`,
+		`<sl-button ui-action="add()" ui-attr-disabled="empty()" disabled="">Add</sl-button>`,
+		`<span ui-value="items[0].name">milk</span>`,
+		`<li ui-value="name" ui-class-done="done" class="done">milk</li>`,
+		`<li ui-value="name" ui-class-done="done">eggs</li>`,
+		`<div data-snapshot-missing="Detail.DEFAULT"></div>`,
+		`data-snapshot-error="broken(): `,
+	} {
+		if !strings.Contains(result.HTML, want) {
+			t.Errorf("missing %s in\n%s", want, result.HTML)
+		}
+	}
+	if strings.Contains(result.HTML, "<template") || strings.Contains(result.HTML, "placeholder") {
+		t.Errorf("expected template contents with bindings resolved, got\n%s", result.HTML)
+	}
+	if result.Type != "Todo" || strings.Join(result.Viewdefs, ",") != "Item.list-item,Todo.DEFAULT,lua.ViewListItem.list-item" {
+		t.Errorf("unexpected type %s or viewdefs %v", result.Type, result.Viewdefs)
+	}
+	if strings.Join(result.Missing, ",") != "Detail.DEFAULT" {
+		t.Errorf("unexpected missing %v", result.Missing)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Path != "broken()" || !strings.Contains(result.Errors[0].Error, "no luck") {
+		t.Errorf("unexpected errors %+v", result.Errors)
+	}
+}
+
+// TestSnapshotPaths tests path resolution
+func TestSnapshotPaths(t *testing.T) {
+	L := newSnapshotState(t)
+	if err := L.DoString(`app.index = 1`); err != nil {
+		t.Fatal(err)
+	}
+	r := newSnapshotRenderer(L, nil)
+	self := L.GetGlobal("app").(*lua.LTable)
+	tests := map[string]string{
+		"title":             "Groceries",
+		"items[1].name":     "eggs",
+		"items[index].name": "eggs",
+		"count()":           "2",
+		"items[5]":          "",
+	}
+	for path, want := range tests {
+		value, err := r.resolve(self, path)
+		if err != nil || snapshotText(value) != want {
+			t.Errorf("%s: got %q, %v; want %q", path, snapshotText(value), err, want)
+		}
+	}
+	for _, path := range []string{"title.length.x", "find(1)", "title()", "items[x]", "count("} {
+		if _, err := r.resolve(self, path); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
+
+// TestSnapshotViewdefFiles tests that linked viewdefs win over app copies
+func TestSnapshotViewdefFiles(t *testing.T) {
+	baseDir := t.TempDir()
+	os.MkdirAll(filepath.Join(baseDir, "viewdefs"), 0755)
+	os.MkdirAll(filepath.Join(baseDir, "apps", "todo", "viewdefs"), 0755)
+	os.WriteFile(filepath.Join(baseDir, "viewdefs", "Todo.DEFAULT.html"), []byte("linked"), 0644)
+	os.WriteFile(filepath.Join(baseDir, "apps", "todo", "viewdefs", "Todo.DEFAULT.html"), []byte("app"), 0644)
+	os.WriteFile(filepath.Join(baseDir, "apps", "todo", "viewdefs", "Item.list-item.html"), []byte("item"), 0644)
+
+	viewdefs := loadSnapshotViewdefs(baseDir)
+	if viewdefs["Todo.DEFAULT"] != "linked" || viewdefs["Item.list-item"] != "item" || len(viewdefs) != 2 {
+		t.Errorf("unexpected viewdefs %v", viewdefs)
+	}
+}
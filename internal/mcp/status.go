@@ -0,0 +1,170 @@
+package mcp
+
+// CRC: crc-MCPServer.md
+// Server status: one Status value served by ui_status, /api/ui_status and mcp:status()
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/zot/frictionless/internal/publisher"
+	"github.com/zot/ui-engine/cli"
+)
+
+// Status is the server's health: configuration, sessions, the publisher and Go runtime usage
+type Status struct {
+	Version       string          `json:"version,omitempty"`  // Bundled version from README.md
+	BaseDir       string          `json:"base_dir,omitempty"` // Set once configured
+	Running       bool            `json:"running"`
+	URL           string          `json:"url,omitempty"`      // Running only
+	MCPPort       int             `json:"mcp_port,omitempty"` // Running only
+	Sessions      int             `json:"sessions"`           // Sessions in the UI engine
+	UptimeSeconds float64         `json:"uptime_seconds"`     // Since the process created the server
+	MCPSessions   []SessionStatus `json:"mcp_sessions"`       // Sessions the MCP server drives
+	Publisher     PublisherStatus `json:"publisher"`
+	Runtime       RuntimeStatus   `json:"runtime"`
+}
+
+// SessionStatus describes one MCP session
+type SessionStatus struct {
+	VendedID       string   `json:"vended_id"`
+	InternalID     string   `json:"internal_id,omitempty"`
+	Current        bool     `json:"current"`            // The session tools use by default
+	App            string   `json:"app,omitempty"`      // Last app shown with mcp:display
+	Apps           []string `json:"apps"`               // Apps loaded with mcp:app or mcp:display
+	QueuedEvents   int      `json:"queued_events"`      // mcp.pushState events not yet read
+	PollingClients int      `json:"polling_clients"`    // Clients waiting on /wait
+	Browsers       int      `json:"connected_browsers"` // Browsers holding a /browser-presence stream
+	WaitTime       float64  `json:"wait_time"`          // Seconds since the agent last responded, 0 while polling
+	Subscriptions  []string `json:"subscriptions"`      // Publisher topics from mcp:subscribe
+}
+
+// PublisherStatus tells whether this process hosts the publisher and, if so, its topics
+type PublisherStatus struct {
+	Addr   string         `json:"addr"`
+	Hosted bool           `json:"hosted"`           // False when another instance holds the port
+	Topics map[string]int `json:"topics,omitempty"` // Hosted only: topic -> waiting subscribers
+}
+
+// RuntimeStatus is the Go runtime's resource usage
+type RuntimeStatus struct {
+	GoVersion      string `json:"go_version"`
+	Goroutines     int    `json:"goroutines"`
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	SysBytes       uint64 `json:"sys_bytes"`
+	NumGC          uint32 `json:"num_gc"`
+}
+
+// Status gathers the current status. It reads only Go-side state, so it answers while a
+// session's executor is busy and can be called from Lua (mcp:status) without deadlocking.
+// Spec: mcp.md Section 5.4
+func (s *Server) Status() Status {
+	s.mu.RLock()
+	status := Status{
+		BaseDir:       s.baseDir,
+		Running:       s.state == Running,
+		UptimeSeconds: time.Since(s.started).Seconds(),
+		MCPSessions:   []SessionStatus{},
+		Publisher:     PublisherStatus{Addr: publisher.DefaultAddr},
+	}
+	if status.Running {
+		status.URL = s.url
+		status.MCPPort = s.mcpPort
+	}
+	current := s.currentVendedID
+	pub := s.publisher
+	s.mu.RUnlock()
+
+	status.Version = bundledVersion()
+	if status.Running && s.getSessionCount != nil {
+		status.Sessions = s.getSessionCount()
+	}
+	if current != "" {
+		status.MCPSessions = append(status.MCPSessions, s.sessionStatus(current, true))
+	}
+	if pub != nil && pub.Hosting() {
+		status.Publisher.Hosted = true
+		status.Publisher.Topics = make(map[string]int)
+		for name, stats := range pub.Stats() {
+			status.Publisher.Topics[name] = stats.Subscribers
+		}
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	status.Runtime = RuntimeStatus{
+		GoVersion:      runtime.Version(),
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: mem.HeapAlloc,
+		SysBytes:       mem.Sys,
+		NumGC:          mem.NumGC,
+	}
+	return status
+}
+
+// sessionStatus describes one vended session
+func (s *Server) sessionStatus(vendedID string, current bool) SessionStatus {
+	st := SessionStatus{
+		VendedID:      vendedID,
+		Current:       current,
+		Apps:          []string{},
+		Subscriptions: []string{},
+		WaitTime:      s.getWaitTime(vendedID),
+	}
+	if s.UiServer != nil {
+		if sessions := s.UiServer.GetSessions(); sessions != nil {
+			st.InternalID = sessions.GetInternalID(vendedID)
+		}
+	}
+
+	s.stateWaitersMu.Lock()
+	st.QueuedEvents = len(s.stateQueue[vendedID])
+	st.PollingClients = len(s.stateWaiters[vendedID])
+	s.stateWaitersMu.Unlock()
+
+	s.mu.RLock()
+	st.App = s.displayedApps[vendedID]
+	st.Browsers = s.browsers[vendedID]
+	st.Apps = append(st.Apps, s.loadedApps[vendedID]...)
+	st.Subscriptions = append(st.Subscriptions, s.subscriptions[vendedID]...)
+	s.mu.RUnlock()
+	sort.Strings(st.Apps)
+	return st
+}
+
+// noteApp records an app loaded (and, when shown, displayed) in a session
+func (s *Server) noteApp(vendedID, appName string, shown bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	for _, name := range s.loadedApps[vendedID] {
+		found = found || name == appName
+	}
+	if !found {
+		s.loadedApps[vendedID] = append(s.loadedApps[vendedID], appName)
+	}
+	if shown {
+		s.displayedApps[vendedID] = appName
+	}
+}
+
+// bundledVersion reads the version from the bundled README.md, or install/README.md when
+// running from a source tree
+// Spec: mcp.md Section 5.4 - version is always present
+func bundledVersion() string {
+	isBundled, _ := cli.IsBundled()
+	var content []byte
+	var err error
+	if isBundled {
+		content, err = cli.BundleReadFile("README.md")
+	} else {
+		content, err = os.ReadFile(filepath.Join("install", "README.md"))
+	}
+	if err != nil {
+		return ""
+	}
+	return parseReadmeVersion(content)
+}
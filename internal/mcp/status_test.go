@@ -0,0 +1,79 @@
+// Package mcp tests for the structured status
+// Test: test-Status.md
+package mcp
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/zot/ui-engine/cli"
+)
+
+// R216-R219: Status Tests
+// Test Design: test-Status.md
+
+// TestStatusSessions tests the per-session apps, queue, polling, browser and subscription counts
+func TestStatusSessions(t *testing.T) {
+	s := NewServer(cli.DefaultConfig(), nil, nil, nil, func() int { return 3 })
+	s.baseDir = "/tmp/ui"
+	s.currentVendedID = "1"
+	s.noteApp("1", "todo", false)
+	s.noteApp("1", "chat", true)
+	s.noteApp("1", "todo", true)
+	s.noteApp("2", "other", true)
+	s.stateQueue["1"] = []interface{}{"a", "b"}
+	s.stateWaiters["1"] = []chan struct{}{make(chan struct{})}
+	s.subscriptions["1"] = []string{"news"}
+	s.addBrowser("1", 1)
+	s.addBrowser("1", 1)
+	s.addBrowser("2", 1)
+
+	status := s.Status()
+	if status.Running || status.URL != "" || status.Sessions != 0 {
+		t.Errorf("expected no URL or browser sessions before starting, got %+v", status)
+	}
+	if status.BaseDir != "/tmp/ui" || status.Publisher.Hosted || status.Runtime.Goroutines == 0 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if len(status.MCPSessions) != 1 {
+		t.Fatalf("expected the current session only, got %+v", status.MCPSessions)
+	}
+	session := status.MCPSessions[0]
+	if !session.Current || session.App != "todo" || strings.Join(session.Apps, ",") != "chat,todo" {
+		t.Errorf("unexpected apps %+v", session)
+	}
+	if session.QueuedEvents != 2 || session.PollingClients != 1 || session.Browsers != 2 || session.WaitTime != 0 {
+		t.Errorf("unexpected queue %+v", session)
+	}
+	if strings.Join(session.Subscriptions, ",") != "news" {
+		t.Errorf("unexpected subscriptions %v", session.Subscriptions)
+	}
+
+	s.state = Running
+	s.url = "http://127.0.0.1:8000"
+	if status := s.Status(); status.Sessions != 3 || status.URL != s.url {
+		t.Errorf("expected the running URL and 3 engine sessions, got %+v", status)
+	}
+}
+
+// TestStatusJSON tests that an idle server reports empty lists rather than nulls
+func TestStatusJSON(t *testing.T) {
+	s := NewServer(cli.DefaultConfig(), nil, nil, nil, nil)
+	data, err := json.Marshal(s.Status())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, ok := fields["mcp_sessions"].([]interface{}); !ok || len(sessions) != 0 {
+		t.Errorf("expected an empty mcp_sessions list, got %v", fields["mcp_sessions"])
+	}
+	for _, key := range []string{"running", "sessions", "uptime_seconds", "publisher", "runtime"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("missing %s in %s", key, data)
+		}
+	}
+}
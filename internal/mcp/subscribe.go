@@ -41,6 +41,9 @@ func (s *Server) registerSubscribeMethod(vendedID string, mcpTable *lua.LTable)
 			}
 		}
 
+		s.mu.Lock()
+		s.subscriptions[vendedID] = append(s.subscriptions[vendedID], topic)
+		s.mu.Unlock()
 		go s.pollLoop(vendedID, topic, handler, favicon)
 		return 0
 	}))
@@ -63,6 +66,7 @@ func (s *Server) pollLoop(vendedID, topic string, handler *lua.LFunction, favico
 		nextURL = baseURL
 
 		if err != nil {
+			s.metrics.countPollError(topic, "connect")
 			time.Sleep(publisherRetry)
 			continue
 		}
@@ -83,25 +87,30 @@ func (s *Server) handlePollResponse(resp *http.Response, vendedID, topic string,
 		body, err := io.ReadAll(io.LimitReader(resp.Body, subscribeBufSize))
 		if err != nil {
 			log.Printf("subscribe %s: read error: %v", topic, err)
+			s.metrics.countPollError(topic, "read")
 			return
 		}
 
 		var data interface{}
 		if err := json.Unmarshal(body, &data); err != nil {
 			log.Printf("subscribe %s: JSON parse error: %v", topic, err)
+			s.metrics.countPollError(topic, "decode")
 			return
 		}
 
-		s.callHandler(vendedID, handler, data)
+		if err := s.callHandler(vendedID, handler, data); err != nil {
+			s.metrics.countPollError(topic, "handler")
+		}
 
 	default:
 		log.Printf("subscribe %s: unexpected status %d", topic, resp.StatusCode)
+		s.metrics.countPollError(topic, "status")
 		time.Sleep(publisherRetry)
 	}
 }
 
 // callHandler executes the Lua handler function in the session context with the parsed data.
-func (s *Server) callHandler(vendedID string, handler *lua.LFunction, data interface{}) {
+func (s *Server) callHandler(vendedID string, handler *lua.LFunction, data interface{}) error {
 	_, err := s.SafeExecuteInSession(vendedID, func() (interface{}, error) {
 		session := s.UiServer.GetLuaSession(vendedID)
 		if session == nil {
@@ -118,4 +127,5 @@ func (s *Server) callHandler(vendedID string, handler *lua.LFunction, data inter
 	if err != nil {
 		log.Printf("subscribe handler error: %v", err)
 	}
+	return err
 }
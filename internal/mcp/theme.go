@@ -11,15 +11,19 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"golang.org/x/net/html"
 )
 
 // ThemeFrontmatter represents theme metadata parsed from CSS comments
 type ThemeFrontmatter struct {
-	Name        string       `json:"name"`
-	Description string       `json:"description"`
-	Classes     []ThemeClass `json:"classes"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Pair        string            `json:"pair,omitempty"` // @pair: the theme's dark/light counterpart
+	Classes     []ThemeClass      `json:"classes"`
+	Variables   map[string]string `json:"variables,omitempty"` // --term-* declarations
 }
 
 // ThemeClass represents a semantic CSS class defined in a theme
@@ -32,8 +36,10 @@ type ThemeClass struct {
 
 // ThemeListResult is returned by the list action
 type ThemeListResult struct {
-	Themes  []ThemeInfo `json:"themes"`
-	Current string      `json:"current"`
+	Themes    []ThemeInfo       `json:"themes"`
+	Current   string            `json:"current"`
+	Mode      string            `json:"mode"`                 // "fixed" or "auto"
+	AppThemes map[string]string `json:"app_themes,omitempty"` // Per-app overrides
 }
 
 // ThemeInfo contains basic theme information
@@ -41,6 +47,8 @@ type ThemeInfo struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	AccentColor string `json:"accent_color,omitempty"`
+	Scheme      string `json:"scheme,omitempty"` // "dark" or "light", from --term-bg
+	Pair        string `json:"pair,omitempty"`   // Paired theme of the opposite scheme
 }
 
 // ThemeClassesResult is returned by the classes action
@@ -49,11 +57,12 @@ type ThemeClassesResult struct {
 	Classes []ThemeClass `json:"classes"`
 }
 
-// ClassUsage tracks where a CSS class is used
+// ClassUsage is one occurrence of a CSS class in an app
 type ClassUsage struct {
-	Class string `json:"class"`
-	File  string `json:"file"`
-	Line  int    `json:"line,omitempty"`
+	Class  string `json:"class"`
+	File   string `json:"file"`
+	Line   int    `json:"line,omitempty"`
+	Source string `json:"source,omitempty"` // "class", "ui-class" (ui-class-NAME binding) or "lua" (returned for ui-class)
 }
 
 // ThemeAuditSummary provides counts for theme auditing
@@ -69,6 +78,7 @@ type ThemeAuditResult struct {
 	Theme               string            `json:"theme"`
 	UndocumentedClasses []ClassUsage      `json:"undocumented_classes"`
 	UnusedThemeClasses  []string          `json:"unused_theme_classes"`
+	ContrastIssues      []ContrastIssue   `json:"contrast_issues"`
 	Summary             ThemeAuditSummary `json:"summary"`
 }
 
@@ -78,28 +88,24 @@ var (
 	themeNamePattern = regexp.MustCompile(`@theme\s+(\S+)`)
 	// Matches @description ... (until next @ or end of comment)
 	themeDescPattern = regexp.MustCompile(`@description\s+([^\n@]+)`)
+	// Matches @pair other-theme
+	themePairPattern = regexp.MustCompile(`@pair\s+(\S+)`)
 	// Matches @class blocks - stops at next @class or end of indented section
 	classBlockPattern = regexp.MustCompile(`(?m)@class\s+(\S+)\s*\n((?:\s+@(?:description|usage|elements)[^\n]*\n?)*)`)
 	// Matches individual class attributes
 	classDescPattern    = regexp.MustCompile(`@description\s+(.+)`)
 	classUsagePattern   = regexp.MustCompile(`@usage\s+(.+)`)
 	classElementPattern = regexp.MustCompile(`@elements\s+(.+)`)
-	// cssClassPattern matches class="..." or class='...' in HTML
-	cssClassPattern = regexp.MustCompile(`class=["']([^"']+)["']`)
-	// frictionlessBlockPattern matches the injected block in index.html
-	frictionlessBlockPattern = regexp.MustCompile(`(?s)<!--\s*#frictionless\s*-->.*?<!--\s*/frictionless\s*-->[\r\n]*`)
-	// accentColorPattern matches --term-accent: value in CSS
-	accentColorPattern = regexp.MustCompile(`--term-accent:\s*([^;]+);`)
 )
 
 // Default theme name
 const defaultThemeName = "lcars"
 
-// ParseThemeCSS extracts metadata from CSS comment block
+// ParseThemeCSS extracts metadata from CSS comment block and the theme's --term-* variables
 func ParseThemeCSS(content []byte) (*ThemeFrontmatter, error) {
 	text := string(content)
 
-	fm := &ThemeFrontmatter{}
+	fm := &ThemeFrontmatter{Variables: parseThemeVariables(text)}
 
 	// Extract theme name
 	if match := themeNamePattern.FindStringSubmatch(text); match != nil {
@@ -111,6 +117,11 @@ func ParseThemeCSS(content []byte) (*ThemeFrontmatter, error) {
 		fm.Description = strings.TrimSpace(match[1])
 	}
 
+	// Extract pair
+	if match := themePairPattern.FindStringSubmatch(text); match != nil {
+		fm.Pair = match[1]
+	}
+
 	// Extract class definitions
 	classMatches := classBlockPattern.FindAllStringSubmatch(text, -1)
 	for _, match := range classMatches {
@@ -173,9 +184,12 @@ func ListThemesWithInfo(baseDir string) (*ThemeListResult, error) {
 	}
 
 	result := &ThemeListResult{
-		Themes:  make([]ThemeInfo, 0, len(themes)),
-		Current: GetCurrentTheme(baseDir),
+		Themes:    make([]ThemeInfo, 0, len(themes)),
+		Current:   GetCurrentTheme(baseDir),
+		Mode:      GetThemeMode(baseDir),
+		AppThemes: GetAppThemes(baseDir),
 	}
+	pairs := ThemePairs(baseDir)
 
 	for _, theme := range themes {
 		fm, err := GetThemeClasses(baseDir, theme)
@@ -185,6 +199,8 @@ func ListThemesWithInfo(baseDir string) (*ThemeListResult, error) {
 		}
 		// Extract accent color from theme CSS
 		info.AccentColor = GetThemeAccentColor(baseDir, theme)
+		info.Scheme = themeScheme(baseDir, theme)
+		info.Pair = pairs[theme]
 		result.Themes = append(result.Themes, info)
 	}
 
@@ -198,10 +214,7 @@ func GetThemeAccentColor(baseDir, theme string) string {
 	if err != nil {
 		return ""
 	}
-	if match := accentColorPattern.FindSubmatch(content); match != nil {
-		return strings.TrimSpace(string(match[1]))
-	}
-	return ""
+	return parseThemeVariables(string(content))["--term-accent"]
 }
 
 // GetCurrentTheme reads the theme from storage/settings.json, falling back to the default
@@ -278,40 +291,141 @@ func ResolveThemeClasses(baseDir, theme string) (string, []ThemeClass, error) {
 	return theme, fm.Classes, nil
 }
 
-// InjectThemeBlock updates index.html with the frictionless theme block
+// Quiet period before the index.html watcher acts, so bursts of writes (ours, editors', build
+// tools') cause one injection
+const themeInjectDebounce = 250 * time.Millisecond
+
+// InjectThemeBlock updates index.html with the frictionless theme block. The file is only written
+// when its content would change, so repeated calls (and the watcher reacting to our own writes) are no-ops.
 func InjectThemeBlock(baseDir string) error {
+	_, err := updateThemeBlock(baseDir)
+	return err
+}
+
+// updateThemeBlock replaces any frictionless blocks in index.html with a fresh one right after the
+// <head> start tag. Returns whether the file was written.
+func updateThemeBlock(baseDir string) (bool, error) {
 	indexPath := filepath.Join(baseDir, "html", "index.html")
 
 	content, err := os.ReadFile(indexPath)
 	if err != nil {
-		return fmt.Errorf("reading index.html: %w", err)
+		return false, fmt.Errorf("reading index.html: %w", err)
 	}
 
 	themes, err := ListThemes(baseDir)
 	if err != nil {
-		return fmt.Errorf("listing themes: %w", err)
+		return false, fmt.Errorf("listing themes: %w", err)
 	}
 
 	block := GenerateThemeBlock(baseDir, themes, GetCurrentTheme(baseDir))
+	newHTML, err := spliceThemeBlock(string(content), block)
+	if err != nil {
+		return false, err
+	}
+	if newHTML == string(content) {
+		return false, nil
+	}
+	return true, os.WriteFile(indexPath, []byte(newHTML), 0644)
+}
 
-	// Remove existing frictionless block if present
-	html := frictionlessBlockPattern.ReplaceAllString(string(content), "")
+// spliceThemeBlock removes existing frictionless blocks from an HTML document and inserts block
+// after the <head> start tag (whatever its case or attributes), on its own lines. Everything else
+// is preserved byte for byte, so splicing the same block twice gives the same document.
+func spliceThemeBlock(content, block string) (string, error) {
+	headEnd, spans := themeBlockSpans(content)
+	if headEnd == -1 {
+		return "", fmt.Errorf("no <head> tag found in index.html")
+	}
 
-	// Find <head> tag (case-insensitive)
-	headIndex := strings.Index(strings.ToLower(html), "<head>")
-	if headIndex == -1 {
-		return fmt.Errorf("no <head> tag found in index.html")
+	// Cut the old blocks, tracking where the end of <head> moves to
+	var sb strings.Builder
+	prev, insertPos := 0, headEnd
+	for _, span := range spans {
+		sb.WriteString(content[prev:span[0]])
+		if span[0] < headEnd {
+			insertPos -= span[1] - span[0]
+		}
+		prev = span[1]
 	}
+	sb.WriteString(content[prev:])
+	doc := sb.String()
 
-	// Insert after <head> tag, preserving any trailing newline
-	insertPos := headIndex + len("<head>")
-	if insertPos < len(html) && html[insertPos] == '\n' {
+	// Insert after the <head> tag, preserving its trailing newline
+	if strings.HasPrefix(doc[insertPos:], "\r\n") {
+		insertPos += 2
+	} else if strings.HasPrefix(doc[insertPos:], "\n") {
 		insertPos++
+	} else {
+		block = "\n" + block
 	}
+	return doc[:insertPos] + block + doc[insertPos:], nil
+}
 
-	newHTML := html[:insertPos] + "\n" + block + html[insertPos:]
+// themeBlockSpans tokenizes an HTML document and returns the byte offset just past the <head> start
+// tag (-1 if there is none) and the [start, end) span of each frictionless block. A span runs from the
+// start of the opening marker's line (when only indentation precedes it) through the closing marker's
+// line ending. Markers inside scripts or attribute values aren't comments, so they aren't matched.
+func themeBlockSpans(content string) (int, [][2]int) {
+	headEnd := -1
+	var spans [][2]int
+	blockStart := -1
+
+	z := html.NewTokenizer(strings.NewReader(content))
+	offset := 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		rawLen := len(z.Raw())
+		switch tt {
+		case html.StartTagToken:
+			if name, _ := z.TagName(); headEnd == -1 && string(name) == "head" {
+				headEnd = offset + rawLen
+			}
+		case html.CommentToken:
+			switch strings.TrimSpace(string(z.Text())) {
+			case "#frictionless":
+				if blockStart == -1 {
+					blockStart = lineStart(content, offset)
+				}
+			case "/frictionless":
+				if blockStart != -1 {
+					spans = append(spans, [2]int{blockStart, lineEnd(content, offset+rawLen)})
+					blockStart = -1
+				}
+			}
+		}
+		offset += rawLen
+	}
+	return headEnd, spans
+}
+
+// lineStart backs up over spaces and tabs to the start of the line, if nothing else precedes offset on it
+func lineStart(content string, offset int) int {
+	i := offset
+	for i > 0 && (content[i-1] == ' ' || content[i-1] == '\t') {
+		i--
+	}
+	if i == 0 || content[i-1] == '\n' {
+		return i
+	}
+	return offset
+}
 
-	return os.WriteFile(indexPath, []byte(newHTML), 0644)
+// lineEnd advances over trailing spaces and one line ending, if nothing else follows offset on its line
+func lineEnd(content string, offset int) int {
+	i := offset
+	for i < len(content) && (content[i] == ' ' || content[i] == '\t') {
+		i++
+	}
+	if strings.HasPrefix(content[i:], "\r\n") {
+		return i + 2
+	}
+	if i == len(content) || content[i] == '\n' {
+		return min(i+1, len(content))
+	}
+	return offset
 }
 
 // HasThemeBlock checks if index.html already contains the frictionless theme block.
@@ -322,10 +436,13 @@ func HasThemeBlock(baseDir string) bool {
 	if err != nil {
 		return false
 	}
-	return frictionlessBlockPattern.Match(content)
+	_, spans := themeBlockSpans(string(content))
+	return len(spans) > 0
 }
 
-// WatchIndexHTML watches index.html for external writes and re-injects the theme block if missing.
+// WatchIndexHTML watches index.html and the theme CSS files. After writes settle, it re-injects the
+// theme block, which only rewrites index.html if the block is missing or stale (new themes, changed
+// cache-busting timestamps). Our own write settles to an unchanged file, so the watcher doesn't loop.
 // Seq: seq-theme-inject.md
 func WatchIndexHTML(baseDir string, logFn func(level int, format string, args ...interface{})) (func(), error) {
 	watcher, err := fsnotify.NewWatcher()
@@ -352,30 +469,48 @@ func WatchIndexHTML(baseDir string, logFn func(level int, format string, args ..
 	}
 
 	go func() {
+		var timer *time.Timer
+		var fire <-chan time.Time
+		reason := ""
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
 		for {
 			select {
 			case event, ok := <-watcher.Events:
 				if !ok {
 					return
 				}
-				if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
 					continue
 				}
-				if event.Name == indexPath {
-					// index.html changed — re-inject if theme block is missing
-					if HasThemeBlock(baseDir) {
+				switch {
+				case event.Name == indexPath:
+					if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
 						continue
 					}
-					logFn(2, "index.html changed without theme block, re-injecting")
-					if err := InjectThemeBlock(baseDir); err != nil {
-						logFn(0, "Warning: failed to re-inject theme block: %v", err)
-					}
-				} else if strings.HasSuffix(event.Name, ".css") && strings.HasPrefix(event.Name, themesDir) {
-					// Theme CSS changed — re-inject to update cache-busting timestamps
-					logFn(2, "theme CSS changed: %s, updating cache-busting", filepath.Base(event.Name))
-					if err := InjectThemeBlock(baseDir); err != nil {
-						logFn(0, "Warning: failed to re-inject theme block: %v", err)
-					}
+					reason = "index.html changed"
+				case strings.HasSuffix(event.Name, ".css") && strings.HasPrefix(event.Name, themesDir):
+					// Theme CSS added, changed or removed — the links and cache-busting timestamps follow
+					reason = "theme CSS changed: " + filepath.Base(event.Name)
+				default:
+					continue
+				}
+				if timer == nil {
+					timer = time.NewTimer(themeInjectDebounce)
+				} else {
+					timer.Reset(themeInjectDebounce)
+				}
+				fire = timer.C
+			case <-fire:
+				fire = nil
+				written, err := updateThemeBlock(baseDir)
+				if err != nil {
+					logFn(0, "Warning: failed to re-inject theme block: %v", err)
+				} else if written {
+					logFn(2, "%s, re-injected theme block", reason)
 				}
 			case err, ok := <-watcher.Errors:
 				if !ok {
@@ -395,9 +530,20 @@ func GenerateThemeBlock(baseDir string, themes []string, defaultTheme string) st
 
 	sb.WriteString("  <!-- #frictionless -->\n")
 
-	// Theme restore script - runs before CSS loads
+	// Theme restore script - runs before CSS loads. A session override (sessionStorage) beats the
+	// global choice (localStorage, or in auto mode the pair member matching prefers-color-scheme);
+	// per-app themes are pushed by the server once the app displays.
+	autoThemes := autoThemesJS(baseDir, defaultTheme)
+	restore := "localStorage.getItem('theme')"
+	if autoThemes != "null" {
+		restore = fmt.Sprintf("frictionlessSchemeTheme('%s')", defaultTheme)
+	}
 	sb.WriteString("  <script>\n")
-	sb.WriteString(fmt.Sprintf("    document.documentElement.className = 'theme-' + (localStorage.getItem('theme') || '%s');\n", defaultTheme))
+	sb.WriteString(fmt.Sprintf("    window.frictionlessAutoThemes = %s;\n", autoThemes))
+	sb.WriteString(themeSwitchScript)
+	sb.WriteString(fmt.Sprintf("    document.documentElement.className = 'theme-' + (sessionStorage.getItem('theme') || %s || '%s');\n", restore, defaultTheme))
+	// Presence stream: held open while the page is, so the server can count connected browsers
+	sb.WriteString("    if (window.EventSource) new EventSource('/browser-presence');\n")
 	sb.WriteString("  </script>\n")
 
 	themesDir := filepath.Join(baseDir, "html", "themes")
@@ -420,6 +566,40 @@ func GenerateThemeBlock(baseDir string, themes []string, defaultTheme string) st
 	return sb.String()
 }
 
+// themeSwitchScript defines frictionlessSetTheme(name, scope, version), which the server calls through
+// mcp.code. It loads the theme's CSS if needed (themes created after page load have no <link> yet) or
+// swaps in a newer version of it, switches the <html> class, and records the choice: "session" in
+// sessionStorage, "global" in localStorage. A "preview" is not recorded.
+// In auto mode (window.frictionlessAutoThemes set) global and app selections of either pair member
+// show the member matching prefers-color-scheme, and the page follows scheme changes.
+const themeSwitchScript = `    window.frictionlessSchemeTheme = function(name) {
+      var auto = window.frictionlessAutoThemes;
+      if (!auto || (name !== auto.dark && name !== auto.light)) return name;
+      return matchMedia('(prefers-color-scheme: dark)').matches ? auto.dark : auto.light;
+    };
+    window.frictionlessSetTheme = function(name, scope, version) {
+      if (scope === 'global' || scope === 'app') name = frictionlessSchemeTheme(name);
+      var href = '/themes/' + name + '.css';
+      var link = document.querySelector('link[href^="' + href + '"]');
+      if (!link) {
+        link = document.createElement('link');
+        link.rel = 'stylesheet';
+        document.head.appendChild(link);
+      }
+      if (link.getAttribute('href') !== href + (version || '')) link.href = href + (version || '');
+      document.documentElement.className = 'theme-' + name;
+      if (scope === 'preview') return;
+      if (scope === 'session') sessionStorage.setItem('theme', name); else sessionStorage.removeItem('theme');
+      if (scope === 'global') localStorage.setItem('theme', name);
+    };
+    matchMedia('(prefers-color-scheme: dark)').addEventListener('change', function() {
+      var auto = window.frictionlessAutoThemes, current = document.documentElement.className;
+      if (auto && (current === 'theme-' + auto.dark || current === 'theme-' + auto.light)) {
+        frictionlessSetTheme(frictionlessSchemeTheme(auto.dark), 'preview');
+      }
+    });
+`
+
 // cssModTime returns a cache-busting query string based on the file's modification time.
 // Returns empty string if the file cannot be stat'd.
 func cssModTime(path string) string {
@@ -445,7 +625,24 @@ func AuditAppTheme(baseDir, appName, theme string) (*ThemeAuditResult, error) {
 	if err != nil {
 		return nil, err
 	}
-	return AuditAppWithClasses(baseDir, appName, themeName, classes)
+	result, err := AuditAppWithClasses(baseDir, appName, themeName, classes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check color contrast for the audited theme, or every theme when auditing against all
+	themes := []string{theme}
+	if theme == "" {
+		if themes, err = ListThemes(baseDir); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range themes {
+		if vars, err := ThemeVariables(baseDir, name); err == nil {
+			result.ContrastIssues = append(result.ContrastIssues, CheckThemeContrast(name, vars)...)
+		}
+	}
+	return result, nil
 }
 
 // AuditAppWithClasses compares an app's CSS class usage against a provided class list.
@@ -456,8 +653,7 @@ func AuditAppWithClasses(baseDir, appName, themeName string, classes []ThemeClas
 		documentedClasses[c.Name] = true
 	}
 
-	viewdefsPath := filepath.Join(baseDir, "apps", appName, "viewdefs")
-	entries, err := os.ReadDir(viewdefsPath)
+	usages, err := collectClassUsages(baseDir, appName)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, fmt.Errorf("app %s has no viewdefs directory", appName)
@@ -465,40 +661,12 @@ func AuditAppWithClasses(baseDir, appName, themeName string, classes []ThemeClas
 		return nil, err
 	}
 
-	usedClasses := make(map[string]ClassUsage)
+	usedClasses := make(map[string]bool)
 	themeClassesUsed := make(map[string]bool)
-
-	for _, entry := range entries {
-		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".html") {
-			continue
-		}
-
-		content, err := os.ReadFile(filepath.Join(viewdefsPath, entry.Name()))
-		if err != nil {
-			continue
-		}
-
-		lines := strings.Split(string(content), "\n")
-		for lineNum, line := range lines {
-			for _, match := range cssClassPattern.FindAllStringSubmatch(line, -1) {
-				for _, class := range strings.Fields(match[1]) {
-					if isSkippedClass(class) {
-						continue
-					}
-
-					if _, seen := usedClasses[class]; !seen {
-						usedClasses[class] = ClassUsage{
-							Class: class,
-							File:  entry.Name(),
-							Line:  lineNum + 1,
-						}
-					}
-
-					if documentedClasses[class] {
-						themeClassesUsed[class] = true
-					}
-				}
-			}
+	for _, usage := range usages {
+		usedClasses[usage.Class] = true
+		if documentedClasses[usage.Class] {
+			themeClassesUsed[usage.Class] = true
 		}
 	}
 
@@ -507,12 +675,15 @@ func AuditAppWithClasses(baseDir, appName, themeName string, classes []ThemeClas
 		Theme:               themeName,
 		UndocumentedClasses: make([]ClassUsage, 0),
 		UnusedThemeClasses:  make([]string, 0),
+		ContrastIssues:      make([]ContrastIssue, 0),
 	}
 
-	// Find undocumented classes (used but not in theme)
-	for class, usage := range usedClasses {
-		if !documentedClasses[class] {
+	// Find undocumented classes (used but not in theme), every occurrence
+	undocumented := make(map[string]bool)
+	for _, usage := range usages {
+		if !documentedClasses[usage.Class] {
 			result.UndocumentedClasses = append(result.UndocumentedClasses, usage)
+			undocumented[usage.Class] = true
 		}
 	}
 
@@ -523,14 +694,11 @@ func AuditAppWithClasses(baseDir, appName, themeName string, classes []ThemeClas
 		}
 	}
 
-	// Sort results for deterministic output
-	sort.Slice(result.UndocumentedClasses, func(i, j int) bool {
-		return result.UndocumentedClasses[i].Class < result.UndocumentedClasses[j].Class
-	})
+	// Sort results for deterministic output (usages are already sorted by class, file, line)
 	sort.Strings(result.UnusedThemeClasses)
 
 	result.Summary.Total = len(usedClasses)
-	result.Summary.Undocumented = len(result.UndocumentedClasses)
+	result.Summary.Undocumented = len(undocumented)
 	result.Summary.Documented = result.Summary.Total - result.Summary.Undocumented
 
 	return result, nil
@@ -0,0 +1,192 @@
+package mcp
+
+// CRC: crc-ThemeManager.md | Seq: seq-theme-audit.md
+// Theme color contrast: resolves --term-* variables and checks WCAG contrast ratios
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ContrastIssue is a theme color pair whose contrast ratio is below the required minimum
+type ContrastIssue struct {
+	Theme      string  `json:"theme"`
+	Foreground string  `json:"foreground"`
+	Background string  `json:"background"`
+	Ratio      float64 `json:"ratio"`
+	Minimum    float64 `json:"minimum"`
+}
+
+// Minimum contrast ratios (WCAG 2.1 AA)
+const (
+	contrastText = 4.5 // Body text
+	contrastUI   = 3.0 // Large text, icons, component boundaries
+)
+
+// contrastPairs lists the foreground/background variable pairs the base stylesheet renders together
+var contrastPairs = []struct {
+	fg, bg  string
+	minimum float64
+}{
+	{"--term-text", "--term-bg", contrastText},
+	{"--term-text", "--term-bg-elevated", contrastText},
+	{"--term-text", "--term-bg-panel", contrastText},
+	{"--term-text", "--term-bg-hover", contrastText},
+	{"--term-text-dim", "--term-bg", contrastText},
+	{"--term-text-muted", "--term-bg", contrastUI},
+	{"--term-accent", "--term-bg", contrastUI},
+	{"--term-bg", "--term-accent", contrastText}, // Primary buttons: bg-colored text on accent
+	{"--term-success", "--term-bg", contrastUI},
+	{"--term-warning", "--term-bg", contrastUI},
+	{"--term-danger", "--term-bg", contrastUI},
+	{"--term-error", "--term-bg", contrastUI},
+	{"--term-info", "--term-bg", contrastUI},
+}
+
+var (
+	// Matches: --term-name: value;
+	// Captures: variable name, value
+	cssVariablePattern = regexp.MustCompile(`(--term-[\w-]+)\s*:\s*([^;}]+)`)
+
+	// Matches: var(--name) or var(--name, fallback)
+	// Captures: variable name
+	cssVarRefPattern = regexp.MustCompile(`^var\(\s*(--[\w-]+)\s*(?:,[^)]*)?\)$`)
+
+	// Matches: rgb(r, g, b) or rgba(r, g, b, a), comma or space separated
+	// Captures: r, g, b, optional alpha
+	cssRGBPattern = regexp.MustCompile(`^rgba?\(\s*([\d.]+)[\s,]+([\d.]+)[\s,]+([\d.]+)(?:\s*[,/]\s*([\d.]+%?))?\s*\)$`)
+)
+
+// parseThemeVariables extracts --term-* declarations from CSS. Later declarations win.
+func parseThemeVariables(text string) map[string]string {
+	vars := make(map[string]string)
+	for _, match := range cssVariablePattern.FindAllStringSubmatch(text, -1) {
+		vars[match[1]] = strings.TrimSpace(match[2])
+	}
+	return vars
+}
+
+// ThemeVariables returns a theme's effective --term-* variables: base.css defaults overridden by the theme
+func ThemeVariables(baseDir, theme string) (map[string]string, error) {
+	themesDir := filepath.Join(baseDir, "html", "themes")
+	vars := make(map[string]string)
+	if base, err := os.ReadFile(filepath.Join(themesDir, "base.css")); err == nil {
+		vars = parseThemeVariables(string(base))
+	}
+	content, err := os.ReadFile(filepath.Join(themesDir, theme+".css"))
+	if err != nil {
+		return nil, fmt.Errorf("reading theme file: %w", err)
+	}
+	fm, err := ParseThemeCSS(content)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range fm.Variables {
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// CheckThemeContrast checks the standard foreground/background pairs of a theme.
+// Pairs with a missing or non-opaque color are skipped.
+func CheckThemeContrast(theme string, vars map[string]string) []ContrastIssue {
+	var issues []ContrastIssue
+	for _, pair := range contrastPairs {
+		fg, ok := resolveColor(vars, pair.fg)
+		if !ok {
+			continue
+		}
+		bg, ok := resolveColor(vars, pair.bg)
+		if !ok {
+			continue
+		}
+		ratio := contrastRatio(fg, bg)
+		if ratio < pair.minimum {
+			issues = append(issues, ContrastIssue{
+				Theme:      theme,
+				Foreground: pair.fg,
+				Background: pair.bg,
+				Ratio:      math.Round(ratio*100) / 100,
+				Minimum:    pair.minimum,
+			})
+		}
+	}
+	return issues
+}
+
+// rgbColor is an opaque sRGB color with 0-255 channels
+type rgbColor struct{ r, g, b float64 }
+
+// resolveColor follows var() references and parses the resulting color
+func resolveColor(vars map[string]string, name string) (rgbColor, bool) {
+	value, ok := vars[name]
+	for depth := 0; ok && depth < 8; depth++ {
+		match := cssVarRefPattern.FindStringSubmatch(value)
+		if match == nil {
+			return parseCSSColor(value)
+		}
+		value, ok = vars[match[1]]
+	}
+	return rgbColor{}, false
+}
+
+// parseCSSColor parses #rgb, #rrggbb, #rrggbbaa, rgb() and rgba(). Translucent colors are rejected.
+func parseCSSColor(value string) (rgbColor, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if strings.HasPrefix(value, "#") {
+		hex := value[1:]
+		switch len(hex) {
+		case 3:
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		case 8:
+			if hex[6:] != "ff" {
+				return rgbColor{}, false
+			}
+			hex = hex[:6]
+		case 6:
+		default:
+			return rgbColor{}, false
+		}
+		n, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return rgbColor{}, false
+		}
+		return rgbColor{float64(n >> 16 & 0xff), float64(n >> 8 & 0xff), float64(n & 0xff)}, true
+	}
+	if match := cssRGBPattern.FindStringSubmatch(value); match != nil {
+		if alpha := match[4]; alpha != "" && alpha != "1" && alpha != "100%" {
+			return rgbColor{}, false
+		}
+		r, _ := strconv.ParseFloat(match[1], 64)
+		g, _ := strconv.ParseFloat(match[2], 64)
+		b, _ := strconv.ParseFloat(match[3], 64)
+		return rgbColor{r, g, b}, true
+	}
+	return rgbColor{}, false
+}
+
+// relativeLuminance computes WCAG relative luminance
+func (c rgbColor) relativeLuminance() float64 {
+	channel := func(v float64) float64 {
+		v /= 255
+		if v <= 0.03928 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	return 0.2126*channel(c.r) + 0.7152*channel(c.g) + 0.0722*channel(c.b)
+}
+
+// contrastRatio computes the WCAG contrast ratio between two colors (1 to 21)
+func contrastRatio(a, b rgbColor) float64 {
+	la, lb := a.relativeLuminance(), b.relativeLuminance()
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
@@ -0,0 +1,278 @@
+package mcp
+
+// CRC: crc-ThemeManager.md | Seq: seq-theme-new.md
+// Theme generation: derives a full --term-* palette from a seed accent color
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Matches a valid theme name
+	themeFileNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+	// Matches the frontmatter comment block (the first comment containing @theme)
+	themeFrontmatterPattern = regexp.MustCompile(`(?s)/\*[^*]*?@theme.*?\*/\n*`)
+
+	// Matches a whole-line --term-* declaration
+	termDeclarationPattern = regexp.MustCompile(`(?m)^[ \t]*--term-[\w-]+\s*:[^;]*;[ \t]*\n?`)
+)
+
+// Status color hues (degrees) used for generated themes
+var statusHues = []struct {
+	name string
+	hue  float64
+}{
+	{"--term-success", 142},
+	{"--term-warning", 43},
+	{"--term-danger", 0},
+	{"--term-error", 0},
+	{"--term-info", 213},
+}
+
+// GenerateThemeCSS builds a theme named name from the CSS of an existing theme and a seed accent.
+// The style rules and @class docs come from the source theme; every --term-* variable is derived
+// from the accent so that the standard pairs meet the contrast minimums in CheckThemeContrast.
+func GenerateThemeCSS(name, from string, fromCSS []byte, baseVars map[string]string, accent string, light bool) (string, error) {
+	seed, ok := parseCSSColor(accent)
+	if !ok {
+		return "", fmt.Errorf("invalid accent color %q (use #rrggbb or rgb())", accent)
+	}
+	fm, err := ParseThemeCSS(fromCSS)
+	if err != nil {
+		return "", err
+	}
+
+	// Fonts come from the source theme (falling back to base.css)
+	fonts := make(map[string]string)
+	for k, v := range baseVars {
+		fonts[k] = v
+	}
+	for k, v := range fm.Variables {
+		fonts[k] = v
+	}
+
+	vars := derivePalette(seed, light)
+	vars["--term-mono"] = fonts["--term-mono"]
+	vars["--term-sans"] = fonts["--term-sans"]
+	if issues := CheckThemeContrast(name, vars); len(issues) > 0 {
+		return "", fmt.Errorf("generated palette fails contrast: %s on %s is %.2f:1", issues[0].Foreground, issues[0].Background, issues[0].Ratio)
+	}
+
+	var sb strings.Builder
+
+	// Frontmatter with the source theme's @class docs
+	mode := "dark"
+	if light {
+		mode = "light"
+	}
+	fmt.Fprintf(&sb, "/*\n@theme %s\n@description Generated %s theme from %s with accent %s\n", name, mode, from, accent)
+	for _, c := range fm.Classes {
+		fmt.Fprintf(&sb, "\n@class %s\n", c.Name)
+		if c.Description != "" {
+			fmt.Fprintf(&sb, "  @description %s\n", c.Description)
+		}
+		if c.Usage != "" {
+			fmt.Fprintf(&sb, "  @usage %s\n", c.Usage)
+		}
+		if len(c.Elements) > 0 {
+			fmt.Fprintf(&sb, "  @elements %s\n", strings.Join(c.Elements, ", "))
+		}
+	}
+	sb.WriteString("*/\n\n")
+
+	// Derived variables
+	fmt.Fprintf(&sb, "/* ========== Variables (generated from %s) ========== */\n.theme-%s {\n", accent, name)
+	for _, v := range ThemeVariableSchema {
+		fmt.Fprintf(&sb, "  %s: %s;\n", v.Name, vars[v.Name])
+	}
+	sb.WriteString("}\n\n")
+
+	// Source theme rules, renamed, without its variable declarations
+	body := themeFrontmatterPattern.ReplaceAllString(string(fromCSS), "")
+	body = termDeclarationPattern.ReplaceAllString(body, "")
+	renamePattern := regexp.MustCompile(`\.theme-` + regexp.QuoteMeta(from) + `([^\w-]|$)`)
+	body = renamePattern.ReplaceAllString(body, ".theme-"+name+"$1")
+	sb.WriteString(strings.TrimLeft(body, "\n"))
+
+	return sb.String(), nil
+}
+
+// GenerateTheme writes html/themes/{name}.css generated from theme `from` and a seed accent.
+// Returns the path written. Fails if the theme already exists.
+func GenerateTheme(baseDir, name, from, accent string, light bool) (string, error) {
+	if !themeFileNamePattern.MatchString(name) || name == "base" {
+		return "", fmt.Errorf("invalid theme name %q (use lowercase letters, digits and dashes)", name)
+	}
+	themesDir := filepath.Join(baseDir, "html", "themes")
+	path := filepath.Join(themesDir, name+".css")
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("theme %s already exists", name)
+	}
+
+	fromCSS, err := os.ReadFile(filepath.Join(themesDir, from+".css"))
+	if err != nil {
+		return "", fmt.Errorf("reading theme %s: %w", from, err)
+	}
+	baseVars := map[string]string{}
+	if base, err := os.ReadFile(filepath.Join(themesDir, "base.css")); err == nil {
+		baseVars = parseThemeVariables(string(base))
+	}
+
+	css, err := GenerateThemeCSS(name, from, fromCSS, baseVars, accent, light)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(css), 0644); err != nil {
+		return "", fmt.Errorf("writing theme: %w", err)
+	}
+	return path, nil
+}
+
+// derivePalette computes every color variable from a seed accent
+func derivePalette(seed rgbColor, light bool) map[string]string {
+	hue, sat, _ := seed.toHSL()
+	tint := math.Min(sat, 0.25) // Neutrals carry a hint of the accent hue
+
+	// Neutral lightness ladder: bg, panel, elevated, hover, border, text, dim, muted
+	ladder := []float64{0.06, 0.08, 0.10, 0.13, 0.20, 0.90, 0.70, 0.58}
+	if light {
+		ladder = []float64{0.98, 0.96, 1.00, 0.92, 0.84, 0.12, 0.32, 0.42}
+	}
+	neutral := func(l float64, s float64) rgbColor { return hslColor(hue, s, l) }
+
+	bg := neutral(ladder[0], tint*0.6)
+	vars := map[string]string{
+		"--term-bg":          bg.hex(),
+		"--term-bg-panel":    neutral(ladder[1], tint*0.6).hex(),
+		"--term-bg-elevated": neutral(ladder[2], tint*0.6).hex(),
+		"--term-bg-hover":    neutral(ladder[3], tint*0.6).hex(),
+		"--term-border":      neutral(ladder[4], tint*0.5).hex(),
+	}
+
+	// Text must clear every background it's drawn on
+	surfaces := []rgbColor{bg}
+	for _, name := range []string{"--term-bg-panel", "--term-bg-elevated", "--term-bg-hover"} {
+		c, _ := parseCSSColor(vars[name])
+		surfaces = append(surfaces, c)
+	}
+	vars["--term-text"] = ensureContrast(neutral(ladder[5], tint*0.3), surfaces, contrastText, light).hex()
+	vars["--term-text-dim"] = ensureContrast(neutral(ladder[6], tint*0.3), []rgbColor{bg}, contrastText, light).hex()
+	vars["--term-text-muted"] = ensureContrast(neutral(ladder[7], tint*0.3), []rgbColor{bg}, contrastUI, light).hex()
+
+	// Accent doubles as primary button background under bg-colored text
+	accent := ensureContrast(seed, []rgbColor{bg}, contrastText, light)
+	bright := accent.adjustLightness(0.10)
+	if light {
+		bright = accent.adjustLightness(-0.08)
+	}
+	vars["--term-accent"] = accent.hex()
+	vars["--term-accent-bright"] = bright.hex()
+	vars["--term-accent-glow"] = accent.rgba(0.4)
+	vars["--term-accent-dim"] = accent.rgba(0.2)
+
+	for _, status := range statusHues {
+		start := 0.62
+		if light {
+			start = 0.38
+		}
+		c := ensureContrast(hslColor(status.hue, 0.75, start), []rgbColor{bg}, contrastUI, light)
+		vars[status.name] = c.hex()
+		if status.name != "--term-info" {
+			vars[status.name+"-dim"] = c.rgba(0.15)
+		}
+	}
+	return vars
+}
+
+// ensureContrast moves a color's lightness away from the backgrounds (darker for light themes,
+// lighter for dark ones) until it reaches the minimum ratio against all of them
+func ensureContrast(c rgbColor, backgrounds []rgbColor, minimum float64, light bool) rgbColor {
+	step := 0.01
+	if light {
+		step = -0.01
+	}
+	for i := 0; i < 100; i++ {
+		ok := true
+		for _, bg := range backgrounds {
+			if contrastRatio(c, bg) < minimum {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return c
+		}
+		c = c.adjustLightness(step)
+	}
+	return c
+}
+
+// toHSL converts to hue (degrees), saturation and lightness (0-1)
+func (c rgbColor) toHSL() (h, s, l float64) {
+	r, g, b := c.r/255, c.g/255, c.b/255
+	maxC := math.Max(r, math.Max(g, b))
+	minC := math.Min(r, math.Min(g, b))
+	l = (maxC + minC) / 2
+	if maxC == minC {
+		return 0, 0, l
+	}
+	d := maxC - minC
+	if l > 0.5 {
+		s = d / (2 - maxC - minC)
+	} else {
+		s = d / (maxC + minC)
+	}
+	switch maxC {
+	case r:
+		h = math.Mod((g-b)/d+6, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	return h * 60, s, l
+}
+
+// hslColor converts hue (degrees), saturation and lightness (0-1) to RGB
+func hslColor(h, s, l float64) rgbColor {
+	l = math.Max(0, math.Min(1, l))
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return rgbColor{math.Round((r + m) * 255), math.Round((g + m) * 255), math.Round((b + m) * 255)}
+}
+
+// adjustLightness shifts lightness by delta, clamped to 0-1
+func (c rgbColor) adjustLightness(delta float64) rgbColor {
+	h, s, l := c.toHSL()
+	return hslColor(h, s, l+delta)
+}
+
+func (c rgbColor) hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", int(c.r), int(c.g), int(c.b))
+}
+
+func (c rgbColor) rgba(alpha float64) string {
+	return fmt.Sprintf("rgba(%d, %d, %d, %g)", int(c.r), int(c.g), int(c.b), alpha)
+}
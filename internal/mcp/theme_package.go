@@ -0,0 +1,391 @@
+package mcp
+
+// CRC: crc-ThemeManager.md | Seq: seq-theme-package.md
+// Theme packages: install and export a theme CSS file with its fonts and images as one archive
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/zot/ui-engine/cli"
+)
+
+// Theme package limits
+const (
+	maxThemeFileSize    = 10 << 20 // Largest single file in a package
+	maxThemePackageSize = 50 << 20 // Largest total uncompressed size
+)
+
+// Asset types a theme package may carry besides its CSS, under NAME/
+var themeAssetExtensions = map[string]bool{
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true, ".avif": true, ".ico": true,
+	".txt": true, ".md": true, // License and credits
+}
+
+// Matches: url(...) in CSS
+// Captures: the referenced path
+var cssURLPattern = regexp.MustCompile(`url\(\s*['"]?([^'")]+?)['"]?\s*\)`)
+
+// ThemeInstallResult is returned by InstallThemePackage
+type ThemeInstallResult struct {
+	Theme    string   `json:"theme"`
+	Files    []string `json:"files"`    // Paths relative to the base dir
+	Replaced bool     `json:"replaced"` // An earlier install of the theme was replaced
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ThemeExportResult is returned by ExportTheme
+type ThemeExportResult struct {
+	Theme    string   `json:"theme"`
+	Path     string   `json:"path"`
+	Files    []string `json:"files"` // Paths inside the archive
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// InstallThemePackage installs a theme from a zip archive or directory laid out like html/themes:
+// one NAME.css at the top, with fonts and images under NAME/. The CSS must parse with ParseThemeCSS
+// and declare @theme NAME. The theme is recorded in the install manifest as user-installed, so
+// install and update never overwrite it. Bundled themes can't be replaced; a theme that exists but
+// wasn't installed this way is only replaced with force.
+func InstallThemePackage(baseDir, src string, force bool) (*ThemeInstallResult, error) {
+	var files map[string][]byte
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, fmt.Errorf("reading theme package: %w", err)
+	}
+	if info.IsDir() {
+		files, err = readThemeDir(src)
+	} else {
+		files, err = readThemeZip(src)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	name, css, err := themePackageCSS(files)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, _ := readManifest(baseDir)
+	if manifest == nil {
+		manifest = &InstallManifest{Files: make(map[string]string)}
+	}
+	if isBundledTheme(manifest, name) {
+		return nil, fmt.Errorf("theme %s is bundled and can't be replaced; rename the theme", name)
+	}
+	_, recorded := manifest.UserThemes[name]
+	exists := themeExists(baseDir, name)
+	if exists && !recorded && !force {
+		return nil, fmt.Errorf("theme %s already exists (use --force to replace it)", name)
+	}
+
+	// Drop the files of the earlier install so removed assets don't linger
+	for _, rel := range manifest.UserThemes[name] {
+		os.Remove(filepath.Join(baseDir, rel))
+	}
+	if recorded {
+		os.RemoveAll(filepath.Join(baseDir, "html", "themes", name))
+	}
+
+	result := &ThemeInstallResult{Theme: name, Replaced: exists, Warnings: missingThemeAssets(name, css, files)}
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		rel := filepath.Join("html", "themes", filepath.FromSlash(p))
+		dest := filepath.Join(baseDir, rel)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return nil, fmt.Errorf("creating theme directory: %w", err)
+		}
+		if err := os.WriteFile(dest, files[p], 0644); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", rel, err)
+		}
+		result.Files = append(result.Files, rel)
+	}
+
+	if manifest.UserThemes == nil {
+		manifest.UserThemes = make(map[string][]string)
+	}
+	manifest.UserThemes[name] = result.Files
+	if err := writeManifest(baseDir, manifest); err != nil {
+		return nil, fmt.Errorf("writing install manifest: %w", err)
+	}
+	return result, nil
+}
+
+// isBundledTheme reports whether name ships with frictionless, by the install manifest or, when
+// there is no manifest (an older install or a source tree), by the binary's bundle
+func isBundledTheme(manifest *InstallManifest, name string) bool {
+	cssRel := filepath.Join("html", "themes", name+".css")
+	if _, ok := manifest.Files[cssRel]; ok {
+		return true
+	}
+	if bundled, _ := cli.IsBundled(); bundled {
+		files, _ := cli.BundleListFiles("html/themes")
+		for _, file := range files {
+			if path.Base(file) == name+".css" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ExportTheme writes html/themes/NAME.css and everything under html/themes/NAME/ to a zip archive
+// that InstallThemePackage accepts. dest defaults to NAME.zip in the current directory.
+func ExportTheme(baseDir, name, dest string) (*ThemeExportResult, error) {
+	if !themeExists(baseDir, name) {
+		return nil, fmt.Errorf("theme %s not found", name)
+	}
+	themesDir := filepath.Join(baseDir, "html", "themes")
+	files := make(map[string][]byte)
+	css, err := os.ReadFile(filepath.Join(themesDir, name+".css"))
+	if err != nil {
+		return nil, fmt.Errorf("reading theme file: %w", err)
+	}
+	files[name+".css"] = css
+	assetDir := filepath.Join(themesDir, name)
+	err = filepath.WalkDir(assetDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(themesDir, p)
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading theme assets: %w", err)
+	}
+
+	// Check the package the same way install will
+	if _, _, err := themePackageCSS(files); err != nil {
+		return nil, err
+	}
+
+	if dest == "" {
+		dest = name + ".zip"
+	}
+	out, err := os.Create(dest)
+	if err != nil {
+		return nil, fmt.Errorf("creating archive: %w", err)
+	}
+	defer out.Close()
+
+	result := &ThemeExportResult{Theme: name, Path: dest, Warnings: missingThemeAssets(name, string(css), files)}
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	zw := zip.NewWriter(out)
+	for _, p := range paths {
+		w, err := zw.Create(p)
+		if err != nil {
+			return nil, fmt.Errorf("writing archive: %w", err)
+		}
+		if _, err := w.Write(files[p]); err != nil {
+			return nil, fmt.Errorf("writing archive: %w", err)
+		}
+		result.Files = append(result.Files, p)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("writing archive: %w", err)
+	}
+	return result, nil
+}
+
+// UserThemes returns the names of themes installed with InstallThemePackage
+func UserThemes(baseDir string) []string {
+	manifest, _ := readManifest(baseDir)
+	if manifest == nil {
+		return nil
+	}
+	names := make([]string, 0, len(manifest.UserThemes))
+	for name := range manifest.UserThemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isUserThemeFile reports whether relPath belongs to a user-installed theme
+func (m *InstallManifest) isUserThemeFile(relPath string) bool {
+	for _, files := range m.UserThemes {
+		for _, f := range files {
+			if f == relPath {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readThemeZip reads a theme archive into a map of slash-separated paths to contents
+func readThemeZip(src string) (map[string][]byte, error) {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return nil, fmt.Errorf("opening theme archive: %w", err)
+	}
+	defer zr.Close()
+
+	files := make(map[string][]byte)
+	total := int64(0)
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		p, err := cleanPackagePath(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ignoredPackagePath(p) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", f.Name, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxThemeFileSize+1))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", f.Name, err)
+		}
+		if total += int64(len(data)); len(data) > maxThemeFileSize || total > maxThemePackageSize {
+			return nil, fmt.Errorf("theme package too large (%s)", f.Name)
+		}
+		files[p] = data
+	}
+	return files, nil
+}
+
+// readThemeDir reads a theme directory into a map of slash-separated paths to contents
+func readThemeDir(src string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	total := int64(0)
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(src, p)
+		if ignoredPackagePath(filepath.ToSlash(rel)) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if total += info.Size(); info.Size() > maxThemeFileSize || total > maxThemePackageSize {
+			return fmt.Errorf("theme package too large (%s)", rel)
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading theme directory: %w", err)
+	}
+	return files, nil
+}
+
+// cleanPackagePath rejects archive paths that would escape the themes directory
+func cleanPackagePath(name string) (string, error) {
+	p := path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	if path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") || strings.Contains(p, ":") {
+		return "", fmt.Errorf("invalid path in theme package: %s", name)
+	}
+	return p, nil
+}
+
+// ignoredPackagePath reports whether a package path is archiver or OS clutter (dotfiles, __MACOSX)
+func ignoredPackagePath(p string) bool {
+	for _, segment := range strings.Split(p, "/") {
+		if strings.HasPrefix(segment, ".") || segment == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// themePackageCSS checks a package's layout and returns its theme name and CSS.
+// The package holds exactly one top-level NAME.css declaring @theme NAME; every other file is a
+// font or image under NAME/.
+func themePackageCSS(files map[string][]byte) (string, string, error) {
+	var cssPath string
+	for p := range files {
+		if !strings.Contains(p, "/") && strings.HasSuffix(p, ".css") {
+			if cssPath != "" {
+				return "", "", fmt.Errorf("theme package has more than one CSS file: %s, %s", cssPath, p)
+			}
+			cssPath = p
+		}
+	}
+	if cssPath == "" {
+		return "", "", fmt.Errorf("theme package has no top-level CSS file")
+	}
+
+	name := strings.TrimSuffix(cssPath, ".css")
+	if !themeFileNamePattern.MatchString(name) || name == "base" {
+		return "", "", fmt.Errorf("invalid theme name %q (use lowercase letters, digits and dashes)", name)
+	}
+	fm, err := ParseThemeCSS(files[cssPath])
+	if err != nil {
+		return "", "", fmt.Errorf("parsing %s: %w", cssPath, err)
+	}
+	if fm.Name != name {
+		return "", "", fmt.Errorf("%s declares @theme %q; it must match the file name", cssPath, fm.Name)
+	}
+
+	for p := range files {
+		if p == cssPath {
+			continue
+		}
+		if !strings.HasPrefix(p, name+"/") {
+			return "", "", fmt.Errorf("unexpected file %s (assets belong under %s/)", p, name)
+		}
+		if !themeAssetExtensions[strings.ToLower(path.Ext(p))] {
+			return "", "", fmt.Errorf("unsupported asset type: %s (fonts and images only)", p)
+		}
+	}
+	return name, string(files[cssPath]), nil
+}
+
+// missingThemeAssets warns about relative url() references the package doesn't carry.
+// References resolve against /themes/, so a package's own assets are NAME/...
+func missingThemeAssets(name, css string, files map[string][]byte) []string {
+	var warnings []string
+	seen := make(map[string]bool)
+	for _, match := range cssURLPattern.FindAllStringSubmatch(cssCommentPattern.ReplaceAllString(css, ""), -1) {
+		ref := match[1]
+		if i := strings.IndexAny(ref, "?#"); i != -1 {
+			ref = ref[:i]
+		}
+		if ref == "" || seen[ref] || strings.HasPrefix(ref, "data:") || strings.Contains(ref, "://") || strings.HasPrefix(ref, "/") {
+			continue
+		}
+		seen[ref] = true
+		p := path.Clean(ref)
+		if _, ok := files[p]; !ok || !strings.HasPrefix(p, name+"/") {
+			warnings = append(warnings, fmt.Sprintf("url(%s) is not in the package", match[1]))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,107 @@
+package mcp
+
+// CRC: crc-ThemeManager.md | Seq: seq-theme-inject.md
+// Dark/light theme pairs and the auto mode that follows prefers-color-scheme
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Theme modes in storage/settings.json "themeMode"
+const (
+	ThemeModeFixed = "fixed" // Always the selected theme
+	ThemeModeAuto  = "auto"  // The selected theme or its pair, following prefers-color-scheme
+)
+
+// GetThemeMode reads the theme mode from storage/settings.json, defaulting to fixed
+func GetThemeMode(baseDir string) string {
+	settings, err := readSettings(baseDir)
+	if err != nil {
+		return ThemeModeFixed
+	}
+	if mode, _ := settings["themeMode"].(string); mode == ThemeModeAuto {
+		return ThemeModeAuto
+	}
+	return ThemeModeFixed
+}
+
+// SaveThemeMode writes the theme mode to storage/settings.json
+func SaveThemeMode(baseDir, mode string) error {
+	if mode != ThemeModeFixed && mode != ThemeModeAuto {
+		return fmt.Errorf("unknown theme mode: %s (use: fixed, auto)", mode)
+	}
+	settings, err := readSettings(baseDir)
+	if err != nil {
+		return err
+	}
+	settings["themeMode"] = mode
+	return writeSettings(baseDir, settings)
+}
+
+// ThemePairs returns each paired theme's partner. A single @pair declaration pairs both themes;
+// pairs naming a missing theme are dropped.
+func ThemePairs(baseDir string) map[string]string {
+	pairs := make(map[string]string)
+	themes, err := ListThemes(baseDir)
+	if err != nil {
+		return pairs
+	}
+	for _, theme := range themes {
+		fm, err := GetThemeClasses(baseDir, theme)
+		if err != nil || fm.Pair == "" || fm.Pair == theme || !themeExists(baseDir, fm.Pair) {
+			continue
+		}
+		pairs[theme] = fm.Pair
+		if _, ok := pairs[fm.Pair]; !ok {
+			pairs[fm.Pair] = theme
+		}
+	}
+	return pairs
+}
+
+// themeScheme returns "light" or "dark" from the luminance of the theme's --term-bg, or "" if unknown
+func themeScheme(baseDir, theme string) string {
+	vars, err := ThemeVariables(baseDir, theme)
+	if err != nil {
+		return ""
+	}
+	bg, ok := resolveColor(vars, "--term-bg")
+	if !ok {
+		return ""
+	}
+	if bg.relativeLuminance() > 0.5 {
+		return "light"
+	}
+	return "dark"
+}
+
+// autoThemePair returns the dark and light members of theme's pair when auto mode is on
+// and the pair has one of each
+func autoThemePair(baseDir, theme string) (dark, light string, ok bool) {
+	if GetThemeMode(baseDir) != ThemeModeAuto {
+		return "", "", false
+	}
+	pair, ok := ThemePairs(baseDir)[theme]
+	if !ok {
+		return "", "", false
+	}
+	dark, light = theme, pair
+	if themeScheme(baseDir, theme) == "light" {
+		dark, light = pair, theme
+	}
+	if themeScheme(baseDir, dark) != "dark" || themeScheme(baseDir, light) != "light" {
+		return "", "", false
+	}
+	return dark, light, true
+}
+
+// autoThemesJS returns the value for window.frictionlessAutoThemes: {"dark":..,"light":..} or null
+func autoThemesJS(baseDir, theme string) string {
+	dark, light, ok := autoThemePair(baseDir, theme)
+	if !ok {
+		return "null"
+	}
+	data, _ := json.Marshal(map[string]string{"dark": dark, "light": light})
+	return string(data)
+}
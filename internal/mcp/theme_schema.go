@@ -0,0 +1,214 @@
+package mcp
+
+// CRC: crc-ThemeManager.md | Seq: seq-theme-validate.md
+// Theme variable schema and theme CSS validation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ThemeVariable describes a --term-* custom property that base.css expects every theme to define
+type ThemeVariable struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"` // "color" or "font"
+	Description string `json:"description"`
+}
+
+// ThemeVariableSchema is the required set of theme variables
+var ThemeVariableSchema = []ThemeVariable{
+	{"--term-bg", "color", "Page background"},
+	{"--term-bg-elevated", "color", "Raised surfaces: inputs, cards, menus"},
+	{"--term-bg-hover", "color", "Hover background for rows and buttons"},
+	{"--term-bg-panel", "color", "Panel background"},
+	{"--term-border", "color", "Borders and dividers"},
+	{"--term-text", "color", "Body text"},
+	{"--term-text-dim", "color", "Secondary text"},
+	{"--term-text-muted", "color", "Placeholders, disabled and incidental text"},
+	{"--term-accent", "color", "Primary accent: focus rings, primary buttons, links"},
+	{"--term-accent-glow", "color", "Focus and hover glow (usually translucent accent)"},
+	{"--term-accent-bright", "color", "Accent hover state"},
+	{"--term-accent-dim", "color", "Accent tint for selected backgrounds"},
+	{"--term-success", "color", "Success status"},
+	{"--term-success-dim", "color", "Success tint background"},
+	{"--term-warning", "color", "Warning status"},
+	{"--term-warning-dim", "color", "Warning tint background"},
+	{"--term-danger", "color", "Danger status and destructive actions"},
+	{"--term-danger-dim", "color", "Danger tint background"},
+	{"--term-error", "color", "Error messages"},
+	{"--term-error-dim", "color", "Error tint background"},
+	{"--term-info", "color", "Informational status"},
+	{"--term-mono", "font", "Monospace font stack"},
+	{"--term-sans", "font", "Sans-serif font stack"},
+}
+
+// ThemeValidationResult is returned by the validate action
+type ThemeValidationResult struct {
+	Theme               string            `json:"theme"`
+	Valid               bool              `json:"valid"`
+	MissingVariables    []string          `json:"missing_variables"`
+	ExtraVariables      []string          `json:"extra_variables"`
+	InvalidValues       []InvalidVariable `json:"invalid_values"`
+	UndocumentedClasses []string          `json:"undocumented_classes"`
+}
+
+// InvalidVariable is a theme variable whose value doesn't match its schema kind
+type InvalidVariable struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+var (
+	// Matches CSS comments
+	cssCommentPattern = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+	// Matches: .class-name in a selector
+	// Captures: class name
+	cssSelectorClassPattern = regexp.MustCompile(`\.(-?[a-zA-Z_][\w-]*)`)
+
+	// Matches hex, rgb(a)/hsl(a) function and var() color values
+	cssColorPattern = regexp.MustCompile(`^(?:#(?:[0-9a-f]{3,4}|[0-9a-f]{6}|[0-9a-f]{8})|(?:rgba?|hsla?)\([^()]*\)|var\(--[\w-]+(?:\s*,[^()]*)?\))$`)
+
+	// CSS named colors, plus transparent and currentcolor
+	cssNamedColors = func() map[string]bool {
+		names := make(map[string]bool)
+		for _, name := range strings.Fields(`transparent currentcolor
+			aliceblue antiquewhite aqua aquamarine azure beige bisque black blanchedalmond blue blueviolet brown
+			burlywood cadetblue chartreuse chocolate coral cornflowerblue cornsilk crimson cyan darkblue darkcyan
+			darkgoldenrod darkgray darkgreen darkgrey darkkhaki darkmagenta darkolivegreen darkorange darkorchid
+			darkred darksalmon darkseagreen darkslateblue darkslategray darkslategrey darkturquoise darkviolet
+			deeppink deepskyblue dimgray dimgrey dodgerblue firebrick floralwhite forestgreen fuchsia gainsboro
+			ghostwhite gold goldenrod gray green greenyellow grey honeydew hotpink indianred indigo ivory khaki
+			lavender lavenderblush lawngreen lemonchiffon lightblue lightcoral lightcyan lightgoldenrodyellow
+			lightgray lightgreen lightgrey lightpink lightsalmon lightseagreen lightskyblue lightslategray
+			lightslategrey lightsteelblue lightyellow lime limegreen linen magenta maroon mediumaquamarine
+			mediumblue mediumorchid mediumpurple mediumseagreen mediumslateblue mediumspringgreen
+			mediumturquoise mediumvioletred midnightblue mintcream mistyrose moccasin navajowhite navy oldlace
+			olive olivedrab orange orangered orchid palegoldenrod palegreen paleturquoise palevioletred
+			papayawhip peachpuff peru pink plum powderblue purple rebeccapurple red rosybrown royalblue
+			saddlebrown salmon sandybrown seagreen seashell sienna silver skyblue slateblue slategray slategrey
+			snow springgreen steelblue tan teal thistle tomato turquoise violet wheat white whitesmoke yellow
+			yellowgreen`) {
+			names[name] = true
+		}
+		return names
+	}()
+)
+
+// ValidateTheme validates a theme CSS file in the themes directory against the variable schema
+func ValidateTheme(baseDir, theme string) (*ThemeValidationResult, error) {
+	content, err := os.ReadFile(filepath.Join(baseDir, "html", "themes", theme+".css"))
+	if err != nil {
+		return nil, fmt.Errorf("reading theme file: %w", err)
+	}
+	return ValidateThemeCSS(theme, content)
+}
+
+// ValidateThemeCSS checks theme CSS for missing or extra --term-* variables, values that
+// don't match the variable's kind, and classes styled in CSS but missing @class docs.
+func ValidateThemeCSS(theme string, content []byte) (*ThemeValidationResult, error) {
+	fm, err := ParseThemeCSS(content)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ThemeValidationResult{
+		Theme:               theme,
+		MissingVariables:    []string{},
+		ExtraVariables:      []string{},
+		InvalidValues:       []InvalidVariable{},
+		UndocumentedClasses: []string{},
+	}
+
+	known := make(map[string]bool, len(ThemeVariableSchema))
+	for _, v := range ThemeVariableSchema {
+		known[v.Name] = true
+		value, ok := fm.Variables[v.Name]
+		if !ok {
+			result.MissingVariables = append(result.MissingVariables, v.Name)
+			continue
+		}
+		if !validThemeValue(v.Kind, value) {
+			result.InvalidValues = append(result.InvalidValues, InvalidVariable{Name: v.Name, Value: value})
+		}
+	}
+	for name := range fm.Variables {
+		if !known[name] {
+			result.ExtraVariables = append(result.ExtraVariables, name)
+		}
+	}
+	sort.Strings(result.ExtraVariables)
+
+	documented := make(map[string]bool, len(fm.Classes))
+	for _, c := range fm.Classes {
+		documented[c.Name] = true
+	}
+	for _, class := range themeSelectorClasses(string(content)) {
+		if !documented[class] && class != "theme-"+theme && !isSkippedClass(class) {
+			result.UndocumentedClasses = append(result.UndocumentedClasses, class)
+		}
+	}
+
+	result.Valid = len(result.MissingVariables) == 0 && len(result.ExtraVariables) == 0 &&
+		len(result.InvalidValues) == 0 && len(result.UndocumentedClasses) == 0
+	return result, nil
+}
+
+// validThemeValue checks a variable value against its schema kind
+func validThemeValue(kind, value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch kind {
+	case "color":
+		return cssColorPattern.MatchString(value) || cssNamedColors[value]
+	default:
+		return value != ""
+	}
+}
+
+// themeSelectorClasses returns the sorted, de-duplicated class names used in CSS selectors
+func themeSelectorClasses(text string) []string {
+	text = cssCommentPattern.ReplaceAllString(text, "")
+	seen := make(map[string]bool)
+	var classes []string
+	// Selector text is what precedes each "{", after the previous rule or statement ends.
+	// The last chunk follows the final "{" and holds only declarations.
+	chunks := strings.Split(text, "{")
+	for _, selector := range chunks[:len(chunks)-1] {
+		if i := strings.LastIndexAny(selector, "};"); i != -1 {
+			selector = selector[i+1:]
+		}
+		if strings.HasPrefix(strings.TrimSpace(selector), "@") {
+			continue // @media, @supports, @keyframes
+		}
+		selector = stripParenthesized(selector)
+		for _, match := range cssSelectorClassPattern.FindAllStringSubmatch(selector, -1) {
+			if !seen[match[1]] {
+				seen[match[1]] = true
+				classes = append(classes, match[1])
+			}
+		}
+	}
+	sort.Strings(classes)
+	return classes
+}
+
+// stripParenthesized removes attribute selectors and pseudo-class arguments, which may contain dots
+func stripParenthesized(selector string) string {
+	var sb strings.Builder
+	depth := 0
+	for _, r := range selector {
+		switch {
+		case r == '[' || r == '(':
+			depth++
+		case (r == ']' || r == ')') && depth > 0:
+			depth--
+		case depth == 0:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,326 @@
+package mcp
+
+// CRC: crc-ThemeManager.md | Seq: seq-theme-set.md
+// Theme selection: global theme, per-app overrides in settings.json, per-session overrides in memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Theme scopes for ui_theme set and mcp:setTheme
+const (
+	ThemeScopeGlobal  = "global"  // storage/settings.json "theme"
+	ThemeScopeApp     = "app"     // storage/settings.json "appThemes"
+	ThemeScopeSession = "session" // in memory, one browser session
+
+	themeScopePreview = "preview" // shown in browsers, not saved
+)
+
+// ThemeSetResult is returned by the set and preview actions
+type ThemeSetResult struct {
+	Scope     string `json:"scope"` // "preview" for the preview action
+	Theme     string `json:"theme"` // "" when an override was cleared
+	App       string `json:"app,omitempty"`
+	Effective string `json:"effective"` // Theme now shown in the session
+}
+
+// Matches an uppercase letter in a PascalCase type name
+var upperPattern = regexp.MustCompile(`[A-Z]`)
+
+// settingsPath returns the path of storage/settings.json
+func settingsPath(baseDir string) string {
+	return filepath.Join(baseDir, "storage", "settings.json")
+}
+
+// readSettings reads storage/settings.json as a generic map so keys owned by Lua apps survive rewrites
+func readSettings(baseDir string) (map[string]interface{}, error) {
+	settings := make(map[string]interface{})
+	data, err := os.ReadFile(settingsPath(baseDir))
+	if os.IsNotExist(err) {
+		return settings, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading settings: %w", err)
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return settings, nil
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("parsing settings: %w", err)
+	}
+	return settings, nil
+}
+
+// writeSettings writes storage/settings.json
+func writeSettings(baseDir string, settings map[string]interface{}) error {
+	path := settingsPath(baseDir)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating storage directory: %w", err)
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding settings: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// GetAppThemes returns the per-app theme overrides from storage/settings.json
+func GetAppThemes(baseDir string) map[string]string {
+	themes := make(map[string]string)
+	settings, err := readSettings(baseDir)
+	if err != nil {
+		return themes
+	}
+	if apps, ok := settings["appThemes"].(map[string]interface{}); ok {
+		for app, theme := range apps {
+			if name, ok := theme.(string); ok && name != "" {
+				themes[app] = name
+			}
+		}
+	}
+	return themes
+}
+
+// ResolveAppTheme returns an app's theme override, or the global theme if it has none
+func ResolveAppTheme(baseDir, app string) string {
+	if theme, ok := GetAppThemes(baseDir)[app]; ok && app != "" {
+		return theme
+	}
+	return GetCurrentTheme(baseDir)
+}
+
+// SaveThemeSetting stores the global theme (app == "") or an app's override in storage/settings.json.
+// An empty theme clears the app's override.
+func SaveThemeSetting(baseDir, app, theme string) error {
+	if theme != "" && !themeExists(baseDir, theme) {
+		return fmt.Errorf("theme %s not found", theme)
+	}
+	settings, err := readSettings(baseDir)
+	if err != nil {
+		return err
+	}
+	if app == "" {
+		if theme == "" {
+			return fmt.Errorf("theme is required")
+		}
+		settings["theme"] = theme
+		return writeSettings(baseDir, settings)
+	}
+
+	apps, _ := settings["appThemes"].(map[string]interface{})
+	if apps == nil {
+		apps = make(map[string]interface{})
+	}
+	if theme == "" {
+		delete(apps, app)
+	} else {
+		apps[app] = theme
+	}
+	if len(apps) == 0 {
+		delete(settings, "appThemes")
+	} else {
+		settings["appThemes"] = apps
+	}
+	return writeSettings(baseDir, settings)
+}
+
+// themeExists reports whether html/themes/{theme}.css exists (base.css is not a theme)
+func themeExists(baseDir, theme string) bool {
+	if theme == "base" || strings.ContainsAny(theme, `/\`) {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(baseDir, "html", "themes", theme+".css"))
+	return err == nil
+}
+
+// setTheme records a theme selection for a scope. Session overrides live in memory; the others
+// are written to settings.json, and a global change re-injects the index.html theme block.
+// A mode ("fixed" or "auto") may accompany a global selection; with a mode, theme defaults to the current one.
+func (s *Server) setTheme(sessionID, scope, app, theme, mode string) error {
+	s.mu.RLock()
+	baseDir := s.baseDir
+	s.mu.RUnlock()
+
+	if mode != "" && scope != ThemeScopeGlobal && scope != "" {
+		return fmt.Errorf("mode applies to the global scope only")
+	}
+
+	switch scope {
+	case ThemeScopeGlobal, "":
+		if mode != "" {
+			if theme == "" {
+				theme = GetCurrentTheme(baseDir)
+			}
+			// Check the theme first so a bad name leaves the mode unchanged
+			if !themeExists(baseDir, theme) {
+				return fmt.Errorf("theme %s not found", theme)
+			}
+			if err := SaveThemeMode(baseDir, mode); err != nil {
+				return err
+			}
+		}
+		if err := SaveThemeSetting(baseDir, "", theme); err != nil {
+			return err
+		}
+		return InjectThemeBlock(baseDir)
+
+	case ThemeScopeApp:
+		if app == "" {
+			return fmt.Errorf("app is required for app scope")
+		}
+		return SaveThemeSetting(baseDir, app, theme)
+
+	case ThemeScopeSession:
+		if theme != "" && !themeExists(baseDir, theme) {
+			return fmt.Errorf("theme %s not found", theme)
+		}
+		s.mu.Lock()
+		if theme == "" {
+			delete(s.sessionThemes, sessionID)
+		} else {
+			s.sessionThemes[sessionID] = theme
+		}
+		s.mu.Unlock()
+		return nil
+
+	default:
+		return fmt.Errorf("unknown scope: %s (use: global, app, session)", scope)
+	}
+}
+
+// effectiveTheme resolves a session's theme: session override, then app override, then global
+func (s *Server) effectiveTheme(sessionID, app string) string {
+	s.mu.RLock()
+	baseDir := s.baseDir
+	theme, ok := s.sessionThemes[sessionID]
+	s.mu.RUnlock()
+	if ok {
+		return theme
+	}
+	return ResolveAppTheme(baseDir, app)
+}
+
+// pushSessionTheme switches the session's browser to its effective theme via mcp.code.
+// Unless force is set, nothing is sent when the browser already shows that theme.
+// Must run inside the session's executor.
+func (s *Server) pushSessionTheme(L *lua.LState, mcpTable *lua.LTable, sessionID, app string, force bool) string {
+	theme := s.effectiveTheme(sessionID, app)
+
+	s.mu.Lock()
+	baseDir := s.baseDir
+	previous, ok := s.appliedThemes[sessionID]
+	_, sessionScoped := s.sessionThemes[sessionID]
+	s.appliedThemes[sessionID] = theme
+	s.mu.Unlock()
+	global := GetCurrentTheme(baseDir)
+	if !ok {
+		// Until the server pushes a theme, the page shows the one its theme block restored
+		previous = global
+	}
+
+	if theme == previous && !force {
+		return theme
+	}
+
+	// The browser mirrors session overrides to sessionStorage and the global theme to localStorage,
+	// so a reload restores the right theme before the server pushes anything
+	scope := ThemeScopeApp
+	if sessionScoped {
+		scope = ThemeScopeSession
+	} else if theme == global {
+		scope = ThemeScopeGlobal
+	}
+	setThemeCode(L, mcpTable, baseDir, theme, scope)
+	return theme
+}
+
+// pushPreviewTheme shows a theme in the session's browser without saving it anywhere.
+// The preview lasts until the next set, or the next display whose effective theme differs.
+// Must run inside the session's executor.
+func (s *Server) pushPreviewTheme(L *lua.LState, mcpTable *lua.LTable, sessionID, theme string) string {
+	s.mu.Lock()
+	baseDir := s.baseDir
+	s.appliedThemes[sessionID] = theme
+	s.mu.Unlock()
+	setThemeCode(L, mcpTable, baseDir, theme, themeScopePreview)
+	return theme
+}
+
+// setThemeCode sets mcp.code to switch the browser's theme. The stylesheet's modification time is
+// passed along so an edited theme is re-fetched rather than served from the browser cache.
+func setThemeCode(L *lua.LState, mcpTable *lua.LTable, baseDir, theme, scope string) {
+	counter := 1
+	if n, ok := L.GetField(mcpTable, "codeCounter").(lua.LNumber); ok {
+		counter = int(n) + 1
+	}
+	version := cssModTime(filepath.Join(baseDir, "html", "themes", theme+".css"))
+	// Refresh the auto pair too, in case the mode or global theme changed since the page loaded
+	autoThemes := autoThemesJS(baseDir, GetCurrentTheme(baseDir))
+	L.SetField(mcpTable, "codeCounter", lua.LNumber(counter))
+	L.SetField(mcpTable, "code", lua.LString(fmt.Sprintf(
+		"window.frictionlessAutoThemes = %s;\nif (window.frictionlessSetTheme) frictionlessSetTheme(%q, %q, %q);\n// %d",
+		autoThemes, theme, scope, version, counter)))
+}
+
+// broadcastTheme switches the browsers of every session the server knows about: the target session
+// and any session that has an override or was sent a theme. With a preview theme every session shows
+// it; otherwise each shows its effective theme (forced for the target, whose browser storage must
+// follow the new selection). Returns the theme now shown in the target session.
+func (s *Server) broadcastTheme(target, preview string) string {
+	s.mu.RLock()
+	ids := map[string]bool{target: true}
+	for id := range s.appliedThemes {
+		ids[id] = true
+	}
+	for id := range s.sessionThemes {
+		ids[id] = true
+	}
+	s.mu.RUnlock()
+
+	shown := ""
+	for id := range ids {
+		session := s.UiServer.GetLuaSession(id)
+		if id == "" || session == nil {
+			continue
+		}
+		s.SafeExecuteInSession(id, func() (interface{}, error) {
+			mcpTable, ok := session.State.GetGlobal("mcp").(*lua.LTable)
+			if !ok {
+				return nil, nil
+			}
+			var theme string
+			if preview != "" {
+				theme = s.pushPreviewTheme(session.State, mcpTable, id, preview)
+			} else {
+				theme = s.pushSessionTheme(session.State, mcpTable, id, displayedAppName(session.State, mcpTable), id == target)
+			}
+			if id == target {
+				shown = theme
+			}
+			return nil, nil
+		})
+	}
+	return shown
+}
+
+// displayedAppName returns the kebab-case name of the app in mcp.value (see mcp:currentAppName)
+func displayedAppName(L *lua.LState, mcpTable *lua.LTable) string {
+	value, ok := L.GetField(mcpTable, "value").(*lua.LTable)
+	if !ok {
+		return ""
+	}
+	typeName, ok := L.GetField(value, "type").(lua.LString)
+	if !ok {
+		return ""
+	}
+	name := upperPattern.ReplaceAllStringFunc(string(typeName), func(c string) string {
+		return "-" + strings.ToLower(c)
+	})
+	return strings.TrimPrefix(name, "-")
+}
@@ -0,0 +1,576 @@
+// Package mcp tests for theme management
+// Test: test-ThemeManager.md
+package mcp
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// completeThemeVariables returns a declaration for every schema variable
+func completeThemeVariables() string {
+	var sb strings.Builder
+	for _, v := range ThemeVariableSchema {
+		value := "#102030"
+		if v.Kind == "font" {
+			value = "monospace"
+		}
+		sb.WriteString("  " + v.Name + ": " + value + ";\n")
+	}
+	return sb.String()
+}
+
+// R169-R170: Theme Validation Tests
+// Test Design: test-ThemeManager.md (Theme validation)
+
+// TestValidateThemeCSSValid tests that a complete, documented theme passes
+func TestValidateThemeCSSValid(t *testing.T) {
+	css := `/*
+@theme test
+@class panel-header
+  @description Header
+*/
+.theme-test {
+` + completeThemeVariables() + `}
+.theme-test .panel-header:not(.hidden) { color: var(--term-text); }
+.theme-test sl-button[class~="x.y"]::part(base) { opacity: 0.5; }
+`
+	result, err := ValidateThemeCSS("test", []byte(css))
+	if err != nil {
+		t.Fatalf("ValidateThemeCSS returned error: %v", err)
+	}
+	if !result.Valid {
+		t.Errorf("Expected valid theme, got %+v", result)
+	}
+}
+
+// TestValidateThemeCSSProblems tests missing, extra and invalid variables and undocumented classes
+func TestValidateThemeCSSProblems(t *testing.T) {
+	vars := strings.Replace(completeThemeVariables(), "  --term-info: #102030;\n", "", 1)
+	vars = strings.Replace(vars, "--term-accent: #102030", "--term-accent: #12345", 1)
+	vars = strings.Replace(vars, "--term-border: #102030", "--term-border: bleu", 1)
+	css := `/* @theme test */
+.theme-test {
+` + vars + `  --term-shadow: #000;
+}
+.theme-test .fancy-card { color: red; }
+@media (max-width: 40.5em) { .theme-test .compact { padding: 0; } }
+`
+	result, err := ValidateThemeCSS("test", []byte(css))
+	if err != nil {
+		t.Fatalf("ValidateThemeCSS returned error: %v", err)
+	}
+	if result.Valid {
+		t.Error("Expected invalid theme")
+	}
+	if len(result.MissingVariables) != 1 || result.MissingVariables[0] != "--term-info" {
+		t.Errorf("Expected missing --term-info, got %v", result.MissingVariables)
+	}
+	if len(result.ExtraVariables) != 1 || result.ExtraVariables[0] != "--term-shadow" {
+		t.Errorf("Expected extra --term-shadow, got %v", result.ExtraVariables)
+	}
+	if len(result.InvalidValues) != 2 {
+		t.Errorf("Expected 2 invalid values (--term-accent, --term-border), got %+v", result.InvalidValues)
+	}
+	if strings.Join(result.UndocumentedClasses, ",") != "compact,fancy-card" {
+		t.Errorf("Expected undocumented compact and fancy-card, got %v", result.UndocumentedClasses)
+	}
+}
+
+// R171-R172: Theme Generation Tests
+// Test Design: test-ThemeManager.md (Theme generation)
+
+// TestGenerateThemeCSS tests that generated themes are complete, pass contrast and keep the source rules
+func TestGenerateThemeCSS(t *testing.T) {
+	source := `/*
+@theme lcars
+@description Source theme
+
+@class panel-header
+  @description Header
+  @usage Panel titles
+*/
+
+.theme-lcars {
+  --term-accent: #ff9900;
+  --term-mono: 'Antonio', monospace;
+}
+.theme-lcars .panel-header { color: var(--term-accent); }
+.theme-lcars-extra { color: red; }
+`
+	base := map[string]string{"--term-sans": "system-ui, sans-serif", "--term-mono": "monospace"}
+
+	for _, light := range []bool{false, true} {
+		for _, accent := range []string{"#E07A47", "#101010", "#ffff00"} {
+			css, err := GenerateThemeCSS("ember", "lcars", []byte(source), base, accent, light)
+			if err != nil {
+				t.Fatalf("GenerateThemeCSS(%s, light=%v) returned error: %v", accent, light, err)
+			}
+			result, err := ValidateThemeCSS("ember", []byte(css))
+			if err != nil {
+				t.Fatalf("ValidateThemeCSS returned error: %v", err)
+			}
+			if len(result.MissingVariables) > 0 || len(result.InvalidValues) > 0 || len(result.ExtraVariables) > 0 {
+				t.Errorf("Generated theme (%s, light=%v) has variable problems: %+v", accent, light, result)
+			}
+			fm, _ := ParseThemeCSS([]byte(css))
+			if issues := CheckThemeContrast("ember", fm.Variables); len(issues) > 0 {
+				t.Errorf("Generated theme (%s, light=%v) fails contrast: %+v", accent, light, issues)
+			}
+		}
+	}
+
+	css, _ := GenerateThemeCSS("ember", "lcars", []byte(source), base, "#E07A47", false)
+	for _, want := range []string{"@theme ember", "@class panel-header", "@usage Panel titles", ".theme-ember .panel-header", ".theme-lcars-extra", "'Antonio', monospace"} {
+		if !strings.Contains(css, want) {
+			t.Errorf("Expected generated CSS to contain %q:\n%s", want, css)
+		}
+	}
+	if strings.Contains(css, "#ff9900") || strings.Contains(css, "@theme lcars") {
+		t.Errorf("Expected source variables and frontmatter to be replaced:\n%s", css)
+	}
+
+	if _, err := GenerateThemeCSS("ember", "lcars", []byte(source), base, "orange-ish", false); err == nil {
+		t.Error("Expected error for an invalid accent color")
+	}
+}
+
+// R173-R175: Theme Selection Tests
+// Test Design: test-ThemeManager.md (Theme selection)
+
+// setupThemeSettingsDir creates a base dir with two themes and a settings.json holding an unrelated key
+func setupThemeSettingsDir(t *testing.T) string {
+	t.Helper()
+	baseDir := t.TempDir()
+	themesDir := filepath.Join(baseDir, "html", "themes")
+	os.MkdirAll(themesDir, 0755)
+	os.MkdirAll(filepath.Join(baseDir, "storage"), 0755)
+	for _, name := range []string{"base", "lcars", "clarity"} {
+		os.WriteFile(filepath.Join(themesDir, name+".css"), []byte("/* @theme "+name+" */"), 0644)
+	}
+	os.WriteFile(filepath.Join(baseDir, "storage", "settings.json"), []byte(`{"theme":"lcars","checkUpdates":true}`), 0644)
+	return baseDir
+}
+
+// TestSaveThemeSetting tests global and per-app settings, and that other settings survive
+func TestSaveThemeSetting(t *testing.T) {
+	baseDir := setupThemeSettingsDir(t)
+
+	if err := SaveThemeSetting(baseDir, "job-tracker", "clarity"); err != nil {
+		t.Fatalf("SaveThemeSetting returned error: %v", err)
+	}
+	if got := ResolveAppTheme(baseDir, "job-tracker"); got != "clarity" {
+		t.Errorf("Expected job-tracker theme clarity, got %s", got)
+	}
+	if got := ResolveAppTheme(baseDir, "contacts"); got != "lcars" {
+		t.Errorf("Expected contacts to use the global theme lcars, got %s", got)
+	}
+
+	if err := SaveThemeSetting(baseDir, "", "clarity"); err != nil {
+		t.Fatalf("SaveThemeSetting returned error: %v", err)
+	}
+	if got := GetCurrentTheme(baseDir); got != "clarity" {
+		t.Errorf("Expected global theme clarity, got %s", got)
+	}
+
+	if err := SaveThemeSetting(baseDir, "job-tracker", ""); err != nil {
+		t.Fatalf("SaveThemeSetting returned error: %v", err)
+	}
+	if len(GetAppThemes(baseDir)) != 0 {
+		t.Errorf("Expected override to be cleared, got %v", GetAppThemes(baseDir))
+	}
+
+	data, _ := os.ReadFile(filepath.Join(baseDir, "storage", "settings.json"))
+	var settings map[string]interface{}
+	json.Unmarshal(data, &settings)
+	if settings["checkUpdates"] != true {
+		t.Errorf("Expected unrelated settings to survive, got %s", data)
+	}
+
+	for _, theme := range []string{"missing", "base", "../lcars"} {
+		if err := SaveThemeSetting(baseDir, "", theme); err == nil {
+			t.Errorf("Expected error for theme %q", theme)
+		}
+	}
+}
+
+// TestSessionThemeOverride tests theme resolution order and the mcp.code pushed to the browser
+func TestSessionThemeOverride(t *testing.T) {
+	baseDir := setupThemeSettingsDir(t)
+	s := &Server{baseDir: baseDir, sessionThemes: map[string]string{}, appliedThemes: map[string]string{}}
+	L := lua.NewState()
+	defer L.Close()
+	mcpTable := L.NewTable()
+
+	// Displaying an app without overrides sends nothing: the page already shows the global theme
+	if got := s.pushSessionTheme(L, mcpTable, "1", "contacts", false); got != "lcars" {
+		t.Errorf("Expected lcars, got %s", got)
+	}
+	if code := L.GetField(mcpTable, "code"); code != lua.LNil {
+		t.Errorf("Expected no code for an unchanged theme, got %v", code)
+	}
+
+	if err := s.setTheme("1", ThemeScopeApp, "job-tracker", "clarity", ""); err != nil {
+		t.Fatalf("setTheme returned error: %v", err)
+	}
+	if got := s.pushSessionTheme(L, mcpTable, "1", "job-tracker", false); got != "clarity" {
+		t.Errorf("Expected app override clarity, got %s", got)
+	}
+	if code := L.GetField(mcpTable, "code").String(); !strings.Contains(code, `frictionlessSetTheme("clarity", "app", "?v=`) {
+		t.Errorf("Expected theme switch code, got %q", code)
+	}
+
+	// A session override beats the app override, and only for that session
+	if err := s.setTheme("1", ThemeScopeSession, "", "lcars", ""); err != nil {
+		t.Fatalf("setTheme returned error: %v", err)
+	}
+	if got := s.effectiveTheme("1", "job-tracker"); got != "lcars" {
+		t.Errorf("Expected session override lcars, got %s", got)
+	}
+	if got := s.effectiveTheme("2", "job-tracker"); got != "clarity" {
+		t.Errorf("Expected other session to keep clarity, got %s", got)
+	}
+	s.pushSessionTheme(L, mcpTable, "1", "job-tracker", false)
+	if code := L.GetField(mcpTable, "code").String(); !strings.Contains(code, `frictionlessSetTheme("lcars", "session", "?v=`) {
+		t.Errorf("Expected session-scoped switch code, got %q", code)
+	}
+
+	if err := s.setTheme("1", ThemeScopeSession, "", "", ""); err != nil {
+		t.Fatalf("setTheme returned error: %v", err)
+	}
+	if got := s.effectiveTheme("1", "job-tracker"); got != "clarity" {
+		t.Errorf("Expected cleared session override to fall back to clarity, got %s", got)
+	}
+	if err := s.setTheme("1", "galaxy", "", "lcars", ""); err == nil {
+		t.Error("Expected error for unknown scope")
+	}
+}
+
+// TestPreviewTheme tests that a preview switches the browser without saving, and the next display restores
+func TestPreviewTheme(t *testing.T) {
+	baseDir := setupThemeSettingsDir(t)
+	s := &Server{baseDir: baseDir, sessionThemes: map[string]string{}, appliedThemes: map[string]string{}}
+	L := lua.NewState()
+	defer L.Close()
+	mcpTable := L.NewTable()
+
+	s.pushPreviewTheme(L, mcpTable, "1", "clarity")
+	code := L.GetField(mcpTable, "code").String()
+	if !strings.Contains(code, `frictionlessSetTheme("clarity", "preview", "?v=`) {
+		t.Errorf("Expected cache-busted preview code, got %q", code)
+	}
+	if got := GetCurrentTheme(baseDir); got != "lcars" {
+		t.Errorf("Expected preview not to change settings, got %s", got)
+	}
+
+	if got := s.pushSessionTheme(L, mcpTable, "1", "contacts", false); got != "lcars" {
+		t.Errorf("Expected display to restore lcars, got %s", got)
+	}
+	if code := L.GetField(mcpTable, "code").String(); !strings.Contains(code, `frictionlessSetTheme("lcars", "global"`) {
+		t.Errorf("Expected switch back to the global theme, got %q", code)
+	}
+
+	block := GenerateThemeBlock(baseDir, []string{"clarity", "lcars"}, "lcars")
+	if !strings.Contains(block, "sessionStorage.getItem('theme') || localStorage.getItem('theme') || 'lcars'") ||
+		!strings.Contains(block, "window.frictionlessSetTheme") || !strings.Contains(block, "new EventSource('/browser-presence')") {
+		t.Errorf("Expected theme block to restore session overrides, define frictionlessSetTheme and open the presence stream:\n%s", block)
+	}
+}
+
+// R178-R179: Theme Audit Class Usage Tests
+// Test Design: test-ThemeManager.md (Theme audit class usage)
+
+// TestThemePairsAutoMode tests @pair discovery, scheme detection, and the auto mode theme block
+func TestThemePairsAutoMode(t *testing.T) {
+	baseDir := setupThemeSettingsDir(t)
+	themesDir := filepath.Join(baseDir, "html", "themes")
+	os.WriteFile(filepath.Join(themesDir, "lcars.css"), []byte("/*\n@theme lcars\n@pair clarity\n*/\n.theme-lcars { --term-bg: #0a0a0f; }\n"), 0644)
+	os.WriteFile(filepath.Join(themesDir, "clarity.css"), []byte("/*\n@theme clarity\n*/\n.theme-clarity { --term-bg: #fafafa; }\n"), 0644)
+	os.WriteFile(filepath.Join(themesDir, "ninja.css"), []byte("/*\n@theme ninja\n@pair ghost\n*/\n.theme-ninja { --term-bg: #000000; }\n"), 0644)
+	os.WriteFile(filepath.Join(baseDir, "html", "index.html"), []byte("<html><head></head><body></body></html>"), 0644)
+
+	pairs := ThemePairs(baseDir)
+	if pairs["lcars"] != "clarity" || pairs["clarity"] != "lcars" {
+		t.Errorf("Expected lcars and clarity paired both ways, got %v", pairs)
+	}
+	if _, ok := pairs["ninja"]; ok {
+		t.Errorf("Expected pair with a missing theme to be dropped, got %v", pairs)
+	}
+
+	list, err := ListThemesWithInfo(baseDir)
+	if err != nil {
+		t.Fatalf("ListThemesWithInfo returned error: %v", err)
+	}
+	if list.Mode != ThemeModeFixed {
+		t.Errorf("Expected default mode fixed, got %s", list.Mode)
+	}
+	for _, info := range list.Themes {
+		if info.Name == "clarity" && (info.Pair != "lcars" || info.Scheme != "light") {
+			t.Errorf("Expected clarity to be light and paired with lcars, got %+v", info)
+		}
+	}
+
+	// Fixed mode restores from localStorage as before
+	block := GenerateThemeBlock(baseDir, []string{"clarity", "lcars", "ninja"}, "lcars")
+	if !strings.Contains(block, "window.frictionlessAutoThemes = null;") || !strings.Contains(block, "localStorage.getItem('theme')") {
+		t.Errorf("Expected fixed mode theme block, got %q", block)
+	}
+
+	s := &Server{baseDir: baseDir, sessionThemes: map[string]string{}, appliedThemes: map[string]string{}}
+	if err := s.setTheme("1", ThemeScopeSession, "", "", ThemeModeAuto); err == nil {
+		t.Error("Expected error for a mode outside the global scope")
+	}
+	if err := s.setTheme("1", ThemeScopeGlobal, "", "nosuch", ThemeModeAuto); err == nil {
+		t.Error("Expected error for an unknown theme")
+	}
+	if got := GetThemeMode(baseDir); got != ThemeModeFixed {
+		t.Errorf("Expected an unknown theme to leave the mode fixed, got %s", got)
+	}
+	if err := s.setTheme("1", ThemeScopeGlobal, "", "", ThemeModeAuto); err != nil {
+		t.Fatalf("setTheme returned error: %v", err)
+	}
+	if got := GetThemeMode(baseDir); got != ThemeModeAuto {
+		t.Errorf("Expected mode auto, got %s", got)
+	}
+	if got := GetCurrentTheme(baseDir); got != "lcars" {
+		t.Errorf("Expected a mode change to keep theme lcars, got %s", got)
+	}
+
+	block = GenerateThemeBlock(baseDir, []string{"clarity", "lcars", "ninja"}, "lcars")
+	if !strings.Contains(block, `window.frictionlessAutoThemes = {"dark":"lcars","light":"clarity"};`) {
+		t.Errorf("Expected auto themes in block, got %q", block)
+	}
+	if !strings.Contains(block, "frictionlessSchemeTheme('lcars')") {
+		t.Errorf("Expected auto mode restore, got %q", block)
+	}
+
+	// An unpaired theme in auto mode behaves as fixed
+	if got := autoThemesJS(baseDir, "ninja"); got != "null" {
+		t.Errorf("Expected no auto themes for unpaired ninja, got %s", got)
+	}
+}
+
+// TestThemePackageRoundTrip tests export, install, replacement and the manifest record
+func TestThemePackageRoundTrip(t *testing.T) {
+	srcDir := setupThemeSettingsDir(t)
+	themesDir := filepath.Join(srcDir, "html", "themes")
+	os.WriteFile(filepath.Join(themesDir, "ember.css"), []byte("/*\n@theme ember\n*/\n@font-face { src: url('ember/fonts/ember.woff2'); }\n.theme-ember { background: url(ember/bg.png); }\n.theme-ember h1 { background: url(\"../elsewhere.png\"); }\n"), 0644)
+	os.MkdirAll(filepath.Join(themesDir, "ember", "fonts"), 0755)
+	os.WriteFile(filepath.Join(themesDir, "ember", "fonts", "ember.woff2"), []byte("font"), 0644)
+	os.WriteFile(filepath.Join(themesDir, "ember", "bg.png"), []byte("png"), 0644)
+
+	archive := filepath.Join(t.TempDir(), "ember.zip")
+	exported, err := ExportTheme(srcDir, "ember", archive)
+	if err != nil {
+		t.Fatalf("ExportTheme returned error: %v", err)
+	}
+	if want := []string{"ember.css", "ember/bg.png", "ember/fonts/ember.woff2"}; fmt.Sprint(exported.Files) != fmt.Sprint(want) {
+		t.Errorf("Expected archive files %v, got %v", want, exported.Files)
+	}
+	if len(exported.Warnings) != 1 || !strings.Contains(exported.Warnings[0], "../elsewhere.png") {
+		t.Errorf("Expected a warning for the reference outside the package, got %v", exported.Warnings)
+	}
+
+	baseDir := setupThemeSettingsDir(t)
+	writeManifest(baseDir, &InstallManifest{Version: "1.0.0", Files: map[string]string{"html/themes/lcars.css": "sha256:x"}})
+	installed, err := InstallThemePackage(baseDir, archive, false)
+	if err != nil {
+		t.Fatalf("InstallThemePackage returned error: %v", err)
+	}
+	if installed.Theme != "ember" || installed.Replaced || len(installed.Files) != 3 {
+		t.Errorf("Unexpected install result: %+v", installed)
+	}
+	if data, _ := os.ReadFile(filepath.Join(baseDir, "html", "themes", "ember", "fonts", "ember.woff2")); string(data) != "font" {
+		t.Errorf("Expected installed font, got %q", data)
+	}
+	manifest, _ := readManifest(baseDir)
+	if !manifest.isUserThemeFile("html/themes/ember.css") || manifest.isUserThemeFile("html/themes/lcars.css") {
+		t.Errorf("Expected only ember recorded as a user theme, got %v", manifest.UserThemes)
+	}
+	if manifest.Version != "1.0.0" || len(manifest.Files) != 1 {
+		t.Errorf("Expected bundled manifest entries preserved, got %+v", manifest)
+	}
+
+	// Reinstalling a user theme replaces it without force, dropping assets the new package lacks
+	os.WriteFile(filepath.Join(baseDir, "html", "themes", "ember", "stale.png"), []byte("old"), 0644)
+	if installed, err = InstallThemePackage(baseDir, archive, false); err != nil || !installed.Replaced {
+		t.Fatalf("Expected replacement, got %+v, %v", installed, err)
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, "html", "themes", "ember", "stale.png")); !os.IsNotExist(err) {
+		t.Error("Expected stale asset removed on replace")
+	}
+
+	// Bundled themes, hand-dropped themes and bad packages are refused
+	bundled := filepath.Join(t.TempDir(), "lcars")
+	os.MkdirAll(bundled, 0755)
+	os.WriteFile(filepath.Join(bundled, "lcars.css"), []byte("/* @theme lcars */"), 0644)
+	if _, err := InstallThemePackage(baseDir, bundled, true); err == nil {
+		t.Error("Expected error replacing a bundled theme")
+	}
+	dropped := filepath.Join(t.TempDir(), "clarity")
+	os.MkdirAll(dropped, 0755)
+	os.WriteFile(filepath.Join(dropped, "clarity.css"), []byte("/* @theme clarity */"), 0644)
+	if _, err := InstallThemePackage(baseDir, dropped, false); err == nil {
+		t.Error("Expected error replacing an existing theme without force")
+	}
+	if _, err := InstallThemePackage(baseDir, dropped, true); err != nil {
+		t.Errorf("Expected force to replace an existing theme, got %v", err)
+	}
+
+	for name, files := range map[string]map[string]string{
+		"mismatched name": {"ash.css": "/* @theme cinder */"},
+		"script asset":    {"ash.css": "/* @theme ash */", "ash/evil.js": "alert(1)"},
+		"stray file":      {"ash.css": "/* @theme ash */", "fonts/a.woff2": "x"},
+		"escaping path":   {"ash.css": "/* @theme ash */", "../ash/a.png": "x"},
+	} {
+		archive := filepath.Join(t.TempDir(), "ash.zip")
+		f, _ := os.Create(archive)
+		zw := zip.NewWriter(f)
+		for p, content := range files {
+			w, _ := zw.Create(p)
+			w.Write([]byte(content))
+		}
+		zw.Close()
+		f.Close()
+		if _, err := InstallThemePackage(baseDir, archive, false); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+// TestSpliceThemeBlock tests head tag detection, block replacement and idempotence
+func TestSpliceThemeBlock(t *testing.T) {
+	block := "  <!-- #frictionless -->\n  <link rel=\"stylesheet\" href=\"/themes/base.css\">\n  <!-- /frictionless -->\n"
+	tests := []struct {
+		name, input, want string
+	}{
+		{"plain head", "<html><head>\n<title>x</title></head></html>",
+			"<html><head>\n" + block + "<title>x</title></head></html>"},
+		{"attributes and case", "<html>\n<HEAD lang=\"en\" data-x='<head>'><title>x</title></HEAD></html>",
+			"<html>\n<HEAD lang=\"en\" data-x='<head>'>\n" + block + "<title>x</title></HEAD></html>"},
+		{"existing block", "<head>\n  <!-- #frictionless -->\n  <link href=\"/themes/old.css\">\n  <!-- /frictionless -->\n  <title>x</title>\n</head>",
+			"<head>\n" + block + "  <title>x</title>\n</head>"},
+		{"marker in script", "<head>\n<script>var s = '<!-- #frictionless -->';</script>\n</head>",
+			"<head>\n" + block + "<script>var s = '<!-- #frictionless -->';</script>\n</head>"},
+		{"block outside head", "<!-- #frictionless --><!-- /frictionless -->\n<head>\n</head>",
+			"<head>\n" + block + "</head>"},
+	}
+	for _, tt := range tests {
+		got, err := spliceThemeBlock(tt.input, block)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+		if again, _ := spliceThemeBlock(got, block); again != got {
+			t.Errorf("%s: splicing twice changed the document:\n%q", tt.name, again)
+		}
+	}
+
+	// Documents written by older injections (blank line before the block) settle after one splice
+	legacy := "<head>\n\n  <!-- #frictionless -->\n  <!-- /frictionless -->\n  <title>x</title>\n</head>"
+	once, _ := spliceThemeBlock(legacy, block)
+	if twice, _ := spliceThemeBlock(once, block); twice != once {
+		t.Errorf("Expected legacy document to settle, got %q then %q", once, twice)
+	}
+
+	if _, err := spliceThemeBlock("<html><body><!-- <head> --></body></html>", block); err == nil {
+		t.Error("Expected error for a document without a head tag")
+	}
+}
+
+// TestInjectThemeBlockUnchanged tests that injection only writes index.html when the block changes
+func TestInjectThemeBlockUnchanged(t *testing.T) {
+	baseDir := setupThemeSettingsDir(t)
+	indexPath := filepath.Join(baseDir, "html", "index.html")
+	os.WriteFile(indexPath, []byte("<!DOCTYPE html>\n<html>\n<head data-app=\"frictionless\">\n<title>x</title>\n</head>\n</html>\n"), 0644)
+
+	if written, err := updateThemeBlock(baseDir); err != nil || !written {
+		t.Fatalf("Expected first injection to write, got %v, %v", written, err)
+	}
+	if !HasThemeBlock(baseDir) {
+		t.Error("Expected theme block after injection")
+	}
+	if written, err := updateThemeBlock(baseDir); err != nil || written {
+		t.Errorf("Expected unchanged block not to be written, got %v, %v", written, err)
+	}
+
+	// A new theme changes the block's links, so the next injection writes
+	os.WriteFile(filepath.Join(baseDir, "html", "themes", "ninja.css"), []byte("/* @theme ninja */"), 0644)
+	if written, err := updateThemeBlock(baseDir); err != nil || !written {
+		t.Errorf("Expected a new theme to rewrite the block, got %v, %v", written, err)
+	}
+	data, _ := os.ReadFile(indexPath)
+	if strings.Count(string(data), "#frictionless") != 1 || !strings.Contains(string(data), "/themes/ninja.css") {
+		t.Errorf("Expected one block linking ninja, got %s", data)
+	}
+}
+
+// TestAuditAppClassUsages tests that static classes, ui-class-NAME bindings and Lua-returned
+// classes are all found, with every occurrence and its line
+func TestAuditAppClassUsages(t *testing.T) {
+	baseDir := t.TempDir()
+	appDir := filepath.Join(baseDir, "apps", "tracker")
+	os.MkdirAll(filepath.Join(appDir, "viewdefs"), 0755)
+	os.WriteFile(filepath.Join(appDir, "viewdefs", "Tracker.DEFAULT.html"), []byte(`<template>
+  <div class="panel-header job-row">
+    <span ui-class-overdue="isOverdue()" ui-class-hidden="done"></span>
+    <div
+class="job-row">
+    </div>
+    <div ui-class="rowClass()"></div>
+    <div ui-class="hidden:done"></div>
+  </div>
+</template>
+`), 0644)
+	os.WriteFile(filepath.Join(appDir, "app.lua"), []byte(`Tracker = session:prototype("Tracker", {})
+
+function Tracker:rowClass()
+    -- return "commented-out"
+    if self.urgent then
+        return "job-urgent"
+    end
+    return self.done and "job-done muted" or ""
+end
+
+function Tracker:label()
+    return "not-a-class"
+end
+`), 0644)
+
+	result, err := AuditAppWithClasses(baseDir, "tracker", "test", []ThemeClass{{Name: "panel-header"}, {Name: "input-area"}})
+	if err != nil {
+		t.Fatalf("AuditAppWithClasses returned error: %v", err)
+	}
+
+	var got []string
+	for _, u := range result.UndocumentedClasses {
+		got = append(got, fmt.Sprintf("%s@%s:%d/%s", u.Class, u.File, u.Line, u.Source))
+	}
+	want := []string{
+		"job-done@app.lua:8/lua",
+		"job-row@Tracker.DEFAULT.html:2/class",
+		"job-row@Tracker.DEFAULT.html:5/class",
+		"job-urgent@app.lua:6/lua",
+		"muted@app.lua:8/lua",
+		"overdue@Tracker.DEFAULT.html:3/ui-class",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected undocumented usages:\n  %v\ngot:\n  %v", want, got)
+	}
+	if result.Summary.Total != 6 || result.Summary.Undocumented != 5 || result.Summary.Documented != 1 {
+		t.Errorf("Expected 6 classes (1 documented, 5 undocumented), got %+v", result.Summary)
+	}
+	if strings.Join(result.UnusedThemeClasses, ",") != "input-area" {
+		t.Errorf("Expected unused input-area, got %v", result.UnusedThemeClasses)
+	}
+}
@@ -0,0 +1,170 @@
+package mcp
+
+// CRC: crc-ThemeManager.md | Seq: seq-theme-audit.md
+// Class usage scanning for theme audits: static class attributes, ui-class-NAME bindings,
+// and class strings returned by Lua methods bound with ui-class
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Class usage sources
+const (
+	classSourceAttr    = "class"    // class="a b"
+	classSourceBinding = "ui-class" // ui-class-NAME="expr"
+	classSourceLua     = "lua"      // string returned by a method bound with ui-class="method()"
+)
+
+var (
+	// Matches: class="a b" (not ui-class="...")
+	// Captures: class list
+	classAttrPattern = regexp.MustCompile(`(?:^|[\s<])class\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+	// Matches: ui-class-NAME=
+	// Captures: class name
+	uiClassBindingPattern = regexp.MustCompile(`\bui-class-([\w-]+)\s*=`)
+
+	// Matches: ui-class="path"
+	// Captures: binding path
+	uiClassPathPattern = regexp.MustCompile(`\bui-class\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+	// Matches: a Lua string literal
+	// Captures: double-quoted content, single-quoted content
+	luaStringPattern = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"|'((?:[^'\\]|\\.)*)'`)
+
+	// Matches a valid CSS class name
+	classNamePattern = regexp.MustCompile(`^-?[A-Za-z_][\w-]*$`)
+
+	// Matches: end at the start of a line (closes a top-level function)
+	topLevelEndPattern = regexp.MustCompile(`(?m)^end\b`)
+
+	// Matches: the return keyword
+	luaReturnPattern = regexp.MustCompile(`\breturn\b`)
+)
+
+// lineAt returns the 1-based line number of a byte offset
+func lineAt(content string, offset int) int {
+	return strings.Count(content[:offset], "\n") + 1
+}
+
+// addClassUsages records each valid class name in a space-separated list
+func addClassUsages(usages []ClassUsage, list, file string, line int, source string) []ClassUsage {
+	for _, class := range strings.Fields(list) {
+		if classNamePattern.MatchString(class) && !isSkippedClass(class) {
+			usages = append(usages, ClassUsage{Class: class, File: file, Line: line, Source: source})
+		}
+	}
+	return usages
+}
+
+// collectClassUsages returns every class occurrence in an app: static class attributes and
+// ui-class-NAME bindings in viewdefs, plus string literals returned by Lua methods that
+// viewdefs bind with ui-class. Sorted by class, file and line.
+func collectClassUsages(baseDir, appName string) ([]ClassUsage, error) {
+	viewdefsPath := filepath.Join(baseDir, "apps", appName, "viewdefs")
+	entries, err := os.ReadDir(viewdefsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var usages []ClassUsage
+	boundMethods := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".html") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(viewdefsPath, entry.Name()))
+		if err != nil {
+			continue
+		}
+		content := string(data)
+
+		for _, m := range classAttrPattern.FindAllStringSubmatchIndex(content, -1) {
+			// Line of the value, not the match start (which may be the preceding newline)
+			list := submatch(content, m, 1) + submatch(content, m, 2)
+			usages = addClassUsages(usages, list, entry.Name(), lineAt(content, m[1]-len(list)-1), classSourceAttr)
+		}
+		for _, m := range uiClassBindingPattern.FindAllStringSubmatchIndex(content, -1) {
+			usages = addClassUsages(usages, content[m[2]:m[3]], entry.Name(), lineAt(content, m[0]), classSourceBinding)
+		}
+		for _, m := range uiClassPathPattern.FindAllStringSubmatchIndex(content, -1) {
+			if method := boundMethodName(submatch(content, m, 1) + submatch(content, m, 2)); method != "" {
+				boundMethods[method] = true
+			}
+		}
+	}
+
+	if len(boundMethods) > 0 {
+		if data, err := os.ReadFile(filepath.Join(baseDir, "apps", appName, "app.lua")); err == nil {
+			usages = append(usages, luaReturnedClasses(string(data), boundMethods)...)
+		}
+	}
+
+	sort.SliceStable(usages, func(i, j int) bool {
+		a, b := usages[i], usages[j]
+		if a.Class != b.Class {
+			return a.Class < b.Class
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return usages, nil
+}
+
+// submatch returns a capture group from FindAllStringSubmatchIndex output, or "" if it didn't participate
+func submatch(content string, m []int, group int) string {
+	if m[2*group] < 0 {
+		return ""
+	}
+	return content[m[2*group]:m[2*group+1]]
+}
+
+// boundMethodName returns the method a ui-class path calls: the last path segment, without
+// arguments or ?params. Returns "" for the old "class:condition" form and for non-method paths.
+func boundMethodName(path string) string {
+	if i := strings.Index(path, "?"); i != -1 {
+		path = path[:i]
+	}
+	path = strings.TrimSpace(path)
+	if strings.Contains(path, ":") || !strings.HasSuffix(path, ")") {
+		return ""
+	}
+	if i := strings.Index(path, "("); i != -1 {
+		path = path[:i]
+	}
+	if i := strings.LastIndex(path, "."); i != -1 {
+		path = path[i+1:]
+	}
+	return path
+}
+
+// luaReturnedClasses scans the bodies of the named methods (on any prototype) for return
+// statements and records the class names in their string literals
+func luaReturnedClasses(content string, methods map[string]bool) []ClassUsage {
+	var usages []ClassUsage
+	for _, def := range methodDefPattern.FindAllStringSubmatchIndex(content, -1) {
+		if !methods[content[def[4]:def[5]]] {
+			continue
+		}
+		end := len(content)
+		if loc := topLevelEndPattern.FindStringIndex(content[def[1]:]); loc != nil {
+			end = def[1] + loc[1]
+		}
+		offset := def[0]
+		for _, line := range strings.SplitAfter(content[def[0]:end], "\n") {
+			if loc := luaReturnPattern.FindStringIndex(line); loc != nil && !strings.HasPrefix(strings.TrimSpace(line), "--") {
+				for _, lit := range luaStringPattern.FindAllStringSubmatch(line[loc[0]:], -1) {
+					usages = addClassUsages(usages, lit[1]+lit[2], "app.lua", lineAt(content, offset), classSourceLua)
+				}
+			}
+			offset += len(line)
+		}
+	}
+	return usages
+}
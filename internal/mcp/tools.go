@@ -10,6 +10,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -19,6 +20,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/yuin/goldmark"
@@ -36,10 +38,11 @@ func (s *Server) registerTools() {
 
 	// ui_open_browser
 	s.mcpServer.AddTool(mcp.NewTool("ui_open_browser",
-		mcp.WithDescription("Open the system's default web browser to the UI session."),
+		mcp.WithDescription("Open a web browser to the UI session, unless one is already connected. Uses the \"browser\" setting, $BROWSER or the system opener; on a headless machine it returns the URL with opened=false instead of failing."),
 		mcp.WithString("sessionId", mcp.Description("The vended session ID to open (defaults to '1')")),
 		mcp.WithString("path", mcp.Description("The URL path to open (defaults to '/')")),
 		mcp.WithBoolean("conserve", mcp.Description("Use conserve mode to prevent duplicate tabs (defaults to true)")),
+		mcp.WithBoolean("force", mcp.Description("Open a browser even when one is already connected (defaults to false)")),
 	), s.handleOpenBrowser)
 
 	// ui_run
@@ -47,11 +50,33 @@ func (s *Server) registerTools() {
 		mcp.WithDescription("Execute Lua code in a session context"),
 		mcp.WithString("code", mcp.Required(), mcp.Description("Lua code to execute")),
 		mcp.WithString("sessionId", mcp.Description("The vended session ID to run in (defaults to '1')")),
+		mcp.WithNumber("timeout", mcp.Description("Seconds the code may run before it is stopped (defaults to 30, at most 600)")),
+		mcp.WithString("profile", mcp.Enum("full", "no-io", "readonly"),
+			mcp.Description("Execution profile: full (default), no-io (the code itself gets no files, processes or module loading; functions it calls are unrestricted), or readonly (no-io, and session state cannot be changed)")),
 	), s.handleRun)
 
+	// ui_batch
+	// Spec: mcp.md section 5.7
+	s.mcpServer.AddTool(mcp.NewTool("ui_batch",
+		mcp.WithDescription("Run an ordered list of Lua snippets and app displays in one executor turn, so the browser sees one update. If a step fails, later steps are skipped and session tables are restored to their state before the batch."),
+		mcp.WithArray("steps", mcp.Required(), mcp.Description("Steps in order: {\"code\": \"lua\"} to run code or {\"display\": \"app\"} to display an app"),
+			mcp.Items(map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"code":    map[string]any{"type": "string", "description": "Lua code to execute"},
+					"display": map[string]any{"type": "string", "description": "App name to display"},
+				},
+			})),
+		mcp.WithString("sessionId", mcp.Description("The vended session ID to run in (defaults to '1')")),
+		mcp.WithNumber("timeout", mcp.Description("Seconds the whole batch may run before it is stopped (defaults to 30, at most 600)")),
+		mcp.WithString("profile", mcp.Enum("full", "no-io", "readonly"),
+			mcp.Description("Execution profile for code steps, as for ui_run; display steps need full")),
+		mcp.WithBoolean("rollback", mcp.Description("Restore session tables when a step fails (defaults to true)")),
+	), s.handleBatch)
+
 	// ui_status
 	s.mcpServer.AddTool(mcp.NewTool("ui_status",
-		mcp.WithDescription("Get current server status including browser connection count"),
+		mcp.WithDescription("Get server health: version, URL, browser session count, MCP sessions with their apps and queued events, publisher topics and Go memory use"),
 	), s.handleStatus)
 
 	// ui_install
@@ -73,20 +98,47 @@ func (s *Server) registerTools() {
 		mcp.WithString("sessionId", mcp.Description("Session ID (defaults to current session)")),
 	), s.handleDisplay)
 
+	// ui_snapshot
+	// Spec: mcp.md section 5.8
+	s.mcpServer.AddTool(mcp.NewTool("ui_snapshot",
+		mcp.WithDescription("Render the displayed app (mcp.value) to HTML on the server with its viewdefs and bindings resolved, to check layout without a browser"),
+		mcp.WithString("namespace", mcp.Description("Viewdef namespace for mcp.value (defaults to DEFAULT)")),
+		mcp.WithString("sessionId", mcp.Description("Session ID (defaults to current session)")),
+		mcp.WithNumber("timeout", mcp.Description("Seconds methods in binding paths may run before the snapshot is stopped (defaults to 30, at most 600)")),
+	), s.handleSnapshot)
+
 	// ui_audit
 	// Spec: specs/ui-audit.md
 	s.mcpServer.AddTool(mcp.NewTool("ui_audit",
-		mcp.WithDescription("Analyze an app for code quality violations (dead methods, viewdef issues)"),
-		mcp.WithString("name", mcp.Required(), mcp.Description("App name to audit")),
+		mcp.WithDescription("Analyze an app for code quality violations (dead methods, viewdef issues). Use all=true to audit every app and get a summary table."),
+		mcp.WithString("name", mcp.Description("App name to audit (required unless all=true)")),
+		mcp.WithBoolean("all", mcp.Description("Audit every app in {base_dir}/apps concurrently, reusing cached results for unchanged apps (defaults to false)")),
+		mcp.WithBoolean("verbose", mcp.Description("With all=true, include each app's full audit result (defaults to false)")),
+		mcp.WithBoolean("fix", mcp.Description("Rewrite mechanically fixable viewdef violations in place before auditing (defaults to false)")),
+		mcp.WithBoolean("checkpoint", mcp.Description("Create an app checkpoint before fixing (defaults to false)")),
 	), s.handleAudit)
 
 	// ui_theme
 	s.mcpServer.AddTool(mcp.NewTool("ui_theme",
-		mcp.WithDescription("Theme management: list available themes, get semantic classes, audit app theme usage"),
-		mcp.WithString("action", mcp.Required(), mcp.Description("Action: list, classes, audit")),
-		mcp.WithString("theme", mcp.Description("Theme name (defaults to current theme)")),
-		mcp.WithString("app", mcp.Description("App name (required for audit action)")),
+		mcp.WithDescription("Theme management: list available themes, get semantic classes, audit app theme usage, validate a theme, set the theme globally, per app or per session, preview a theme in connected browsers without saving"),
+		mcp.WithString("action", mcp.Required(), mcp.Description("Action: list, classes, audit, validate, set, preview")),
+		mcp.WithString("theme", mcp.Description("Theme name (defaults to current theme; for set, empty clears an app or session override; for preview, empty ends the preview)")),
+		mcp.WithString("app", mcp.Description("App name (required for audit action and set with scope=app)")),
+		mcp.WithString("scope", mcp.Description("For set: global (default, storage/settings.json), app (override for one app), or session (override for one browser session)")),
+		mcp.WithString("mode", mcp.Description("For set with global scope: fixed, or auto to switch between the theme and its @pair following the browser's prefers-color-scheme")),
+		mcp.WithString("sessionId", mcp.Description("For set and preview: session to override with scope=session, and whose effective theme is reported (defaults to the current session)")),
 	), s.handleTheme)
+
+	// ui_logs
+	// Spec: mcp.md section 5.6
+	s.mcpServer.AddTool(mcp.NewTool("ui_logs",
+		mcp.WithDescription("Query the structured Lua log: print output, mcp:log entries, Lua errors and ui_run failures, with time, level, session and app"),
+		mcp.WithString("app", mcp.Description("Only entries attributed to this app")),
+		mcp.WithString("level", mcp.Description("Minimum level: debug, info, warn, error (defaults to all)")),
+		mcp.WithString("since", mcp.Description("Only entries at or after this RFC 3339 time, or within this duration back from now (e.g. 5m)")),
+		mcp.WithString("sessionId", mcp.Description("Only entries from this vended session ID")),
+		mcp.WithNumber("limit", mcp.Description("Most recent entries to return (defaults to 100)")),
+	), s.handleLogs)
 }
 
 // Spec: mcp.md section 5.1
@@ -334,9 +386,14 @@ func (s *Server) Install(force bool) (*InstallResult, error) {
 	}
 	track("README.md", status)
 
-	// 9. Install themes to {base_dir}/html/themes/
+	// 9. Install themes to {base_dir}/html/themes/, leaving user-installed themes alone
+	userManifest, _ := readManifest(s.baseDir)
 	themeFiles, _ := cli.BundleListFiles("html/themes")
 	for _, bundlePath := range themeFiles {
+		if userManifest != nil && userManifest.isUserThemeFile(bundlePath) {
+			track(bundlePath, "skipped")
+			continue
+		}
 		destPath := filepath.Join(s.baseDir, bundlePath)
 		status, err := s.installFile(bundlePath, destPath, 0644, force, fileInfoMap)
 		if err != nil {
@@ -461,9 +518,11 @@ func compareSemver(a, b string) int {
 }
 
 // InstallManifest records the SHA256 hashes of installed files for smart update.
+// User-installed themes are recorded separately: install and update leave their files alone.
 type InstallManifest struct {
-	Version string            `json:"version"`
-	Files   map[string]string `json:"files"`
+	Version    string              `json:"version"`
+	Files      map[string]string   `json:"files"`
+	UserThemes map[string][]string `json:"user_themes,omitempty"` // Theme name -> installed files
 }
 
 // computeFileHash returns "sha256:<hex>" for the file at path, or error.
@@ -531,8 +590,8 @@ func (s *Server) Update() (*UpdateResult, error) {
 	}
 
 	manifest, err := readManifest(s.baseDir)
-	if err != nil || manifest == nil {
-		// No manifest — fall back to force install
+	if err != nil || manifest == nil || manifest.Version == "" {
+		// No manifest (or only user themes from theme install) — fall back to force install
 		result, err := s.Install(true)
 		if err != nil {
 			return nil, err
@@ -714,9 +773,13 @@ func (s *Server) Update() (*UpdateResult, error) {
 		return nil, err
 	}
 
-	// 9. Themes
+	// 9. Themes (user-installed themes are never updated or reported as conflicts)
 	themeFiles, _ := cli.BundleListFiles("html/themes")
 	for _, bundlePath := range themeFiles {
+		if manifest.isUserThemeFile(bundlePath) {
+			skipped = append(skipped, bundlePath)
+			continue
+		}
 		destPath := filepath.Join(s.baseDir, bundlePath)
 		if err := updateFile(bundlePath, destPath, bundlePath, 0644); err != nil {
 			return nil, err
@@ -764,7 +827,7 @@ func (s *Server) handleAPIUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	_ = args // no arguments needed
-	result, err := s.callMCPHandler(s.handleUpdate, nil)
+	result, err := s.callMCPHandler(r.Context(), "ui_update", s.handleUpdate, nil)
 	apiResponse(w, result, err)
 }
 
@@ -820,13 +883,17 @@ func (s *Server) setupMCPGlobal(vendedID string) error {
 		// Set value to nil initially
 		L.SetField(mcpTable, "value", lua.LNil)
 
+		// mcp.diagnostics - live audit results keyed by app name, updated as app files are saved
+		// CRC: crc-Auditor.md
+		L.SetField(mcpTable, "diagnostics", s.diagnosticsTable(session))
+
 		// mcp.pushState(event) - push event to queue and signal waiters
 		// Spec: mcp.md Section 8.1
 		L.SetField(mcpTable, "pushState", L.NewFunction(func(L *lua.LState) int {
 			event := L.CheckTable(1)
 
 			// Convert Lua table to Go value
-			goEvent := luaTableToGo(event)
+			goEvent := luaToGo(event)
 
 			// Add to queue and signal waiters
 			s.pushStateEvent(vendedID, goEvent)
@@ -889,6 +956,7 @@ func (s *Server) setupMCPGlobal(vendedID string) error {
 				L.Push(lua.LString(fmt.Sprintf("app %s has no global '%s'", appName, globalName)))
 				return 2
 			}
+			s.noteApp(vendedID, appName, false)
 
 			L.Push(appVal)
 			return 1
@@ -924,8 +992,13 @@ func (s *Server) setupMCPGlobal(vendedID string) error {
 			// Assign to mcp.value to display
 			if appVal != lua.LNil {
 				L.SetField(mcpTable, "value", appVal)
+				s.noteApp(vendedID, appName, true)
 			}
 
+			// Switch to the app's theme if it (or the session) overrides the global one
+			// CRC: crc-ThemeManager.md | Seq: seq-theme-set.md
+			s.pushSessionTheme(L, mcpTable, vendedID, appName, false)
+
 			L.Push(lua.LTrue)
 			return 1
 		}))
@@ -934,43 +1007,7 @@ func (s *Server) setupMCPGlobal(vendedID string) error {
 		// Spec: mcp.md Section 4.3
 		L.SetField(mcpTable, "status", L.NewFunction(func(L *lua.LState) int {
 			// Note: Called as mcp:status() but we ignore the self argument
-			result := L.NewTable()
-
-			// Get server state
-			s.mu.RLock()
-			state := s.state
-			url := s.url
-			baseDir := s.baseDir
-			mcpPort := s.mcpPort
-			s.mu.RUnlock()
-
-			L.SetField(result, "base_dir", lua.LString(baseDir))
-
-			// Get bundled version (same logic as handleStatus)
-			isBundled, _ := cli.IsBundled()
-			var bundledContent []byte
-			var err error
-			if isBundled {
-				bundledContent, err = cli.BundleReadFile("README.md")
-			} else {
-				bundledContent, err = os.ReadFile(filepath.Join("install", "README.md"))
-			}
-			if err == nil {
-				if version := parseReadmeVersion(bundledContent); version != "" {
-					L.SetField(result, "version", lua.LString(version))
-				}
-			}
-
-			// Add running-only fields
-			if state == Running {
-				L.SetField(result, "url", lua.LString(url))
-				L.SetField(result, "mcp_port", lua.LNumber(mcpPort))
-				if s.getSessionCount != nil {
-					L.SetField(result, "sessions", lua.LNumber(s.getSessionCount()))
-				}
-			}
-
-			L.Push(result)
+			L.Push(session.GoToLua(toJSONValue(s.Status())))
 			return 1
 		}))
 
@@ -986,6 +1023,47 @@ func (s *Server) setupMCPGlobal(vendedID string) error {
 			return 1
 		}))
 
+		// mcp:setTheme(name [, {app=NAME} | {session=true} | {mode="auto"|"fixed"}]) - select a theme
+		// globally, for an app, or for this session only; an empty name clears an app or session override.
+		// A mode switches global auto (prefers-color-scheme) pairing on or off.
+		// Returns the session's effective theme, or nil, errmsg
+		// CRC: crc-ThemeManager.md | Seq: seq-theme-set.md
+		L.SetField(mcpTable, "setTheme", L.NewFunction(func(L *lua.LState) int {
+			name := L.OptString(2, "")
+			scope, app, mode := ThemeScopeGlobal, "", ""
+			if opts, ok := L.Get(3).(*lua.LTable); ok {
+				if appName, ok := L.GetField(opts, "app").(lua.LString); ok && appName != "" {
+					scope, app = ThemeScopeApp, string(appName)
+				}
+				if lua.LVAsBool(L.GetField(opts, "session")) {
+					scope = ThemeScopeSession
+				}
+				if m, ok := L.GetField(opts, "mode").(lua.LString); ok {
+					mode = string(m)
+				}
+			}
+			if err := s.setTheme(vendedID, scope, app, name, mode); err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+			L.Push(lua.LString(s.pushSessionTheme(L, mcpTable, vendedID, displayedAppName(L, mcpTable), true)))
+			return 1
+		}))
+
+		// mcp:auditAll() - audit every app, returns summary rows (cached by file hash)
+		// CRC: crc-Auditor.md
+		L.SetField(mcpTable, "auditAll", L.NewFunction(func(L *lua.LState) int {
+			project, err := AuditAllApps(s.baseDir, s.auditCache, false)
+			if err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+			L.Push(session.GoToLua(toJSONValue(project)))
+			return 1
+		}))
+
 		// mcp:renderMarkdown(text) - convert markdown text to HTML fragment
 		// CRC: crc-MCPServer.md | R147
 		L.SetField(mcpTable, "renderMarkdown", L.NewFunction(func(L *lua.LState) int {
@@ -1003,6 +1081,10 @@ func (s *Server) setupMCPGlobal(vendedID string) error {
 		// CRC: crc-MCPSubscribe.md
 		s.registerSubscribeMethod(vendedID, mcpTable)
 
+		// Record print output and mcp:log(level, ...) in log/lua.jsonl
+		// CRC: crc-LuaLog.md
+		s.registerLogMethods(vendedID, mcpTable)
+
 		// Load mcp.lua if it exists to extend the mcp global
 		// Use DirectRequireLuaFile to register for hot-loading
 		// Spec: mcp.md Section 4.3 "Extension via mcp.lua"
@@ -1088,58 +1170,18 @@ func toUpper(r rune) rune {
 	return r
 }
 
-// luaTableToGo converts a Lua table to a Go map/slice.
-func luaTableToGo(tbl *lua.LTable) interface{} {
-	// Check if it's an array (sequential integer keys starting at 1)
-	isArray := true
-	maxIdx := 0
-	tbl.ForEach(func(k, v lua.LValue) {
-		if kn, ok := k.(lua.LNumber); ok {
-			idx := int(kn)
-			if idx > maxIdx {
-				maxIdx = idx
-			}
-		} else {
-			isArray = false
-		}
-	})
-
-	if isArray && maxIdx > 0 {
-		arr := make([]interface{}, maxIdx)
-		tbl.ForEach(func(k, v lua.LValue) {
-			if kn, ok := k.(lua.LNumber); ok {
-				arr[int(kn)-1] = luaValueToGo(v)
-			}
-		})
-		return arr
-	}
-
-	// It's a map
-	m := make(map[string]interface{})
-	tbl.ForEach(func(k, v lua.LValue) {
-		if ks, ok := k.(lua.LString); ok {
-			m[string(ks)] = luaValueToGo(v)
-		}
-	})
-	return m
-}
-
-// luaValueToGo converts a Lua value to Go.
-func luaValueToGo(v lua.LValue) interface{} {
-	switch val := v.(type) {
-	case lua.LString:
-		return string(val)
-	case lua.LNumber:
-		return float64(val)
-	case lua.LBool:
-		return bool(val)
-	case *lua.LTable:
-		return luaTableToGo(val)
-	case *lua.LNilType:
+// toJSONValue round-trips a Go value through JSON so it contains only maps, slices and scalars.
+// Used to hand Go structs to session.GoToLua.
+func toJSONValue(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
 		return nil
-	default:
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
 		return nil
 	}
+	return out
 }
 
 // Spec: mcp.md
@@ -1153,7 +1195,9 @@ func (s *Server) handleOpenBrowser(ctx context.Context, request mcp.CallToolRequ
 
 	sessionID, ok := args["sessionId"].(string)
 	if !ok || sessionID == "" {
+		s.mu.RLock()
 		sessionID = s.currentVendedID
+		s.mu.RUnlock()
 	}
 	if sessionID == "" {
 		return mcp.NewToolResultError("no active session - server may not have started correctly"), nil
@@ -1172,9 +1216,12 @@ func (s *Server) handleOpenBrowser(ctx context.Context, request mcp.CallToolRequ
 		conserve = c
 	}
 
+	force, _ := args["force"].(bool)
+
 	s.mu.RLock()
 	baseURL := s.url
 	state := s.state
+	baseDir := s.baseDir
 	s.mu.RUnlock()
 
 	if state != Running {
@@ -1191,23 +1238,33 @@ func (s *Server) handleOpenBrowser(ctx context.Context, request mcp.CallToolRequ
 		}
 	}
 
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "linux":
-		cmd = exec.Command("xdg-open", fullURL)
-	case "darwin":
-		cmd = exec.Command("open", fullURL)
-	case "windows":
-		cmd = exec.Command("cmd", "/c", "start", fullURL)
-	default:
-		return mcp.NewToolResultError(fmt.Sprintf("unsupported platform: %s", runtime.GOOS)), nil
+	// A connected browser already shows the session; conserve mode would only notify it
+	result := OpenBrowserResult{URL: fullURL, Browsers: s.connectedBrowsers(sessionID)}
+	if result.Browsers > 0 && !force {
+		result.Reason = "a browser is already connected; use force to open another"
+		jsonResult, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonResult)), nil
 	}
 
+	// Headless servers and containers have no opener: hand the URL back instead of failing
+	opener, ok := findBrowserOpener(GetBrowserSetting(baseDir), runtime.GOOS, os.Getenv, exec.LookPath)
+	if !ok {
+		result.Reason = "no opener available: no desktop session; set $BROWSER or \"browser\" in storage/settings.json, or open the URL from another machine"
+		jsonResult, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonResult)), nil
+	}
+	argv := opener.command(fullURL)
+	result.Command = argv[0]
+	result.Source = opener.source
+	cmd := exec.Command(argv[0], argv[1:]...)
 	if err := cmd.Start(); err != nil {
-		return mcp.NewToolResultError(fmt.Sprintf("failed to open browser: %v", err)), nil
+		return mcp.NewToolResultError(fmt.Sprintf("failed to open browser with %s (from %s): %v; open %s manually", argv[0], opener.source, err, fullURL)), nil
 	}
+	go cmd.Wait()
 
-	return mcp.NewToolResultText(fmt.Sprintf("Opened %s", fullURL)), nil
+	result.Opened = true
+	jsonResult, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonResult)), nil
 }
 
 // Spec: mcp.md
@@ -1237,70 +1294,132 @@ func (s *Server) handleRun(ctx context.Context, request mcp.CallToolRequest) (*m
 		return mcp.NewToolResultError(fmt.Sprintf("session %s not found", sessionID)), nil
 	}
 
+	timeout, err := runTimeout(args["timeout"])
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	profileName, _ := args["profile"].(string)
+	profile, err := ParseRunProfile(profileName)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	// The Lua VM checks the context between instructions, so a runaway loop stops at the
+	// deadline or when the MCP request is cancelled instead of holding the session executor
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
 	// Use SafeExecuteInSession (sets Lua context, triggers afterBatch, recovers panics)
+	var run RunResult
 	result, err := s.SafeExecuteInSession(sessionID, func() (interface{}, error) {
-		return session.LoadCodeDirect("mcp-run", code)
+		L := session.State
+		var result interface{}
+		stdout, elapsed, err := s.inLuaContext(runCtx, L, sessionID, func() error {
+			// The code runs as a function that keeps its result as a Lua value for luaToGo;
+			// sandboxed profiles run it in the sandbox's environment
+			var sb *sandbox
+			if profile != ProfileFull {
+				sb = newSandbox(L, profile)
+			}
+			value := lua.LValue(lua.LNil)
+			L.SetGlobal(profileRunGlobal, L.NewFunction(captureRun(sb, &value)))
+			defer L.SetGlobal(profileRunGlobal, lua.LNil)
+			if _, err := session.LoadCodeDirect("mcp-run", profileCode(code)); err != nil {
+				return err
+			}
+			result = luaToGo(value)
+			return nil
+		})
+		run.Stdout, run.DurationMS = stdout, durationMS(elapsed)
+		return result, err
 	})
 
 	if err != nil {
-		return mcp.NewToolResultError(fmt.Sprintf("execution failed: %v", err)), nil
-	}
-
-	// Marshal result
-	jsonResult, err := json.MarshalIndent(result, "", "  ")
-	if err != nil {
-		// Fallback for non-serializable results
-		fallback := map[string]string{
-			"non-json": fmt.Sprintf("%v", result),
+		switch {
+		case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+			run.Error = fmt.Sprintf("execution failed: timed out after %s", timeout)
+		case errors.Is(ctx.Err(), context.Canceled):
+			run.Error = "execution failed: cancelled by the client"
+		default:
+			message, traceback := luaErrorParts(err)
+			run.Error, run.Traceback = "execution failed: "+message, traceback
 		}
-		jsonResult, _ = json.Marshal(fallback)
+		s.logLua(LogEntry{Level: LogError, Session: sessionID, Source: logSourceRun, Message: run.Error})
+		jsonResult, _ := json.MarshalIndent(run, "", "  ")
+		return mcp.NewToolResultError(string(jsonResult)), nil
 	}
 
+	run.Result = result
+	jsonResult, _ := json.MarshalIndent(run, "", "  ")
 	return mcp.NewToolResultText(string(jsonResult)), nil
 }
 
-// CRC: crc-MCPTool.md
-// Spec: mcp.md (section 5.5)
-func (s *Server) handleStatus(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	s.mu.RLock()
-	state := s.state
-	url := s.url
-	baseDir := s.baseDir
-	mcpPort := s.mcpPort
-	s.mu.RUnlock()
-
-	result := map[string]interface{}{}
+// RunResult is the ui_run response
+type RunResult struct {
+	Result     interface{} `json:"result"`              // Return value, null on error
+	Stdout     string      `json:"stdout"`              // print and io.write output during the call
+	Error      string      `json:"error,omitempty"`     // Error message, without the traceback
+	Traceback  string      `json:"traceback,omitempty"` // Lua stack traceback of the error
+	DurationMS float64     `json:"duration_ms"`         // Execution time in the session executor
+}
 
-	// Always include bundled version from README.md
-	// Spec: mcp.md section 5.5 - version is always present
-	isBundled, _ := cli.IsBundled()
-	var bundledContent []byte
-	var err error
-	if isBundled {
-		bundledContent, err = cli.BundleReadFile("README.md")
+// luaErrorParts splits a Lua error into its message and stack traceback
+func luaErrorParts(err error) (message, traceback string) {
+	var apiErr *lua.ApiError
+	if errors.As(err, &apiErr) && apiErr.Object != nil {
+		message, traceback = apiErr.Object.String(), apiErr.StackTrace
 	} else {
-		// Development mode: read from install/README.md
-		bundledContent, err = os.ReadFile(filepath.Join("install", "README.md"))
+		message = err.Error()
 	}
-	if err == nil {
-		if version := parseReadmeVersion(bundledContent); version != "" {
-			result["version"] = version
+	if i := strings.Index(message, "\nstack traceback:"); i != -1 {
+		if traceback == "" {
+			traceback = message[i+1:]
 		}
+		message = message[:i]
 	}
+	return message, strings.TrimSpace(traceback)
+}
 
-	// Include base_dir when set
-	if baseDir != "" {
-		result["base_dir"] = baseDir
-	}
+// Default and maximum ui_run execution time
+const (
+	defaultRunTimeout = 30 * time.Second
+	maxRunTimeout     = 10 * time.Minute
+)
 
-	// Include url, mcp_port, and sessions when running
-	if state == Running {
-		result["url"] = url
-		result["mcp_port"] = mcpPort
-		if s.getSessionCount != nil {
-			result["sessions"] = s.getSessionCount()
+// runTimeout reads the ui_run timeout argument: seconds as a number or numeric string
+func runTimeout(arg interface{}) (time.Duration, error) {
+	var seconds float64
+	switch v := arg.(type) {
+	case nil:
+		return defaultRunTimeout, nil
+	case float64:
+		seconds = v
+	case string:
+		if v == "" {
+			return defaultRunTimeout, nil
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("timeout must be a number of seconds")
 		}
+		seconds = n
+	default:
+		return 0, fmt.Errorf("timeout must be a number of seconds")
 	}
+	if seconds <= 0 {
+		return 0, fmt.Errorf("timeout must be positive")
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > maxRunTimeout {
+		timeout = maxRunTimeout
+	}
+	return timeout, nil
+}
+
+// CRC: crc-MCPTool.md
+// Spec: mcp.md (section 5.5)
+func (s *Server) handleStatus(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	result := s.Status()
 
 	jsonResult, err := json.MarshalIndent(result, "", "  ")
 	if err != nil {
@@ -1383,15 +1502,51 @@ func (s *Server) handleAudit(ctx context.Context, request mcp.CallToolRequest) (
 		return mcp.NewToolResultError("arguments must be a map"), nil
 	}
 
+	// All-apps mode returns a summary table instead of a single app's result
+	if all, _ := args["all"].(bool); all {
+		verbose, _ := args["verbose"].(bool)
+		project, err := AuditAllApps(baseDir, s.auditCache, verbose)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("audit failed: %v", err)), nil
+		}
+		jsonResult, err := json.MarshalIndent(project, "", "  ")
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to marshal result: %v", err)), nil
+		}
+		return mcp.NewToolResultText(string(jsonResult)), nil
+	}
+
 	name, ok := args["name"].(string)
 	if !ok || name == "" {
-		return mcp.NewToolResultError("name must be a non-empty string"), nil
+		return mcp.NewToolResultError("name must be a non-empty string (or use all=true)"), nil
+	}
+
+	fix, _ := args["fix"].(bool)
+	checkpoint, _ := args["checkpoint"].(bool)
+
+	var fixResult *FixResult
+	if fix {
+		var checkpointMsg string
+		if checkpoint {
+			msg, err := CreateCheckpoint(baseDir, name, "before audit fix")
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("checkpoint failed: %v", err)), nil
+			}
+			checkpointMsg = msg
+		}
+		fixed, err := FixApp(baseDir, name)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("fix failed: %v", err)), nil
+		}
+		fixed.Checkpoint = checkpointMsg
+		fixResult = fixed
 	}
 
 	result, err := AuditApp(baseDir, name)
 	if err != nil {
 		return mcp.NewToolResultError(fmt.Sprintf("audit failed: %v", err)), nil
 	}
+	result.Fix = fixResult
 
 	jsonResult, err := json.MarshalIndent(result, "", "  ")
 	if err != nil {
@@ -1453,8 +1608,54 @@ func (s *Server) handleTheme(ctx context.Context, request mcp.CallToolRequest) (
 			return mcp.NewToolResultError(fmt.Sprintf("auditing theme: %v", err)), nil
 		}
 
+	case "validate":
+		// CRC: crc-ThemeManager.md | Seq: seq-theme-validate.md
+		if theme == "" {
+			theme = GetCurrentTheme(baseDir)
+		}
+		result, err = ValidateTheme(baseDir, theme)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("validating theme: %v", err)), nil
+		}
+
+	case "set":
+		// CRC: crc-ThemeManager.md | Seq: seq-theme-set.md
+		scope, _ := args["scope"].(string)
+		if scope == "" {
+			scope = ThemeScopeGlobal
+		}
+		mode, _ := args["mode"].(string)
+		if theme == "" && mode == "" && scope == ThemeScopeGlobal {
+			return mcp.NewToolResultError("theme is required for global scope"), nil
+		}
+		sessionID, _ := args["sessionId"].(string)
+		if sessionID == "" {
+			sessionID = s.currentVendedID
+		}
+		if err := s.setTheme(sessionID, scope, app, theme, mode); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("setting theme: %v", err)), nil
+		}
+		// Switch connected browsers now rather than on their next app change
+		setResult := ThemeSetResult{Scope: scope, Theme: theme, App: app, Effective: s.broadcastTheme(sessionID, "")}
+		if setResult.Effective == "" {
+			setResult.Effective = s.effectiveTheme(sessionID, app)
+		}
+		result = setResult
+
+	case "preview":
+		// CRC: crc-ThemeManager.md | Seq: seq-theme-set.md
+		sessionID, _ := args["sessionId"].(string)
+		if sessionID == "" {
+			sessionID = s.currentVendedID
+		}
+		if theme != "" && !themeExists(baseDir, theme) {
+			return mcp.NewToolResultError(fmt.Sprintf("theme %s not found", theme)), nil
+		}
+		// An empty theme ends the preview, returning browsers to their effective themes
+		result = ThemeSetResult{Scope: themeScopePreview, Theme: theme, Effective: s.broadcastTheme(sessionID, theme)}
+
 	default:
-		return mcp.NewToolResultError(fmt.Sprintf("unknown action: %s (use: list, classes, audit)", action)), nil
+		return mcp.NewToolResultError(fmt.Sprintf("unknown action: %s (use: list, classes, audit, validate, set, preview)", action)), nil
 	}
 
 	jsonResult, err := json.MarshalIndent(result, "", "  ")
@@ -1465,6 +1666,43 @@ func (s *Server) handleTheme(ctx context.Context, request mcp.CallToolRequest) (
 	return mcp.NewToolResultText(string(jsonResult)), nil
 }
 
+// CRC: crc-LuaLog.md
+// Spec: mcp.md (section 5.6)
+// Sequence: seq-lua-log.md
+func (s *Server) handleLogs(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args, _ := request.Params.Arguments.(map[string]interface{})
+	stringArg := func(name string) string {
+		value, _ := args[name].(string)
+		return value
+	}
+
+	s.mu.RLock()
+	log := s.luaLog
+	s.mu.RUnlock()
+	if log == nil {
+		return mcp.NewToolResultError("server not configured - no Lua log"), nil
+	}
+
+	since, err := ParseLogSince(stringArg("since"), time.Now())
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	query := LogQuery{App: stringArg("app"), Level: stringArg("level"), Session: stringArg("sessionId"), Since: since}
+	switch limit := args["limit"].(type) {
+	case float64:
+		query.Limit = int(limit)
+	case string:
+		query.Limit, _ = strconv.Atoi(limit)
+	}
+
+	entries, err := log.Query(query)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	jsonResult, _ := json.MarshalIndent(entries, "", "  ")
+	return mcp.NewToolResultText(string(jsonResult)), nil
+}
+
 // HTTP Tool API handlers (Spec 2.5)
 // These wrap the MCP tool handlers for HTTP access by spawned agents.
 
@@ -1505,14 +1743,19 @@ func parseJSONBody(r *http.Request) (map[string]interface{}, error) {
 	return args, nil
 }
 
-// callMCPHandler invokes an MCP handler and extracts the result.
+// callMCPHandler invokes the named tool's MCP handler and extracts the result.
+// ctx is the HTTP request's context, so a disconnecting client cancels long-running handlers.
+// The call counts in the tool's metrics, as MCP calls do.
 func (s *Server) callMCPHandler(
+	ctx context.Context,
+	name string,
 	handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error),
 	args map[string]interface{},
 ) (interface{}, error) {
 	req := mcp.CallToolRequest{}
+	req.Params.Name = name
 	req.Params.Arguments = args
-	result, err := handler(context.Background(), req)
+	result, err := s.metrics.toolMiddleware(handler)(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -1536,7 +1779,7 @@ func (s *Server) handleAPIStatus(w http.ResponseWriter, r *http.Request) {
 		apiError(w, http.StatusMethodNotAllowed, "GET required")
 		return
 	}
-	result, err := s.callMCPHandler(s.handleStatus, nil)
+	result, err := s.callMCPHandler(r.Context(), "ui_status", s.handleStatus, nil)
 	apiResponse(w, result, err)
 }
 
@@ -1551,7 +1794,15 @@ func (s *Server) handleAPIRun(w http.ResponseWriter, r *http.Request) {
 		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
-	result, err := s.callMCPHandler(s.handleRun, args)
+	// A token limits the call to its profile or a stricter one
+	name, _ := args["profile"].(string)
+	profile, status, err := s.tokenProfile(r, name)
+	if err != nil {
+		apiError(w, status, err.Error())
+		return
+	}
+	args["profile"] = string(profile)
+	result, err := s.callMCPHandler(r.Context(), "ui_run", s.handleRun, args)
 	apiResponse(w, result, err)
 }
 
@@ -1566,7 +1817,7 @@ func (s *Server) handleAPIDisplay(w http.ResponseWriter, r *http.Request) {
 		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
-	result, err := s.callMCPHandler(s.handleDisplay, args)
+	result, err := s.callMCPHandler(r.Context(), "ui_display", s.handleDisplay, args)
 	apiResponse(w, result, err)
 }
 
@@ -1581,7 +1832,7 @@ func (s *Server) handleAPIConfigure(w http.ResponseWriter, r *http.Request) {
 		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
-	result, err := s.callMCPHandler(s.handleConfigure, args)
+	result, err := s.callMCPHandler(r.Context(), "ui_configure", s.handleConfigure, args)
 	apiResponse(w, result, err)
 }
 
@@ -1596,7 +1847,7 @@ func (s *Server) handleAPIInstall(w http.ResponseWriter, r *http.Request) {
 		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
-	result, err := s.callMCPHandler(s.handleInstall, args)
+	result, err := s.callMCPHandler(r.Context(), "ui_install", s.handleInstall, args)
 	apiResponse(w, result, err)
 }
 
@@ -1611,7 +1862,7 @@ func (s *Server) handleAPIOpenBrowser(w http.ResponseWriter, r *http.Request) {
 		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
-	result, err := s.callMCPHandler(s.handleOpenBrowser, args)
+	result, err := s.callMCPHandler(r.Context(), "ui_open_browser", s.handleOpenBrowser, args)
 	apiResponse(w, result, err)
 }
 
@@ -1626,7 +1877,7 @@ func (s *Server) handleAPIAudit(w http.ResponseWriter, r *http.Request) {
 		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
-	result, err := s.callMCPHandler(s.handleAudit, args)
+	result, err := s.callMCPHandler(r.Context(), "ui_audit", s.handleAudit, args)
 	apiResponse(w, result, err)
 }
 
@@ -1641,7 +1892,23 @@ func (s *Server) handleAPITheme(w http.ResponseWriter, r *http.Request) {
 		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
-	result, err := s.callMCPHandler(s.handleTheme, args)
+	result, err := s.callMCPHandler(r.Context(), "ui_theme", s.handleTheme, args)
+	apiResponse(w, result, err)
+}
+
+// handleAPILogs handles GET /api/logs?app=&level=&since=&sessionId=&limit=
+func (s *Server) handleAPILogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, "GET required")
+		return
+	}
+	args := make(map[string]interface{})
+	for _, name := range []string{"app", "level", "since", "sessionId", "limit"} {
+		if value := r.URL.Query().Get(name); value != "" {
+			args[name] = value
+		}
+	}
+	result, err := s.callMCPHandler(r.Context(), "ui_logs", s.handleLogs, args)
 	apiResponse(w, result, err)
 }
 
@@ -4,16 +4,21 @@ package mcp
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
+	lua "github.com/yuin/gopher-lua"
 	"github.com/zot/ui-engine/cli"
 )
 
 // createTestServer creates a server configured for testing with the given baseDir
-// This is a minimal server without Lua session support (for install/ClearLogs tests)
+// This is a minimal server without Lua session support (for install/RotateLogs tests)
 func createTestServer(t *testing.T, baseDir string) *Server {
 	t.Helper()
 	cfg := cli.DefaultConfig()
@@ -324,13 +329,13 @@ func TestInstallForceOverwrites(t *testing.T) {
 }
 
 // ============================================================================
-// ClearLogs Tests
-// Test Design: test-MCP.md (ClearLogs section)
-// Spec: mcp.md Section 5.1 - ui_configure clears logs
+// RotateLogs Tests
+// Test Design: test-MCP.md (RotateLogs section)
+// Spec: mcp.md Section 5.1 - ui_configure rotates logs
 // ============================================================================
 
-// TestClearLogsClearsAllFiles tests that ClearLogs removes all files in log directory
-func TestClearLogsClearsAllFiles(t *testing.T) {
+// TestRotateLogsArchivesFiles tests that a forced rotation archives each log as NAME.1
+func TestRotateLogsArchivesFiles(t *testing.T) {
 	tempDir := t.TempDir()
 	logDir := filepath.Join(tempDir, "log")
 
@@ -339,31 +344,101 @@ func TestClearLogsClearsAllFiles(t *testing.T) {
 	os.WriteFile(filepath.Join(logDir, "mcp.log"), []byte("go log content"), 0644)
 	os.WriteFile(filepath.Join(logDir, "lua.log"), []byte("lua log content"), 0644)
 	os.WriteFile(filepath.Join(logDir, "lua-err.log"), []byte("lua error content"), 0644)
+	os.WriteFile(filepath.Join(logDir, "notes.txt"), []byte("not a log"), 0644)
 
 	// Create server
 	s := createTestServer(t, tempDir)
 
-	// Verify files exist before clearing
-	entries, _ := os.ReadDir(logDir)
-	if len(entries) != 3 {
-		t.Fatalf("Expected 3 log files before clearing, got %d", len(entries))
+	// Rotate logs
+	err := s.RotateLogs(true)
+	if err != nil {
+		t.Fatalf("RotateLogs returned error: %v", err)
 	}
 
-	// Clear logs
-	err := s.ClearLogs()
-	if err != nil {
-		t.Fatalf("ClearLogs returned error: %v", err)
+	// Verify logs were archived with their content, and other files left alone
+	for name, content := range map[string]string{"mcp.log": "go log content", "lua.log": "lua log content", "lua-err.log": "lua error content"} {
+		if _, err := os.Stat(filepath.Join(logDir, name)); err == nil {
+			t.Errorf("%s should have been rotated", name)
+		}
+		if data, err := os.ReadFile(filepath.Join(logDir, name+".1")); err != nil || string(data) != content {
+			t.Errorf("%s.1 = %q, %v; want %q", name, data, err, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(logDir, "notes.txt")); err != nil {
+		t.Error("notes.txt should not be touched")
+	}
+}
+
+// TestRotateLogsKeepsGenerations tests that archives shift up and those past the limit are dropped
+func TestRotateLogsKeepsGenerations(t *testing.T) {
+	tempDir := t.TempDir()
+	logDir := filepath.Join(tempDir, "log")
+	os.MkdirAll(logDir, 0755)
+	os.MkdirAll(filepath.Join(tempDir, "storage"), 0755)
+	os.WriteFile(filepath.Join(tempDir, "storage", "settings.json"), []byte(`{"logRotation": {"generations": 2}}`), 0644)
+
+	s := createTestServer(t, tempDir)
+	for _, content := range []string{"first", "second", "third"} {
+		os.WriteFile(filepath.Join(logDir, "mcp.log"), []byte(content), 0644)
+		if err := s.RotateLogs(true); err != nil {
+			t.Fatalf("RotateLogs returned error: %v", err)
+		}
+	}
+
+	for name, want := range map[string]string{"mcp.log.1": "third", "mcp.log.2": "second"} {
+		if data, err := os.ReadFile(filepath.Join(logDir, name)); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v; want %q", name, data, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(logDir, "mcp.log.3")); err == nil {
+		t.Error("mcp.log.3 is past the generation limit and should not exist")
+	}
+}
+
+// TestRotateLogsBySize tests that an unforced rotation only archives logs past their limits
+func TestRotateLogsBySize(t *testing.T) {
+	tempDir := t.TempDir()
+	logDir := filepath.Join(tempDir, "log")
+	os.MkdirAll(logDir, 0755)
+	os.MkdirAll(filepath.Join(tempDir, "storage"), 0755)
+	os.WriteFile(filepath.Join(tempDir, "storage", "settings.json"), []byte(`{"logRotation": {"maxSizeMB": 1}}`), 0644)
+	os.WriteFile(filepath.Join(logDir, "lua.log"), make([]byte, 2*1024*1024), 0644)
+	os.WriteFile(filepath.Join(logDir, "mcp.log"), []byte("small"), 0644)
+
+	s := createTestServer(t, tempDir)
+	if err := s.RotateLogs(false); err != nil {
+		t.Fatalf("RotateLogs returned error: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(logDir, "lua.log.1")); err != nil {
+		t.Error("lua.log is past maxSizeMB and should have been rotated")
 	}
+	if _, err := os.Stat(filepath.Join(logDir, "mcp.log.1")); err == nil {
+		t.Error("mcp.log is under the limits and should not have been rotated")
+	}
+}
 
-	// Verify all files were removed
-	entries, _ = os.ReadDir(logDir)
-	if len(entries) != 0 {
-		t.Errorf("Expected 0 log files after clearing, got %d", len(entries))
+// TestRotationDueByAge tests the age limit, measured from when the log was started
+func TestRotationDueByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mcp.log")
+	os.WriteFile(path, []byte("content"), 0644)
+	info, _ := os.Stat(path)
+
+	now := time.Now()
+	rot := LogRotation{MaxAgeHours: 24}
+	if rotationDue(info, now.Add(-time.Hour), now, rot) {
+		t.Error("an hour-old log should not be due")
+	}
+	if !rotationDue(info, now.Add(-25*time.Hour), now, rot) {
+		t.Error("a 25-hour-old log should be due")
+	}
+	if rotationDue(info, now.Add(-25*time.Hour), now, LogRotation{}) {
+		t.Error("a zero age limit should never be due")
 	}
 }
 
-// TestClearLogsCallsCallback tests that ClearLogs invokes the onClearLogs callback
-func TestClearLogsCallsCallback(t *testing.T) {
+// TestRotateLogsCallsCallback tests that RotateLogs invokes the onClearLogs callback
+func TestRotateLogsCallsCallback(t *testing.T) {
 	tempDir := t.TempDir()
 	logDir := filepath.Join(tempDir, "log")
 
@@ -380,10 +455,10 @@ func TestClearLogsCallsCallback(t *testing.T) {
 		callbackCalled = true
 	})
 
-	// Clear logs
-	err := s.ClearLogs()
+	// Rotate logs
+	err := s.RotateLogs(true)
 	if err != nil {
-		t.Fatalf("ClearLogs returned error: %v", err)
+		t.Fatalf("RotateLogs returned error: %v", err)
 	}
 
 	// Verify callback was called
@@ -392,23 +467,23 @@ func TestClearLogsCallsCallback(t *testing.T) {
 	}
 }
 
-// TestClearLogsHandlesMissingDirectory tests that ClearLogs handles missing log directory
-func TestClearLogsHandlesMissingDirectory(t *testing.T) {
+// TestRotateLogsHandlesMissingDirectory tests that RotateLogs handles missing log directory
+func TestRotateLogsHandlesMissingDirectory(t *testing.T) {
 	tempDir := t.TempDir()
 	// Don't create log directory
 
 	// Create server
 	s := createTestServer(t, tempDir)
 
-	// Clear logs should not error on missing directory
-	err := s.ClearLogs()
+	// Rotating logs should not error on missing directory
+	err := s.RotateLogs(true)
 	if err != nil {
-		t.Errorf("ClearLogs should not error on missing directory: %v", err)
+		t.Errorf("RotateLogs should not error on missing directory: %v", err)
 	}
 }
 
-// TestClearLogsSkipsSubdirectories tests that ClearLogs only removes files, not subdirs
-func TestClearLogsSkipsSubdirectories(t *testing.T) {
+// TestRotateLogsSkipsSubdirectories tests that RotateLogs leaves subdirectories alone
+func TestRotateLogsSkipsSubdirectories(t *testing.T) {
 	tempDir := t.TempDir()
 	logDir := filepath.Join(tempDir, "log")
 	subDir := filepath.Join(logDir, "subdir")
@@ -421,26 +496,23 @@ func TestClearLogsSkipsSubdirectories(t *testing.T) {
 	// Create server
 	s := createTestServer(t, tempDir)
 
-	// Clear logs
-	err := s.ClearLogs()
+	// Rotate logs
+	err := s.RotateLogs(true)
 	if err != nil {
-		t.Fatalf("ClearLogs returned error: %v", err)
+		t.Fatalf("RotateLogs returned error: %v", err)
 	}
 
-	// Verify file was removed but subdirectory remains
+	// Verify file was rotated but subdirectory remains
 	if _, err := os.Stat(filepath.Join(logDir, "mcp.log")); err == nil {
-		t.Error("mcp.log should have been removed")
-	}
-	if _, err := os.Stat(subDir); err != nil {
-		t.Error("Subdirectory should not be removed")
+		t.Error("mcp.log should have been rotated")
 	}
 	if _, err := os.Stat(filepath.Join(subDir, "nested.log")); err != nil {
-		t.Error("Files in subdirectory should not be removed")
+		t.Error("Files in subdirectory should not be touched")
 	}
 }
 
-// TestClearLogsNoCallbackIfNotSet tests that ClearLogs works without callback
-func TestClearLogsNoCallbackIfNotSet(t *testing.T) {
+// TestRotateLogsNoCallbackIfNotSet tests that RotateLogs works without callback
+func TestRotateLogsNoCallbackIfNotSet(t *testing.T) {
 	tempDir := t.TempDir()
 	logDir := filepath.Join(tempDir, "log")
 
@@ -451,15 +523,57 @@ func TestClearLogsNoCallbackIfNotSet(t *testing.T) {
 	// Create server without setting callback
 	s := createTestServer(t, tempDir)
 
-	// Clear logs should work without panic
-	err := s.ClearLogs()
+	// Rotating logs should work without panic
+	err := s.RotateLogs(true)
 	if err != nil {
-		t.Fatalf("ClearLogs returned error: %v", err)
+		t.Fatalf("RotateLogs returned error: %v", err)
 	}
 
-	// Verify file was removed
+	// Verify file was rotated
 	if _, err := os.Stat(filepath.Join(logDir, "mcp.log")); err == nil {
-		t.Error("mcp.log should have been removed")
+		t.Error("mcp.log should have been rotated")
+	}
+}
+
+// TestConfigureNewDirectoryMovesLogs tests that reconfiguring to another directory points the
+// Lua logs there and leaves the old directory's logs closed
+func TestConfigureNewDirectoryMovesLogs(t *testing.T) {
+	oldDir := t.TempDir()
+	newDir := t.TempDir()
+	for _, dir := range []string{oldDir, newDir} {
+		os.WriteFile(filepath.Join(dir, "README.md"), []byte("# Test\n"), 0644)
+	}
+
+	s := createTestServer(t, "")
+	t.Cleanup(func() {
+		if s.stopLogRotation != nil {
+			s.stopLogRotation()
+		}
+		if s.luaLog != nil {
+			s.luaLog.Close()
+		}
+	})
+	if err := s.Configure(oldDir); err != nil {
+		t.Fatalf("Configure(old) returned error: %v", err)
+	}
+	os.Remove(luaLogPath(oldDir))
+
+	if err := s.Configure(newDir); err != nil {
+		t.Fatalf("Configure(new) returned error: %v", err)
+	}
+	if want := filepath.Join(newDir, "log", "lua.log"); s.logPath != want {
+		t.Errorf("logPath = %q, want %q", s.logPath, want)
+	}
+	if want := filepath.Join(newDir, "log", "lua-err.log"); s.errPath != want {
+		t.Errorf("errPath = %q, want %q", s.errPath, want)
+	}
+	if _, err := os.Stat(luaLogPath(oldDir)); err == nil {
+		t.Error("the old directory's lua.jsonl should not be reopened")
+	}
+	s.logLua(LogEntry{Level: LogInfo, Message: "moved"})
+	data, err := os.ReadFile(luaLogPath(newDir))
+	if err != nil || !strings.Contains(string(data), "moved") {
+		t.Errorf("new lua.jsonl should hold the entry, got %q (%v)", data, err)
 	}
 }
 
@@ -483,10 +597,13 @@ func TestRunExecuteCode(t *testing.T) {
 		t.Fatalf("handleRun returned tool error: %v", result.Content)
 	}
 
-	// Check result contains "2"
-	text := getTextContent(result)
-	if text != "2" {
-		t.Errorf("Expected result '2', got %q", text)
+	// Check result is 2 and the duration is recorded
+	run := getRunResult(t, result)
+	if run.Result != float64(2) {
+		t.Errorf("Expected result 2, got %v", run.Result)
+	}
+	if run.DurationMS <= 0 || run.Error != "" {
+		t.Errorf("Expected a duration and no error, got %+v", run)
 	}
 }
 
@@ -505,9 +622,8 @@ func TestRunSessionAccess(t *testing.T) {
 	}
 
 	// Session should exist
-	text := getTextContent(result)
-	if text != "true" {
-		t.Errorf("Expected session to exist (true), got %q", text)
+	if run := getRunResult(t, result); run.Result != true {
+		t.Errorf("Expected session to exist (true), got %v", run.Result)
 	}
 }
 
@@ -525,28 +641,22 @@ func TestRunJSONMarshalling(t *testing.T) {
 		t.Fatalf("handleRun returned tool error: %v", result.Content)
 	}
 
-	// Result should be JSON object
-	text := getTextContent(result)
-	if text == "" {
-		t.Fatal("Expected non-empty result")
+	// Result should be a JSON object
+	obj, ok := getRunResult(t, result).Result.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected a JSON object, got %q", getTextContent(result))
 	}
-	// Should contain the keys (order may vary in JSON)
-	if !contains(text, `"a"`) || !contains(text, `"b"`) {
-		t.Errorf("Expected JSON with keys 'a' and 'b', got %q", text)
-	}
-	if !contains(text, "1") || !contains(text, `"text"`) {
-		t.Errorf("Expected JSON with values 1 and 'text', got %q", text)
+	if obj["a"] != float64(1) || obj["b"] != "text" {
+		t.Errorf("Expected a=1 and b='text', got %v", obj)
 	}
 }
 
-// TestRunNonJSONResult tests that Lua functions convert to null in JSON
-// Note: Lua functions are converted to nil in Go, which marshals to "null"
-// The non-json wrapper is only triggered for Go types that fail JSON marshalling
+// TestRunNonJSONResult tests that Lua functions convert to $function markers in JSON
 func TestRunNonJSONResult(t *testing.T) {
 	s, cleanup := createTestServerWithSession(t)
 	defer cleanup()
 
-	// Return a function - converts to nil in Go, marshals to "null"
+	// Return a function - converts to {"$function": "SOURCE:LINE"}
 	result, err := callHandleRun(s, "return function() end")
 	if err != nil {
 		t.Fatalf("handleRun returned error: %v", err)
@@ -555,10 +665,20 @@ func TestRunNonJSONResult(t *testing.T) {
 		t.Fatalf("handleRun returned tool error: %v", result.Content)
 	}
 
-	// Functions convert to null (nil in Go)
-	text := getTextContent(result)
-	if text != "null" {
-		t.Errorf("Expected 'null' for function, got %q", text)
+	// Functions are described by where they are defined
+	marker, ok := getRunResult(t, result).Result.(map[string]interface{})
+	if source, _ := marker["$function"].(string); !ok || !strings.Contains(source, ":") {
+		t.Errorf("Expected a $function marker, got %v", marker)
+	}
+
+	// A table that contains itself refers back to the root
+	result, err = callHandleRun(s, "local t = {} t.self = t return t")
+	if err != nil || result.IsError {
+		t.Fatalf("handleRun failed: %v %v", err, result.Content)
+	}
+	obj, _ := getRunResult(t, result).Result.(map[string]interface{})
+	if self, _ := obj["self"].(map[string]interface{}); self["$ref"] != "#" {
+		t.Errorf("Expected a $ref marker, got %v", obj)
 	}
 }
 
@@ -577,9 +697,8 @@ func TestRunMCPGlobalAccess(t *testing.T) {
 	}
 
 	// mcp.type should be "MCP"
-	text := getTextContent(result)
-	if !contains(text, "MCP") {
-		t.Errorf("Expected mcp.type to be 'MCP', got %q", text)
+	if run := getRunResult(t, result); run.Result != "MCP" {
+		t.Errorf("Expected mcp.type to be 'MCP', got %v", run.Result)
 	}
 }
 
@@ -602,6 +721,145 @@ func TestRunNoActiveSession(t *testing.T) {
 	}
 }
 
+// TestRunTimeout tests that a runaway loop is stopped at the timeout and the session stays usable
+func TestRunTimeout(t *testing.T) {
+	s, cleanup := createTestServerWithSession(t)
+	defer cleanup()
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"code": "while true do end", "timeout": 0.2}
+	start := time.Now()
+	result, err := s.handleRun(context.Background(), request)
+	if err != nil {
+		t.Fatalf("handleRun returned error: %v", err)
+	}
+	if run := getRunResult(t, result); !result.IsError || !contains(run.Error, "timed out after 200ms") {
+		t.Errorf("Expected a timeout error, got %q", getTextContent(result))
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Timeout took %v", elapsed)
+	}
+
+	// The executor is free again
+	result, err = callHandleRun(s, "return 1 + 1")
+	if err != nil || result.IsError || getRunResult(t, result).Result != float64(2) {
+		t.Errorf("Expected the session to run code after a timeout, got %q, %v", getTextContent(result), err)
+	}
+}
+
+// TestRunCancelled tests that cancelling the request stops execution
+func TestRunCancelled(t *testing.T) {
+	s, cleanup := createTestServerWithSession(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"code": "while true do end"}
+	result, err := s.handleRun(ctx, request)
+	if err != nil {
+		t.Fatalf("handleRun returned error: %v", err)
+	}
+	if run := getRunResult(t, result); !result.IsError || !contains(run.Error, "cancelled") {
+		t.Errorf("Expected a cancellation error, got %q", getTextContent(result))
+	}
+}
+
+// TestRunCapturesOutput tests that printed output is returned with the result
+func TestRunCapturesOutput(t *testing.T) {
+	s, cleanup := createTestServerWithSession(t)
+	defer cleanup()
+
+	result, err := callHandleRun(s, `print("hello", 1) io.write("no newline") return "done"`)
+	if err != nil {
+		t.Fatalf("handleRun returned error: %v", err)
+	}
+	run := getRunResult(t, result)
+	if result.IsError || run.Result != "done" || run.Stdout != "hello\t1\nno newline" {
+		t.Errorf("Expected result and captured output, got %+v", run)
+	}
+
+	// The next call starts with empty output
+	result, _ = callHandleRun(s, "return 1")
+	if run := getRunResult(t, result); run.Stdout != "" {
+		t.Errorf("Expected no output from the next call, got %q", run.Stdout)
+	}
+}
+
+// TestRunErrorTraceback tests that a Lua error returns its message, traceback and the output before it
+func TestRunErrorTraceback(t *testing.T) {
+	s, cleanup := createTestServerWithSession(t)
+	defer cleanup()
+
+	result, err := callHandleRun(s, "print('starting')\nlocal function fail() error('boom') end\nfail()")
+	if err != nil {
+		t.Fatalf("handleRun returned error: %v", err)
+	}
+	run := getRunResult(t, result)
+	if !result.IsError || !contains(run.Error, "boom") || !contains(run.Traceback, "stack traceback") {
+		t.Errorf("Expected an error with a traceback, got %+v", run)
+	}
+	if run.Stdout != "starting\n" {
+		t.Errorf("Expected output before the error, got %q", run.Stdout)
+	}
+}
+
+// TestRunTimeoutArgument tests parsing of the timeout argument
+func TestRunTimeoutArgument(t *testing.T) {
+	tests := []struct {
+		arg  interface{}
+		want time.Duration
+		ok   bool
+	}{
+		{nil, defaultRunTimeout, true},
+		{"", defaultRunTimeout, true},
+		{float64(5), 5 * time.Second, true},
+		{"0.5", 500 * time.Millisecond, true},
+		{float64(3600), maxRunTimeout, true},
+		{float64(0), 0, false},
+		{"soon", 0, false},
+		{true, 0, false},
+	}
+	for _, tt := range tests {
+		got, err := runTimeout(tt.arg)
+		if (err == nil) != tt.ok || (tt.ok && got != tt.want) {
+			t.Errorf("runTimeout(%v) = %v, %v; want %v (ok=%v)", tt.arg, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestLuaErrorParts tests splitting a Lua error into message and traceback
+func TestLuaErrorParts(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	err := L.DoString("local function inner() error('boom') end\ninner()")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	message, traceback := luaErrorParts(err)
+	if !contains(message, "boom") || contains(message, "stack traceback") {
+		t.Errorf("message = %q", message)
+	}
+	if !contains(traceback, "stack traceback") || !contains(traceback, "inner") {
+		t.Errorf("traceback = %q", traceback)
+	}
+
+	message, traceback = luaErrorParts(fmt.Errorf("plain failure"))
+	if message != "plain failure" || traceback != "" {
+		t.Errorf("plain error split into %q, %q", message, traceback)
+	}
+}
+
+// getRunResult decodes the structured ui_run result
+func getRunResult(t *testing.T, result *mcp.CallToolResult) RunResult {
+	t.Helper()
+	var run RunResult
+	if err := json.Unmarshal([]byte(getTextContent(result)), &run); err != nil {
+		t.Fatalf("Expected a JSON run result, got %q: %v", getTextContent(result), err)
+	}
+	return run
+}
+
 // getTextContent extracts text content from a tool result
 func getTextContent(result *mcp.CallToolResult) string {
 	if len(result.Content) == 0 {
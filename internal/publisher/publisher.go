@@ -24,15 +24,25 @@ const (
 
 // Publisher is a topic-based pub/sub HTTP server.
 type Publisher struct {
-	addr   string
-	topics map[string]*topic
-	mu     sync.Mutex
+	addr    string
+	topics  map[string]*topic
+	mu      sync.Mutex
+	hosting bool // Bound to addr and serving
 }
 
 type topic struct {
 	mu          sync.Mutex
 	subscribers []chan json.RawMessage
 	favicon     string // data URL, set by subscribers via query param
+	published   int    // Messages published to the topic
+	delivered   int    // Messages sent to subscribers
+}
+
+// TopicStats counts a topic's waiting subscribers and its traffic since the publisher started.
+type TopicStats struct {
+	Subscribers int
+	Published   int
+	Delivered   int
 }
 
 // New creates a Publisher bound to the given address.
@@ -63,9 +73,37 @@ func (p *Publisher) ListenAndServe() error {
 	}
 
 	log.Printf("Publisher listening on %s", p.addr)
+	p.mu.Lock()
+	p.hosting = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.hosting = false
+		p.mu.Unlock()
+	}()
 	return srv.Serve(ln)
 }
 
+// Hosting reports whether this Publisher holds its port and is serving.
+func (p *Publisher) Hosting() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hosting
+}
+
+// Stats returns each topic's subscriber and message counts.
+func (p *Publisher) Stats() map[string]TopicStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make(map[string]TopicStats, len(p.topics))
+	for name, t := range p.topics {
+		t.mu.Lock()
+		stats[name] = TopicStats{Subscribers: len(t.subscribers), Published: t.published, Delivered: t.delivered}
+		t.mu.Unlock()
+	}
+	return stats
+}
+
 // handlePublish delivers a JSON body to all subscribers of a topic.
 // POST /publish/{topic}
 func (p *Publisher) handlePublish(w http.ResponseWriter, r *http.Request) {
@@ -97,6 +135,10 @@ func (p *Publisher) handlePublish(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(PublishTTL)
 		n = t.publish(body)
 	}
+	t.mu.Lock()
+	t.published++
+	t.delivered += n
+	t.mu.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Fprintf(w, `{"listeners":%d}`, n)